@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sessions_username;
+
+ALTER TABLE users DROP COLUMN IF EXISTS must_change_password;
@@ -0,0 +1,16 @@
+CREATE INDEX IF NOT EXISTS idx_audit_action ON audit_log(action, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_zone ON audit_log(zone_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_record ON audit_log(record_name text_pattern_ops, record_type);
+CREATE INDEX IF NOT EXISTS idx_audit_ip ON audit_log(ip_address text_pattern_ops);
+
+-- Free-text search on detail uses ILIKE '%...%', which only a trigram index
+-- can serve. pg_trgm needs CREATE privilege on the database (PostgreSQL 13+
+-- treats it as a trusted extension); without it search still works unindexed.
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+    CREATE INDEX IF NOT EXISTS idx_audit_detail_trgm ON audit_log USING gin (detail gin_trgm_ops);
+EXCEPTION WHEN insufficient_privilege OR undefined_file THEN
+    RAISE NOTICE 'pg_trgm unavailable, audit detail search will not be indexed';
+END
+$$;
@@ -0,0 +1,13 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS auth_method;
+
+DROP INDEX IF EXISTS idx_audit_after_state;
+DROP INDEX IF EXISTS idx_audit_before_state;
+DROP INDEX IF EXISTS idx_audit_change;
+DROP INDEX IF EXISTS idx_audit_status;
+
+ALTER TABLE audit_log DROP COLUMN IF EXISTS auth_method;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS change_id;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS error;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS status;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS after_state;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS before_state;
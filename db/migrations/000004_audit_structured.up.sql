@@ -0,0 +1,14 @@
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS before_state JSONB;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS after_state JSONB;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'success';
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS error TEXT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS change_id TEXT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS auth_method TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_status ON audit_log(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_change ON audit_log(change_id);
+-- Containment queries such as after_state @> '{"values":["192.0.2.10"]}'
+CREATE INDEX IF NOT EXISTS idx_audit_before_state ON audit_log USING gin (before_state jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_audit_after_state ON audit_log USING gin (after_state jsonb_path_ops);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_method TEXT NOT NULL DEFAULT 'local';
@@ -0,0 +1,9 @@
+-- Record sets with a routing policy share name and type and are told apart
+-- by their set identifier, so the cache key has to include it.
+ALTER TABLE dns_cache ADD COLUMN IF NOT EXISTS set_identifier TEXT NOT NULL DEFAULT '';
+ALTER TABLE dns_cache ADD COLUMN IF NOT EXISTS routing_json TEXT;
+ALTER TABLE dns_cache ADD COLUMN IF NOT EXISTS evaluate_target_health INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS idx_dns_cache_record;
+DELETE FROM dns_cache;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dns_cache_record ON dns_cache(zone_id, record_name, record_type, set_identifier);
@@ -0,0 +1,14 @@
+-- One row per audit entry and sink that has not been delivered yet. Rows are
+-- written in the same transaction as the audit entry and removed once the
+-- sink has accepted it.
+CREATE TABLE IF NOT EXISTS audit_outbox (
+    sink            TEXT      NOT NULL,
+    audit_id        INTEGER   NOT NULL REFERENCES audit_log(id) ON DELETE CASCADE,
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (sink, audit_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_outbox_audit ON audit_outbox(audit_id);
@@ -0,0 +1,3 @@
+-- Request ID of the HTTP request that produced the entry, matching the
+-- request_id in the application log and the X-Request-ID response header.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS request_id TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE audit_outbox DROP CONSTRAINT IF EXISTS audit_outbox_audit_id_fkey;
+ALTER TABLE audit_outbox ADD CONSTRAINT audit_outbox_audit_id_fkey
+    FOREIGN KEY (audit_id) REFERENCES audit_log(id) ON DELETE CASCADE;
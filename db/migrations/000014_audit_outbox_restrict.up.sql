@@ -0,0 +1,6 @@
+-- Retention must not drop deliveries that are still pending: it stops below
+-- the oldest entry in the outbox, and deleting a referenced entry fails
+-- instead of cascading.
+ALTER TABLE audit_outbox DROP CONSTRAINT IF EXISTS audit_outbox_audit_id_fkey;
+ALTER TABLE audit_outbox ADD CONSTRAINT audit_outbox_audit_id_fkey
+    FOREIGN KEY (audit_id) REFERENCES audit_log(id);
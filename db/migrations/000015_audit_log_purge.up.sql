@@ -0,0 +1,59 @@
+-- Retention deletes through audit_log_purge, which runs with the rights of
+-- the owner of audit_log. The append-only trigger now lets a DELETE through
+-- only when it runs as that owner, so another role can no longer bypass it
+-- by setting ns116.audit_allow_delete in its own session.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF OLD.hash IS NULL AND
+           (to_jsonb(NEW) - 'hash' - 'prev_hash') = (to_jsonb(OLD) - 'hash' - 'prev_hash') THEN
+            RETURN NEW;
+        END IF;
+    ELSIF TG_OP = 'DELETE' THEN
+        IF current_setting('ns116.audit_allow_delete', true) = 'on' AND
+           current_user = (SELECT pg_get_userbyid(relowner) FROM pg_class WHERE oid = TG_RELID) THEN
+            RETURN OLD;
+        END IF;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only: % is not allowed', TG_OP
+        USING ERRCODE = 'insufficient_privilege';
+END;
+$$ LANGUAGE plpgsql;
+
+-- audit_log_purge deletes the entries up to and including to_id and returns
+-- how many it deleted. The caller archives them and records the retention
+-- anchor in the same transaction.
+CREATE OR REPLACE FUNCTION audit_log_purge(to_id INTEGER) RETURNS BIGINT
+    SECURITY DEFINER
+    SET search_path FROM CURRENT
+AS $$
+DECLARE
+    deleted BIGINT;
+BEGIN
+    PERFORM set_config('ns116.audit_allow_delete', 'on', true);
+    DELETE FROM audit_log WHERE id <= to_id;
+    GET DIAGNOSTICS deleted = ROW_COUNT;
+    PERFORM set_config('ns116.audit_allow_delete', 'off', true);
+    RETURN deleted;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Functions are executable by PUBLIC by default; the role NS116 runs as
+-- has to be granted EXECUTE explicitly
+REVOKE ALL ON FUNCTION audit_log_purge(INTEGER) FROM PUBLIC;
+
+-- No role but the owner needs to change or remove audit entries any more
+DO $$
+DECLARE
+    grantee TEXT;
+BEGIN
+    FOR grantee IN
+        SELECT DISTINCT CASE WHEN a.grantee = 0 THEN 'PUBLIC' ELSE quote_ident(pg_get_userbyid(a.grantee)) END
+        FROM pg_class c, aclexplode(c.relacl) a
+        WHERE c.oid = 'audit_log'::regclass AND a.grantee <> c.relowner
+          AND a.privilege_type IN ('UPDATE', 'DELETE', 'TRUNCATE')
+    LOOP
+        EXECUTE format('REVOKE UPDATE, DELETE, TRUNCATE ON audit_log FROM %s', grantee);
+    END LOOP;
+END;
+$$;
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+const (
+	dispatchInterval = time.Second
+	dispatchBatch    = 100
+)
+
+// Dispatcher drains the audit outbox into the sinks. Each sink is delivered
+// to in entry order; a failing sink backs off without holding up the others.
+type Dispatcher struct {
+	db    *database.DB
+	sinks []Sink
+}
+
+func NewDispatcher(db *database.DB, sinks []Sink) *Dispatcher {
+	return &Dispatcher{db: db, sinks: sinks}
+}
+
+// Run delivers pending entries until ctx is cancelled, makes a last pass to
+// ship entries written since, and closes the sinks.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+	defer func() {
+		for _, s := range d.sinks {
+			if err := s.Close(); err != nil {
+				slog.Warn("closing audit sink failed", "sink", s.Name(), "err", err)
+			}
+		}
+	}()
+
+	work := context.WithoutCancel(ctx)
+	for {
+		for _, s := range d.sinks {
+			d.drain(work, s)
+		}
+		select {
+		case <-ctx.Done():
+			for _, s := range d.sinks {
+				d.drain(work, s)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) drain(ctx context.Context, s Sink) {
+	for ctx.Err() == nil {
+		n, err := d.db.ProcessAuditOutbox(ctx, s.Name(), dispatchBatch, func(e model.AuditEntry) error {
+			return s.Send(ctx, e)
+		})
+		if err != nil {
+			slog.Warn("audit sink delivery failed", "sink", s.Name(), "err", err)
+			return
+		}
+		if n < dispatchBatch {
+			return
+		}
+	}
+}
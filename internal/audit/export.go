@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+// CheckpointKey returns the HMAC key that signs audit checkpoints. Without a
+// configured key the session secret is used, which only protects against
+// someone who can modify audit_log but not read settings.
+func CheckpointKey(ctx context.Context, cfg config.AuditConfig, db *database.DB) ([]byte, error) {
+	if cfg.CheckpointKey != "" {
+		return []byte(cfg.CheckpointKey), nil
+	}
+	secret, err := db.EnsureSessionSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(secret), nil
+}
+
+// FilterParams are the query parameters ParseFilter reads.
+var FilterParams = []string{"user", "action", "zone", "record", "type", "ip", "from", "to", "q", "status", "value"}
+
+// ParseFilter reads an audit filter from query parameters. Dates are
+// YYYY-MM-DD in UTC and "to" is inclusive of the whole day.
+func ParseFilter(q url.Values) (model.AuditFilter, error) {
+	f := model.AuditFilter{
+		Username:   strings.TrimSpace(q.Get("user")),
+		Action:     strings.TrimSpace(q.Get("action")),
+		ZoneID:     strings.TrimSpace(q.Get("zone")),
+		RecordName: strings.TrimSpace(q.Get("record")),
+		RecordType: strings.TrimSpace(q.Get("type")),
+		IPAddress:  strings.TrimSpace(q.Get("ip")),
+		Search:     strings.TrimSpace(q.Get("q")),
+		Status:     strings.TrimSpace(q.Get("status")),
+		Value:      strings.TrimSpace(q.Get("value")),
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", v)
+		}
+		f.From = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", v)
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+// ExportFormats are the formats an Exporter writes.
+var ExportFormats = []string{"csv", "jsonl"}
+
+// Exporter writes audit entries as CSV with a header row or as JSON Lines.
+type Exporter struct {
+	write func(model.AuditEntry) error
+	flush func() error
+}
+
+func NewExporter(w io.Writer, format string) (*Exporter, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "created_at", "username", "action", "zone_id", "zone_name",
+			"record_name", "record_type", "detail", "ip_address",
+			"status", "error", "change_id", "auth_method", "request_id", "cert_fingerprint", "before", "after", "prev_hash", "hash"})
+		return &Exporter{
+			write: func(e model.AuditEntry) error {
+				return cw.Write([]string{strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339),
+					e.Username, e.Action, e.ZoneID, e.ZoneName, e.RecordName, e.RecordType, e.Detail, e.IPAddress,
+					e.Status, e.Error, e.ChangeID, e.AuthMethod, e.RequestID, e.CertFingerprint, string(e.Before), string(e.After), e.PrevHash, e.Hash})
+			},
+			flush: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}, nil
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		return &Exporter{
+			write: func(e model.AuditEntry) error { return enc.Encode(e) },
+			flush: func() error { return nil },
+		}, nil
+	}
+	return nil, fmt.Errorf("format must be csv or jsonl")
+}
+
+func (e *Exporter) Write(entry model.AuditEntry) error {
+	return e.write(entry)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (e *Exporter) Flush() error {
+	return e.flush()
+}
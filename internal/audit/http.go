@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"ns116/internal/config"
+	"ns116/internal/model"
+)
+
+// HTTPSink POSTs each entry as a JSON document to a collector. Any 2xx
+// response counts as delivered.
+type HTTPSink struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewHTTPSink(c config.AuditSinkConfig) (*HTTPSink, error) {
+	return &HTTPSink{
+		name:    c.Name,
+		url:     c.URL,
+		headers: c.Headers,
+		client:  &http.Client{Timeout: c.Timeout},
+	}, nil
+}
+
+func (s *HTTPSink) Name() string { return s.name }
+
+func (s *HTTPSink) Send(ctx context.Context, e model.AuditEntry) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
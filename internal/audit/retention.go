@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+const retentionInterval = 24 * time.Hour
+
+// ArchiveFile is one compressed archive in the archive directory.
+type ArchiveFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// HumanSize formats the file size for display, e.g. "1.2 MB".
+func (f ArchiveFile) HumanSize() string {
+	const unit = 1024
+	if f.Size < unit {
+		return fmt.Sprintf("%d B", f.Size)
+	}
+	div, exp := int64(unit), 0
+	for n := f.Size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(f.Size)/float64(div), "KMGTPE"[exp])
+}
+
+// Retention moves audit entries older than the retention period into
+// gzip-compressed JSON Lines archives and deletes them from the database.
+// Archived entries keep their hashes so they can be verified offline.
+type Retention struct {
+	db   *database.DB
+	days int
+	dir  string
+	key  []byte
+
+	mu sync.Mutex // one pass at a time
+}
+
+func NewRetention(db *database.DB, cfg config.AuditConfig, key []byte) *Retention {
+	return &Retention{db: db, days: cfg.RetentionDays, dir: cfg.ArchiveDir, key: key}
+}
+
+func (r *Retention) Enabled() bool { return r.days > 0 }
+func (r *Retention) Days() int     { return r.days }
+func (r *Retention) Dir() string   { return r.dir }
+
+// Loop runs a retention pass at startup and then daily until ctx is done. A
+// pass in progress when that happens is finished.
+func (r *Retention) Loop(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	work := context.WithoutCancel(ctx)
+	for {
+		if run, err := r.Run(work, model.AuditEntry{Username: "system"}); err != nil {
+			slog.Error("audit retention failed", "err", err)
+		} else if run.Archived > 0 {
+			slog.Info("audit retention archived entries", "count", run.Archived, "file", run.File)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run archives and deletes every entry older than the retention period. The
+// archive is fully written and synced before anything is deleted. The
+// outcome is stored for the status page and audited on behalf of actor.
+func (r *Retention) Run(ctx context.Context, actor model.AuditEntry) (model.AuditRetentionRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run := model.AuditRetentionRun{StartedAt: time.Now().UTC()}
+	if !r.Enabled() {
+		return run, fmt.Errorf("audit retention is not enabled")
+	}
+
+	err := r.archive(ctx, &run)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if b, mErr := json.Marshal(run); mErr == nil {
+		if err := r.db.SetSetting(ctx, "audit_retention_last_run", string(b)); err != nil {
+			slog.ErrorContext(ctx, "saving audit retention status failed", "err", err)
+		}
+	}
+	if err != nil || run.Archived > 0 {
+		entry := actor
+		entry.Action = "audit_retention"
+		entry.Detail = fmt.Sprintf("archived=%d first_id=%d last_id=%d file=%s",
+			run.Archived, run.FirstID, run.LastID, run.File)
+		entry.Status = model.AuditSuccess
+		if err != nil {
+			entry.Status, entry.Error = model.AuditFailure, err.Error()
+		}
+		if err := r.db.LogAudit(ctx, entry); err != nil {
+			slog.ErrorContext(ctx, "writing audit entry failed", "action", entry.Action, "err", err)
+		}
+	}
+	return run, err
+}
+
+func (r *Retention) archive(ctx context.Context, run *model.AuditRetentionRun) error {
+	toID, err := r.db.AuditArchiveBound(ctx, r.days)
+	if err != nil || toID == 0 {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(r.dir, ".audit-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(gz)
+	err = r.db.StreamAuditRange(ctx, toID, func(e model.AuditEntry) error {
+		if run.FirstID == 0 {
+			run.FirstID = e.ID
+		}
+		run.LastID = e.ID
+		run.Archived++
+		return enc.Encode(e)
+	})
+	if err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	run.File = filepath.Join(r.dir, fmt.Sprintf("audit-%010d-%010d.jsonl.gz", run.FirstID, run.LastID))
+	if err := os.Rename(tmp.Name(), run.File); err != nil {
+		return err
+	}
+
+	deleted, err := r.db.PurgeAuditLog(ctx, toID, r.key)
+	if err != nil {
+		return fmt.Errorf("archived to %s but deleting failed: %w", run.File, err)
+	}
+	if deleted != run.Archived {
+		slog.WarnContext(ctx, "audit retention deleted a different number of entries than it archived",
+			"archived", run.Archived, "deleted", deleted)
+	}
+	return nil
+}
+
+// LastRun returns the outcome of the most recent pass, or nil if retention
+// has never run.
+func (r *Retention) LastRun(ctx context.Context) (*model.AuditRetentionRun, error) {
+	v, err := r.db.GetSetting(ctx, "audit_retention_last_run")
+	if err != nil || v == "" {
+		return nil, err
+	}
+	var run model.AuditRetentionRun
+	if err := json.Unmarshal([]byte(v), &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Archives lists the archive files, newest first.
+func (r *Retention) Archives() ([]ArchiveFile, error) {
+	if r.dir == "" {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(r.dir, "audit-*.jsonl.gz"))
+	if err != nil {
+		return nil, err
+	}
+	var files []ArchiveFile
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		files = append(files, ArchiveFile{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name > files[j].Name })
+	return files, nil
+}
@@ -0,0 +1,47 @@
+// Package audit moves audit entries out of the database: it ships them from
+// the outbox to external sinks such as syslog, JSON Lines files and HTTP
+// collectors, and archives entries past their retention period.
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"ns116/internal/config"
+	"ns116/internal/model"
+)
+
+// Sink is a destination for audit entries. Send must return an error unless
+// the entry has been durably accepted; it is then retried later.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, e model.AuditEntry) error
+	Close() error
+}
+
+// NewSinks builds the sinks described by the configuration.
+func NewSinks(cfgs []config.AuditSinkConfig) ([]Sink, error) {
+	var sinks []Sink
+	for _, c := range cfgs {
+		var s Sink
+		var err error
+		switch c.Type {
+		case "syslog":
+			s, err = NewSyslogSink(c)
+		case "file":
+			s, err = NewFileSink(c)
+		case "http":
+			s, err = NewHTTPSink(c)
+		default:
+			err = fmt.Errorf("unknown type %q", c.Type)
+		}
+		if err != nil {
+			for _, prev := range sinks {
+				prev.Close()
+			}
+			return nil, fmt.Errorf("audit sink %q: %w", c.Name, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ns116/internal/config"
+	"ns116/internal/model"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+const (
+	severityWarning = 4
+	severityNotice  = 5
+)
+
+// SyslogSink sends entries as RFC 5424 messages with the JSON entry as the
+// message body. TCP and TLS use octet-counting framing (RFC 6587).
+type SyslogSink struct {
+	name     string
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+	tlsConf  *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewSyslogSink(c config.AuditSinkConfig) (*SyslogSink, error) {
+	facility, ok := syslogFacilities[c.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", c.Facility)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	s := &SyslogSink{
+		name:     c.Name,
+		network:  c.Network,
+		address:  c.Address,
+		facility: facility,
+		appName:  c.AppName,
+		hostname: hostname,
+	}
+	if c.Network == "tls" {
+		host, _, _ := net.SplitHostPort(c.Address)
+		s.tlsConf = &tls.Config{ServerName: host, InsecureSkipVerify: c.SkipVerify, MinVersion: tls.VersionTLS12}
+		if c.CAFile != "" {
+			pem, err := os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+			}
+			s.tlsConf.RootCAs = pool
+		}
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) Name() string { return s.name }
+
+func (s *SyslogSink) Send(ctx context.Context, e model.AuditEntry) error {
+	msg, err := s.format(e)
+	if err != nil {
+		return err
+	}
+	if s.network != "udp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if s.conn, err = s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.conn.Write(msg); err != nil {
+		// Reconnect on the next attempt
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	switch s.network {
+	case "tls":
+		td := &tls.Dialer{NetDialer: d, Config: s.tlsConf}
+		return td.DialContext(ctx, "tcp", s.address)
+	default:
+		return d.DialContext(ctx, s.network, s.address)
+	}
+}
+
+// format renders the RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *SyslogSink) format(e model.AuditEntry) ([]byte, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	severity := severityNotice
+	if e.Status == model.AuditFailure {
+		severity = severityWarning
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		s.facility*8+severity,
+		e.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogField(s.hostname, 255), syslogField(s.appName, 48), os.Getpid(),
+		syslogField(e.Action, 32))
+	return append([]byte(header), body...), nil
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogField restricts a header field to printable US-ASCII without spaces
+// and to the maximum length RFC 5424 allows.
+func syslogField(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, v)
+	if v == "" {
+		return "-"
+	}
+	if len(v) > max {
+		v = v[:max]
+	}
+	return v
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ns116/internal/config"
+	"ns116/internal/model"
+)
+
+// readFrame reads one octet-counted message (RFC 6587): "LEN SP MSG".
+func readFrame(r *bufio.Reader) (string, error) {
+	n, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(n, " "))
+	if err != nil {
+		return "", fmt.Errorf("bad frame length %q", n)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func TestSyslogSinkTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	frames := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			f, err := readFrame(r)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- f
+		}
+	}()
+
+	s, err := NewSyslogSink(config.AuditSinkConfig{
+		Name: "siem", Type: "syslog", Network: "tcp", Address: ln.Addr().String(),
+		Facility: "local0", AppName: "ns116",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	entries := []model.AuditEntry{
+		// A multi-line detail must not split the message
+		{ID: 1, Username: "alice", Action: "create_record", Detail: "line one\nline two", Status: model.AuditSuccess, CreatedAt: created},
+		{ID: 2, Username: "bob", Action: "login failed", Status: model.AuditFailure, Error: "bad password", CreatedAt: created},
+	}
+	for _, e := range entries {
+		if err := s.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	wantHeaders := []string{
+		"<133>1 2026-03-01T12:30:00.000000Z ",
+		"<132>1 2026-03-01T12:30:00.000000Z ",
+	}
+	wantMsgIDs := []string{"create_record", "login_failed"}
+	for i, want := range entries {
+		var f string
+		select {
+		case f = <-frames:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("frame %d not received", i)
+		}
+		if !strings.HasPrefix(f, wantHeaders[i]) {
+			t.Errorf("frame %d = %q, want prefix %q", i, f, wantHeaders[i])
+		}
+		fields := strings.SplitN(f, " ", 8)
+		if len(fields) != 8 {
+			t.Fatalf("frame %d has %d fields: %q", i, len(fields), f)
+		}
+		if fields[3] != "ns116" || fields[5] != wantMsgIDs[i] || fields[6] != "-" {
+			t.Errorf("frame %d header fields = %q", i, fields[:7])
+		}
+		var got model.AuditEntry
+		if err := json.Unmarshal([]byte(fields[7]), &got); err != nil {
+			t.Fatalf("frame %d body: %v", i, err)
+		}
+		if got.ID != want.ID || got.Detail != want.Detail || got.Error != want.Error {
+			t.Errorf("frame %d body = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewSyslogSink(config.AuditSinkConfig{
+		Name: "siem", Type: "syslog", Network: "udp", Address: pc.LocalAddr().String(), Facility: "auth",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(context.Background(), model.AuditEntry{ID: 7, Action: "login", Status: model.AuditSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64*1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One datagram per message, without a length prefix
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<37>1 ") || !strings.Contains(msg, `"id":7`) {
+		t.Errorf("datagram = %q", msg)
+	}
+}
@@ -1,77 +1,137 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"ns116/internal/database"
+	"ns116/internal/model"
 )
 
 const (
-	cookieName    = "ns116_session"
-	sessionMaxAge = 24 * time.Hour
+	cookieName = "ns116_session"
+	// secureCookieName is used over HTTPS. Browsers only accept a __Host-
+	// cookie when it is Secure, host-only and scoped to "/", so it cannot be
+	// planted by a sibling subdomain or over plain HTTP.
+	secureCookieName = "__Host-ns116_session"
+	sessionMaxAge    = 24 * time.Hour
+
+	// PasswordChangePath is the only page a user with a pending forced
+	// password reset may reach (besides logging out).
+	PasswordChangePath = "/account/password"
 )
 
 type SessionManager struct {
 	secret string
 	db     *database.DB
+	// secure marks the session cookie Secure and gives it the __Host- prefix
+	secure bool
 }
 
-func NewSessionManager(db *database.DB) (*SessionManager, error) {
-	secret, err := db.EnsureSessionSecret()
+// NewSessionManager loads the session signing secret. secure must be set
+// when clients reach NS116 over HTTPS, directly or through a proxy.
+func NewSessionManager(ctx context.Context, db *database.DB, secure bool) (*SessionManager, error) {
+	secret, err := db.EnsureSessionSecret(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load session secret: %w", err)
 	}
-	return &SessionManager{secret: secret, db: db}, nil
+	return &SessionManager{secret: secret, db: db, secure: secure}, nil
 }
 
-func (sm *SessionManager) CreateSession(w http.ResponseWriter, username string) string {
+func (sm *SessionManager) sessionCookieName() string {
+	if sm.secure {
+		return secureCookieName
+	}
+	return cookieName
+}
+
+func (sm *SessionManager) CreateSession(ctx context.Context, w http.ResponseWriter, username, authMethod string) (string, error) {
 	token := generateToken()
 	csrfToken := generateToken()
 	signed := sm.sign(token)
 	expiresAt := time.Now().Add(sessionMaxAge)
 
-	_ = sm.db.CreateSession(signed, csrfToken, username, expiresAt)
+	if err := sm.db.CreateSession(ctx, signed, csrfToken, username, authMethod, expiresAt); err != nil {
+		return "", err
+	}
 
 	http.SetCookie(w, &http.Cookie{
-		Name:     cookieName,
+		Name:     sm.sessionCookieName(),
 		Value:    signed,
 		Path:     "/",
 		HttpOnly: true,
+		Secure:   sm.secure,
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   int(sessionMaxAge.Seconds()),
 	})
-	return csrfToken
+	return csrfToken, nil
+}
+
+// ReplaceSession ends the session attached to r, if any, and starts a new
+// one for username with a single cookie update.
+func (sm *SessionManager) ReplaceSession(w http.ResponseWriter, r *http.Request, username, authMethod string) (string, error) {
+	if cookie, err := r.Cookie(sm.sessionCookieName()); err == nil {
+		if err := sm.db.DeleteSession(r.Context(), cookie.Value); err != nil {
+			slog.ErrorContext(r.Context(), "deleting session failed", "err", err)
+		}
+	}
+	return sm.CreateSession(r.Context(), w, username, authMethod)
 }
 
 func (sm *SessionManager) DestroySession(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie(cookieName)
+	cookie, err := r.Cookie(sm.sessionCookieName())
 	if err == nil {
-		_ = sm.db.DeleteSession(cookie.Value)
+		if err := sm.db.DeleteSession(r.Context(), cookie.Value); err != nil {
+			slog.ErrorContext(r.Context(), "deleting session failed", "err", err)
+		}
 	}
 	http.SetCookie(w, &http.Cookie{
-		Name:   cookieName,
+		Name:   sm.sessionCookieName(),
 		Value:  "",
 		Path:   "/",
+		Secure: sm.secure,
 		MaxAge: -1,
 	})
 }
 
-func (sm *SessionManager) GetSessionInfo(r *http.Request) (string, string, bool) {
-	cookie, err := r.Cookie(cookieName)
+// Session returns the unexpired session attached to r, if any.
+func (sm *SessionManager) Session(r *http.Request) (*model.Session, bool) {
+	if s, ok := r.Context().Value(sessionKey{}).(*model.Session); ok {
+		return s, true
+	}
+	cookie, err := r.Cookie(sm.sessionCookieName())
 	if err != nil {
-		return "", "", false
+		return nil, false
+	}
+	s, err := sm.db.GetSession(r.Context(), cookie.Value)
+	if err != nil || s == nil || time.Now().After(s.ExpiresAt) {
+		return nil, false
 	}
-	username, csrfToken, expiresAt, err := sm.db.GetSession(cookie.Value)
-	if err != nil || username == "" || time.Now().After(expiresAt) {
+	return s, true
+}
+
+func (sm *SessionManager) GetSessionInfo(r *http.Request) (string, string, bool) {
+	s, ok := sm.Session(r)
+	if !ok {
 		return "", "", false
 	}
-	return username, csrfToken, true
+	return s.Username, s.CSRFToken, true
+}
+
+// AuthMethod reports how the current session was authenticated ("local",
+// "ldap", "proxy" or "cert"), for audit entries.
+func (sm *SessionManager) AuthMethod(r *http.Request) string {
+	if s, ok := sm.Session(r); ok {
+		return s.AuthMethod
+	}
+	return ""
 }
 
 func (sm *SessionManager) GetUsername(r *http.Request) (string, bool) {
@@ -102,10 +162,41 @@ func (sm *SessionManager) ValidateCSRF(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// InvalidateOtherSessions logs the user out of every session except the one
+// attached to the current request.
+func (sm *SessionManager) InvalidateOtherSessions(r *http.Request, username string) error {
+	cookie, err := r.Cookie(sm.sessionCookieName())
+	if err != nil {
+		return sm.db.DeleteUserSessions(r.Context(), username)
+	}
+	return sm.db.DeleteUserSessionsExcept(r.Context(), username, cookie.Value)
+}
+
+// activeUser resolves the session user and enforces account state: disabled
+// or deleted accounts lose their session, and users with a pending forced
+// password reset are sent to the password change page.
+func (sm *SessionManager) activeUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	username, ok := sm.GetUsername(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil, false
+	}
+	user, _ := sm.db.GetUserByUsername(r.Context(), username)
+	if user == nil || !user.Active {
+		sm.DestroySession(w, r)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil, false
+	}
+	if user.MustChangePassword && r.URL.Path != PasswordChangePath {
+		http.Redirect(w, r, PasswordChangePath, http.StatusSeeOther)
+		return nil, false
+	}
+	return user, true
+}
+
 func (sm *SessionManager) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if _, ok := sm.GetUsername(r); !ok {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if _, ok := sm.activeUser(w, r); !ok {
 			return
 		}
 		next(w, r)
@@ -114,13 +205,11 @@ func (sm *SessionManager) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 
 func (sm *SessionManager) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		username, ok := sm.GetUsername(r)
+		user, ok := sm.activeUser(w, r)
 		if !ok {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		user, _ := sm.db.GetUserByUsername(username)
-		if user == nil || user.Role != "admin" {
+		if user.Role != "admin" {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"ns116/internal/config"
+)
+
+// ClientCertificate returns the client certificate verified on the TLS
+// connection of r, or nil when the client presented none.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// CertFingerprint returns the hex SHA-256 fingerprint of the verified client
+// certificate of r, or "" when there is none.
+func CertFingerprint(r *http.Request) string {
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CertIdentity is the user a client certificate maps to.
+type CertIdentity struct {
+	Username string
+	// Role is used to provision the user; empty for existing accounts only
+	Role        string
+	Email       string
+	Fingerprint string
+}
+
+// CertMapper maps verified client certificates to users with the rules of
+// server.tls.client_certs.users. The first matching rule wins.
+type CertMapper struct {
+	users atomic.Pointer[[]config.ClientCertUser]
+}
+
+func NewCertMapper(users []config.ClientCertUser) *CertMapper {
+	m := &CertMapper{}
+	m.SetUsers(users)
+	return m
+}
+
+// SetUsers replaces the mapping rules for requests from now on.
+func (m *CertMapper) SetUsers(users []config.ClientCertUser) {
+	m.users.Store(&users)
+}
+
+// Identify returns the user the verified client certificate of r maps to.
+// Returns false when there is no certificate or no rule matches it.
+func (m *CertMapper) Identify(r *http.Request) (CertIdentity, bool) {
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return CertIdentity{}, false
+	}
+	for _, u := range *m.users.Load() {
+		if !certMatches(cert, u) {
+			continue
+		}
+		id := CertIdentity{
+			Username:    u.Username,
+			Role:        u.Role,
+			Email:       u.Email,
+			Fingerprint: CertFingerprint(r),
+		}
+		if id.Email == "" && len(cert.EmailAddresses) > 0 {
+			id.Email = cert.EmailAddresses[0]
+		}
+		return id, true
+	}
+	return CertIdentity{}, false
+}
+
+func certMatches(cert *x509.Certificate, u config.ClientCertUser) bool {
+	switch {
+	case u.CommonName != "":
+		return cert.Subject.CommonName == u.CommonName
+	case u.DNSName != "":
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, u.DNSName) {
+				return true
+			}
+		}
+	case u.Email != "":
+		for _, addr := range cert.EmailAddresses {
+			if strings.EqualFold(addr, u.Email) {
+				return true
+			}
+		}
+	case u.URI != "":
+		for _, uri := range cert.URIs {
+			if uri.String() == u.URI {
+				return true
+			}
+		}
+	}
+	return false
+}
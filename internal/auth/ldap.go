@@ -104,10 +104,16 @@ func (lc *LDAPClient) Authenticate(username, password string) (*LDAPResult, erro
 
 // ResolveRole maps LDAP groups to NS116 roles using group_mapping.
 // Returns ("", false) if the user is not in any mapped group.
-// Priority: "admin" is checked first, then "editor".
 func (lc *LDAPClient) ResolveRole(groups []string) (string, bool) {
+	return ResolveRole(lc.cfg.GroupMapping, groups)
+}
+
+// ResolveRole maps groups to an NS116 role using a role → group mapping.
+// Returns ("", false) if none of the groups is mapped.
+// Priority: "admin" is checked first, then "editor".
+func ResolveRole(mapping map[string]string, groups []string) (string, bool) {
 	// Check admin first (highest privilege wins)
-	if adminGroup, ok := lc.cfg.GroupMapping["admin"]; ok {
+	if adminGroup, ok := mapping["admin"]; ok {
 		for _, g := range groups {
 			if strings.EqualFold(g, adminGroup) {
 				return "admin", true
@@ -116,7 +122,7 @@ func (lc *LDAPClient) ResolveRole(groups []string) (string, bool) {
 	}
 
 	// Then check editor
-	if editorGroup, ok := lc.cfg.GroupMapping["editor"]; ok {
+	if editorGroup, ok := mapping["editor"]; ok {
 		for _, g := range groups {
 			if strings.EqualFold(g, editorGroup) {
 				return "editor", true
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"ns116/internal/config"
+)
+
+// ValidatePassword checks a candidate password against the configured
+// complexity policy and returns a user-facing error describing the rules
+// that are not satisfied.
+func ValidatePassword(policy config.PasswordPolicyConfig, password string) error {
+	if len([]rune(password)) < policy.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", policy.MinLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+
+	var missing []string
+	if policy.RequireUpper && !hasUpper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Password must contain %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"ns116/internal/model"
+)
+
+// apiTokenPrefix starts every API token so that tokens are recognisable in
+// credential files and by secret scanners.
+const apiTokenPrefix = "ns116_"
+
+// NewAPIToken generates a token secret and returns it with the hash to store
+// and the prefix shown to tell tokens apart.
+func NewAPIToken() (secret, hash, prefix string) {
+	secret = apiTokenPrefix + generateToken()
+	return secret, HashAPIToken(secret), secret[:len(apiTokenPrefix)+8]
+}
+
+// HashAPIToken returns the stored form of a token secret. Secrets are 256
+// random bits, so a plain SHA-256 is enough.
+func HashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// BearerToken returns the credentials of an "Authorization: Bearer" header,
+// or "" when there is none.
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type sessionKey struct{}
+
+// WithSession attaches a session established without a cookie, such as an
+// API token, to the request context. Session and everything built on it
+// return that session for the request.
+func WithSession(ctx context.Context, s *model.Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+
+	"ns116/internal/audit"
+	"ns116/internal/model"
+)
+
+func (env Env) audit(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: audit: missing subcommand", errUsage)
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "verify":
+		return env.auditVerify(ctx, args)
+	case "export":
+		return env.auditExport(ctx, args)
+	}
+	return fmt.Errorf("%w: audit: unknown subcommand %q", errUsage, sub)
+}
+
+// auditVerify checks the audit hash chain; a broken chain exits with
+// status 1.
+func (env Env) auditVerify(ctx context.Context, args []string) error {
+	if err := parse(newFlags("audit verify"), args, 0, 0); err != nil {
+		return err
+	}
+	cfg, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	key, err := audit.CheckpointKey(ctx, cfg.Audit, db)
+	if err != nil {
+		return fmt.Errorf("failed to load audit checkpoint key: %w", err)
+	}
+	res, err := db.VerifyAuditLog(ctx, key)
+	if err != nil {
+		return err
+	}
+	var broken error
+	if !res.OK() {
+		broken = fmt.Errorf("%s", res)
+	}
+	logAudit(ctx, db, model.AuditEntry{
+		Action: "verify_audit",
+		Detail: fmt.Sprintf("checked=%d last_id=%d", res.Checked, res.LastID),
+	}, broken)
+
+	fmt.Fprintln(env.Stdout, res)
+	if broken != nil {
+		return errCheckFailed
+	}
+	return nil
+}
+
+// auditExport streams the audit log, filtered like the Audit Log page, as
+// CSV or JSON Lines.
+func (env Env) auditExport(ctx context.Context, args []string) error {
+	fs := newFlags("audit export")
+	format := fs.String("format", "jsonl", "csv or jsonl")
+	output := fs.String("o", "", "write to this file instead of standard output")
+	filters := make(map[string]*string, len(audit.FilterParams))
+	for _, p := range audit.FilterParams {
+		filters[p] = fs.String(p, "", "filter by "+p)
+	}
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	q := url.Values{}
+	for p, v := range filters {
+		if *v != "" {
+			q.Set(p, *v)
+		}
+	}
+	filter, err := audit.ParseFilter(q)
+	if err != nil {
+		return fmt.Errorf("%w: audit export: %v", errUsage, err)
+	}
+	if !slices.Contains(audit.ExportFormats, *format) {
+		return fmt.Errorf("%w: audit export: format must be csv or jsonl", errUsage)
+	}
+
+	_, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = env.Stdout
+	var file *os.File
+	if *output != "" {
+		// Audit entries can be sensitive, so the file is private
+		if file, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	exp, err := audit.NewExporter(w, *format)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	err = db.StreamAuditLog(ctx, filter, func(e model.AuditEntry) error {
+		n++
+		return exp.Write(e)
+	})
+	if flushErr := exp.Flush(); err == nil {
+		err = flushErr
+	}
+	if file != nil && err == nil {
+		err = file.Close()
+	}
+	logAudit(ctx, db, model.AuditEntry{
+		Action: "export_audit",
+		Detail: fmt.Sprintf("format=%s %s rows=%d", *format, q.Encode(), n),
+	}, err)
+	return err
+}
@@ -0,0 +1,209 @@
+// Package cli implements the ns116 subcommands that administer an
+// installation from the command line: users, schema migrations, sessions,
+// the configuration, the audit log and zone files. They reuse the
+// configuration file and database of the server, so a locked-out admin can
+// recover without SQL.
+//
+// ns116 ctl is the exception: a client for the API of a running server that
+// needs only an API token.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/user"
+	"strings"
+
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/logging"
+	"ns116/internal/model"
+	"ns116/web"
+)
+
+const usage = `Usage: ns116 [-config path] [command]
+
+Without a command, ns116 serves the web UI. Commands:
+
+  user list
+  user create [-role admin|editor] [-password-stdin] <username>
+  user reset-password [-password-stdin] <username>
+  user set-role <username> <admin|editor>
+  user enable <username>
+  user disable <username>
+
+  migrate up
+  migrate down [steps]
+  migrate version
+  migrate force <version>
+
+  sessions purge [-all | -user <username>]
+
+  config check [-print]
+
+  audit verify
+  audit export [-format csv|jsonl] [-o file] [-user ...] [-action ...]
+               [-zone ...] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [...]
+
+  plan [-zone zone] [-managed-only] <zone-file> ...
+  apply [-zone zone] [-managed-only] [-m message] <zone-file> ...
+
+  ctl [-profile name] [-o table|json|yaml] [-wait] <command>
+      login [-url URL] [-ca-file file] [-cert-file file -key-file file]
+      whoami
+      zones list
+      records list [-name name] [-type type] <zone>
+      records get [-set-identifier id] <zone> <name> <type>
+      records create [-ttl seconds] [-value value ...] [-f file] <zone> <name> <type>
+      records update [-set-identifier id] [-ttl seconds] [-value value ...] [-f file]
+                     <zone> <name> <type>
+      records delete [-set-identifier id] <zone> <name> <type>
+      records export [-f file] <zone>
+      records import -f file <zone>
+      change <change-id>
+      plan [-zone zone] [-managed-only] <zone-file> ...
+      apply [-zone zone] [-managed-only] [-m message] <zone-file> ...
+
+Without -password-stdin a temporary password is generated and printed; the
+user must change it at the next login.
+
+plan compares YAML zone files with their zones and lists the record sets to
+create, update and delete; apply makes those changes. A zone file is for the
+zone its name (without .yaml) names unless -zone says otherwise.
+-managed-only keeps record sets the file does not list. -m sets the change
+comment, which is also the commit message in the zone history.
+
+ctl talks to a running server with an API token from the Account page,
+saved by ctl login (which reads the token from standard input) to
+~/.config/ns116/credentials.yaml. <zone> is a zone ID, name or label.
+`
+
+// Env holds the configuration path and standard streams of a command.
+type Env struct {
+	ConfigPath string
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// errUsage makes Run print the usage and exit with status 2.
+var errUsage = errors.New("invalid arguments")
+
+// errCheckFailed makes Run exit with status 1 after a check has already
+// reported its result.
+var errCheckFailed = errors.New("check failed")
+
+// Usage writes the list of commands to w.
+func Usage(w io.Writer) {
+	fmt.Fprint(w, usage)
+}
+
+// Run executes the command in args and returns the process exit status.
+func Run(ctx context.Context, env Env, args []string) int {
+	err := env.run(ctx, args)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errCheckFailed):
+		return 1
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(env.Stderr, "ns116: %v\n\n", err)
+		Usage(env.Stderr)
+		return 2
+	}
+	fmt.Fprintf(env.Stderr, "ns116 %s: %v\n", args[0], err)
+	return 1
+}
+
+func (env Env) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "user":
+		return env.user(ctx, args)
+	case "migrate":
+		return env.migrate(ctx, args)
+	case "sessions":
+		return env.sessions(ctx, args)
+	case "config":
+		return env.config(args)
+	case "audit":
+		return env.audit(ctx, args)
+	case "plan", "apply":
+		return env.sync(ctx, cmd, args)
+	case "ctl":
+		return env.ctl(ctx, args)
+	case "help":
+		Usage(env.Stdout)
+		return nil
+	}
+	return fmt.Errorf("%w: unknown command %q", errUsage, cmd)
+}
+
+// newFlags returns a flag set for the subcommand name whose errors are
+// reported through Run.
+func newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ns116 "+name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parse parses args into fs and checks the number of positional arguments.
+func parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %v", errUsage, fs.Name(), err)
+	}
+	if n := fs.NArg(); n < minArgs || n > maxArgs {
+		return fmt.Errorf("%w: %s: wrong number of arguments", errUsage, fs.Name())
+	}
+	return nil
+}
+
+func (env Env) loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(env.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	logging.Setup(cfg.Log)
+	return cfg, nil
+}
+
+// open loads the configuration and connects to the database. The schema is
+// not migrated and must be current.
+func (env Env) open(ctx context.Context) (*config.Config, *database.DB, error) {
+	cfg, err := env.loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := database.Open(ctx, cfg.Database.DSN, web.MigrationsFS(), false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// Changes made here reach the sinks and the zone history through the
+	// server's dispatcher
+	db.SetAuditSinks(cfg.OutboxSinks())
+	return cfg, db, nil
+}
+
+// logAudit records a command with its outcome. Commands act as the system
+// user with auth method "cli"; the operating system user is noted in the
+// detail.
+func logAudit(ctx context.Context, db *database.DB, entry model.AuditEntry, err error) {
+	entry.Username, entry.AuthMethod = "system", "cli"
+	if u, uerr := user.Current(); uerr == nil {
+		entry.Detail = strings.TrimSpace(entry.Detail + " os_user=" + u.Username)
+	}
+	entry.Status = model.AuditSuccess
+	if err != nil {
+		entry.Status, entry.Error = model.AuditFailure, err.Error()
+	}
+	if err := db.LogAudit(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "writing audit entry failed", "action", entry.Action, "err", err)
+	}
+}
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ns116/internal/model"
+)
+
+// credentials is the file ns116 ctl keeps its servers and tokens in, by
+// default ~/.config/ns116/credentials.yaml.
+type credentials struct {
+	// Default names the profile used without -profile
+	Default  string                `yaml:"default,omitempty"`
+	Profiles map[string]ctlProfile `yaml:"profiles"`
+}
+
+type ctlProfile struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// CAFile verifies servers with an internal certificate authority
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertFile and KeyFile are presented to servers that require client
+	// certificates
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+}
+
+const defaultProfile = "default"
+
+// credentialsPath returns the credentials file location, which
+// NS116_CTL_CREDENTIALS overrides.
+func credentialsPath() (string, error) {
+	if p := os.Getenv("NS116_CTL_CREDENTIALS"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the credentials file: %w", err)
+	}
+	return filepath.Join(dir, "ns116", "credentials.yaml"), nil
+}
+
+// loadCredentials reads the credentials file. A missing file yields no
+// profiles.
+func loadCredentials(path string) (*credentials, error) {
+	creds := &credentials{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return creds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return creds, nil
+}
+
+// save writes the file readable by its owner only, as it holds tokens.
+func (c *credentials) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// profileName picks the profile from the -profile flag, NS116_CTL_PROFILE or
+// the file's default, in that order.
+func (c *credentials) profileName(flagValue string) string {
+	for _, name := range []string{flagValue, os.Getenv("NS116_CTL_PROFILE"), c.Default} {
+		if name != "" {
+			return name
+		}
+	}
+	return defaultProfile
+}
+
+// apiClient calls the JSON API of an NS116 server.
+type apiClient struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+func newAPIClient(p ctlProfile) (*apiClient, error) {
+	base, err := url.Parse(strings.TrimSuffix(p.URL, "/"))
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" {
+		return nil, fmt.Errorf("server URL %q must be an absolute http(s) URL", p.URL)
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if p.CAFile != "" {
+		pem, err := os.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", p.CAFile)
+		}
+	}
+	if p.CertFile != "" || p.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &apiClient{
+		base:  base,
+		token: p.Token,
+		http:  &http.Client{Transport: transport, Timeout: 2 * time.Minute},
+	}, nil
+}
+
+// do sends body, if not nil, as JSON and decodes the response into out, if
+// not nil. Path segments must already be escaped.
+func (c *apiClient) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	u := c.base.JoinPath("api/v1", path)
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr model.APIError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("%s; create a token on the Account page and run ns116 ctl login", apiErr.Error)
+		}
+		return errors.New(apiErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", c.base.Host, err)
+	}
+	return nil
+}
+
+// pathEscape joins the parts into a path, escaping each of them.
+func pathEscape(parts ...string) string {
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"fmt"
+
+	"ns116/internal/config"
+)
+
+// config validates the configuration without touching the database, e.g.
+// before a reload.
+func (env Env) config(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("%w: config: expected check", errUsage)
+	}
+	fs := newFlags("config check")
+	show := fs.Bool("print", false, "list the effective settings, secrets redacted")
+	if err := parse(fs, args[1:], 0, 0); err != nil {
+		return err
+	}
+	cfg, err := config.Load(env.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if *show {
+		for _, a := range cfg.Summary() {
+			fmt.Fprintf(env.Stdout, "%s = %s\n", a.Key, a.Value)
+		}
+	}
+	source := env.ConfigPath
+	if source == "" {
+		source = "environment"
+	}
+	fmt.Fprintf(env.Stdout, "Configuration OK (%s)\n", source)
+	return nil
+}
@@ -0,0 +1,717 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ns116/internal/model"
+	"ns116/internal/zonesync"
+)
+
+// outputFormats are the values of ns116 ctl -o.
+var outputFormats = []string{"table", "json", "yaml"}
+
+// changePollInterval is how often -wait asks whether a change is in sync.
+const changePollInterval = 5 * time.Second
+
+// ctlCmd runs one ns116 ctl command against the server of a credentials
+// profile.
+type ctlCmd struct {
+	Env
+	profile string
+	format  string
+	wait    bool
+	api     *apiClient
+}
+
+// ctl is the client mode: it manages zones on a running NS116 server
+// through its API, with the permissions and audit trail of the user whose
+// token it presents.
+func (env Env) ctl(ctx context.Context, args []string) error {
+	fs := newFlags("ctl")
+	profile := fs.String("profile", "", "credentials profile to use")
+	format := fs.String("o", "table", "output format: table, json or yaml")
+	wait := fs.Bool("wait", false, "after a change, wait until Route53 has applied it")
+	if err := parse(fs, args, 1, math.MaxInt); err != nil {
+		return err
+	}
+	if !slices.Contains(outputFormats, *format) {
+		return fmt.Errorf("%w: ctl: output format must be table, json or yaml", errUsage)
+	}
+	c := &ctlCmd{Env: env, profile: *profile, format: *format, wait: *wait}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	if cmd == "login" {
+		return c.login(ctx, args)
+	}
+	switch cmd {
+	case "whoami", "zones", "records", "change", "plan", "apply":
+	default:
+		return fmt.Errorf("%w: ctl: unknown command %q", errUsage, cmd)
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	switch cmd {
+	case "whoami":
+		return c.whoami(ctx, args)
+	case "zones":
+		if len(args) == 0 || args[0] != "list" {
+			return fmt.Errorf("%w: ctl zones: expected list", errUsage)
+		}
+		return c.zonesList(ctx, args[1:])
+	case "change":
+		return c.change(ctx, args)
+	case "plan", "apply":
+		return c.sync(ctx, cmd, args)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%w: ctl records: missing subcommand", errUsage)
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "list":
+		return c.recordsList(ctx, args)
+	case "get":
+		return c.recordsGet(ctx, args)
+	case "create":
+		return c.recordsCreate(ctx, args)
+	case "update":
+		return c.recordsUpdate(ctx, args)
+	case "delete":
+		return c.recordsDelete(ctx, args)
+	case "export":
+		return c.recordsExport(ctx, args)
+	case "import":
+		return c.recordsImport(ctx, args)
+	}
+	return fmt.Errorf("%w: ctl records: unknown subcommand %q", errUsage, sub)
+}
+
+// connect sets up the API client from the credentials profile.
+// NS116_CTL_URL and NS116_CTL_TOKEN override the profile, e.g. in CI.
+func (c *ctlCmd) connect() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return err
+	}
+	name := creds.profileName(c.profile)
+	p, ok := creds.Profiles[name]
+	if ok {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+			fmt.Fprintf(c.Stderr, "warning: %s is readable by other users; restrict it with chmod 600\n", path)
+		}
+	}
+	if v := os.Getenv("NS116_CTL_URL"); v != "" {
+		p.URL = v
+	}
+	if v := os.Getenv("NS116_CTL_TOKEN"); v != "" {
+		p.Token = v
+	}
+	if p.URL == "" || p.Token == "" {
+		return fmt.Errorf("no credentials for profile %q in %s; run ns116 ctl login", name, path)
+	}
+	c.api, err = newAPIClient(p)
+	return err
+}
+
+// login checks a token against the server and saves it to the profile. The
+// token is read from standard input so it stays out of the shell history.
+func (c *ctlCmd) login(ctx context.Context, args []string) error {
+	fs := newFlags("ctl login")
+	serverURL := fs.String("url", "", "server URL, e.g. https://dns.example.com")
+	caFile := fs.String("ca-file", "", "CA certificates to verify the server with")
+	certFile := fs.String("cert-file", "", "client certificate, for servers that require one")
+	keyFile := fs.String("key-file", "", "key of the client certificate")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return err
+	}
+	name := creds.profileName(c.profile)
+	p := creds.Profiles[name]
+	if *serverURL != "" {
+		p.URL = *serverURL
+	}
+	if *caFile != "" {
+		p.CAFile = *caFile
+	}
+	if *certFile != "" {
+		p.CertFile, p.KeyFile = *certFile, *keyFile
+	}
+	if p.URL == "" {
+		return fmt.Errorf("%w: ctl login: -url is required for a new profile", errUsage)
+	}
+
+	fmt.Fprint(c.Stderr, "API token: ")
+	line, err := bufio.NewReader(c.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("reading token: %w", err)
+	}
+	if p.Token = strings.TrimSpace(line); p.Token == "" {
+		return fmt.Errorf("no token given")
+	}
+
+	if c.api, err = newAPIClient(p); err != nil {
+		return err
+	}
+	var id model.APIIdentity
+	if err := c.api.do(ctx, http.MethodGet, "whoami", nil, nil, &id); err != nil {
+		return err
+	}
+	if creds.Profiles == nil {
+		creds.Profiles = map[string]ctlProfile{}
+	}
+	creds.Profiles[name] = p
+	if creds.Default == "" {
+		creds.Default = name
+	}
+	if err := creds.save(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "Logged in to %s as %s (%s); saved profile %q to %s\n", p.URL, id.Username, id.Role, name, path)
+	return nil
+}
+
+func (c *ctlCmd) whoami(ctx context.Context, args []string) error {
+	if err := parse(newFlags("ctl whoami"), args, 0, 0); err != nil {
+		return err
+	}
+	var id model.APIIdentity
+	if err := c.api.do(ctx, http.MethodGet, "whoami", nil, nil, &id); err != nil {
+		return err
+	}
+	return c.print(id, func(tw io.Writer) {
+		fmt.Fprintf(tw, "%s (%s) at %s\n", id.Username, id.Role, c.api.base)
+	})
+}
+
+func (c *ctlCmd) zonesList(ctx context.Context, args []string) error {
+	if err := parse(newFlags("ctl zones list"), args, 0, 0); err != nil {
+		return err
+	}
+	var zones []model.HostedZone
+	if err := c.api.do(ctx, http.MethodGet, "zones", nil, nil, &zones); err != nil {
+		return err
+	}
+	return c.print(zones, func(tw io.Writer) {
+		fmt.Fprintln(tw, "ID\tNAME\tRECORDS\tLABEL\tOWNER")
+		for _, z := range zones {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", z.ID, z.Name, z.RecordCount, z.Label, z.Owner)
+		}
+	})
+}
+
+// zone resolves a zone argument, which may be a zone ID, name or label.
+func (c *ctlCmd) zone(ctx context.Context, arg string) (model.HostedZone, error) {
+	var zones []model.HostedZone
+	if err := c.api.do(ctx, http.MethodGet, "zones", nil, nil, &zones); err != nil {
+		return model.HostedZone{}, err
+	}
+	if z, ok := findZone(zones, arg); ok {
+		return z, nil
+	}
+	return model.HostedZone{}, fmt.Errorf("no zone %q; see ns116 ctl zones list", arg)
+}
+
+func (c *ctlCmd) recordsList(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records list")
+	name := fs.String("name", "", "only records with this name")
+	recordType := fs.String("type", "", "only records of this type")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	if *name != "" {
+		q.Set("name", *name)
+	}
+	if *recordType != "" {
+		q.Set("type", *recordType)
+	}
+	var records []model.DNSRecord
+	if err := c.api.do(ctx, http.MethodGet, pathEscape("zones", zone.ID, "records"), q, nil, &records); err != nil {
+		return err
+	}
+	return c.print(records, func(tw io.Writer) {
+		fmt.Fprintln(tw, "NAME\tTYPE\tTTL\tSET ID\tVALUES")
+		for _, r := range records {
+			ttl := strconv.FormatInt(r.TTL, 10)
+			if r.IsAlias {
+				ttl = "alias"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Type, ttl, r.SetIdentifier, recordValues(r))
+		}
+	})
+}
+
+func (c *ctlCmd) recordsGet(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records get")
+	setID := fs.String("set-identifier", "", "set identifier of a record under a routing policy")
+	if err := parse(fs, args, 3, 3); err != nil {
+		return err
+	}
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	rec, err := c.getRecord(ctx, zone, fs.Arg(1), fs.Arg(2), *setID)
+	if err != nil {
+		return err
+	}
+	return c.print(rec, func(tw io.Writer) { printRecord(tw, rec) })
+}
+
+func (c *ctlCmd) getRecord(ctx context.Context, zone model.HostedZone, name, recordType, setID string) (model.DNSRecord, error) {
+	q := url.Values{}
+	if setID != "" {
+		q.Set("set_identifier", setID)
+	}
+	var rec model.DNSRecord
+	err := c.api.do(ctx, http.MethodGet, pathEscape("zones", zone.ID, "records", name, recordType), q, nil, &rec)
+	return rec, err
+}
+
+// recordFlags are the flags of records create and update that describe the
+// record.
+type recordFlags struct {
+	ttl    *int64
+	values *stringList
+	file   *string
+}
+
+func addRecordFlags(fs *flag.FlagSet) recordFlags {
+	f := recordFlags{values: &stringList{}}
+	f.ttl = fs.Int64("ttl", 0, "time to live in seconds")
+	fs.Var(f.values, "value", "record value; repeat for several")
+	f.file = fs.String("f", "", "read the record from this JSON or YAML file (- for standard input)")
+	return f
+}
+
+// apply sets the record fields given on the command line.
+func (f recordFlags) apply(rec *model.DNSRecord) {
+	if *f.ttl != 0 {
+		rec.TTL = *f.ttl
+	}
+	if len(*f.values) > 0 {
+		rec.Values = *f.values
+	}
+}
+
+func (c *ctlCmd) recordsCreate(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records create")
+	rf := addRecordFlags(fs)
+	if err := parse(fs, args, 3, 3); err != nil {
+		return err
+	}
+	var rec model.DNSRecord
+	if *rf.file != "" {
+		if err := c.readDocument(*rf.file, &rec); err != nil {
+			return err
+		}
+	}
+	rec.Name, rec.Type = fs.Arg(1), strings.ToUpper(fs.Arg(2))
+	rf.apply(&rec)
+
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var info model.ChangeInfo
+	if err := c.api.do(ctx, http.MethodPost, pathEscape("zones", zone.ID, "records"), nil, rec, &info); err != nil {
+		return err
+	}
+	return c.printChange(ctx, info)
+}
+
+// recordsUpdate changes the TTL or values of a record, or replaces it with
+// the record in a file. Flag edits are conditional on the record not having
+// changed since it was read.
+func (c *ctlCmd) recordsUpdate(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records update")
+	setID := fs.String("set-identifier", "", "set identifier of a record under a routing policy")
+	rf := addRecordFlags(fs)
+	if err := parse(fs, args, 3, 3); err != nil {
+		return err
+	}
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	name, recordType := fs.Arg(1), strings.ToUpper(fs.Arg(2))
+
+	q := url.Values{}
+	if *setID != "" {
+		q.Set("set_identifier", *setID)
+	}
+	var rec model.DNSRecord
+	if *rf.file != "" {
+		if err := c.readDocument(*rf.file, &rec); err != nil {
+			return err
+		}
+	} else {
+		if *rf.ttl == 0 && len(*rf.values) == 0 {
+			return fmt.Errorf("%w: ctl records update: nothing to change; give -ttl, -value or -f", errUsage)
+		}
+		if rec, err = c.getRecord(ctx, zone, name, recordType, *setID); err != nil {
+			return err
+		}
+		q.Set("version", rec.Version())
+	}
+	rf.apply(&rec)
+
+	var info model.ChangeInfo
+	if err := c.api.do(ctx, http.MethodPut, pathEscape("zones", zone.ID, "records", name, recordType), q, rec, &info); err != nil {
+		return err
+	}
+	return c.printChange(ctx, info)
+}
+
+func (c *ctlCmd) recordsDelete(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records delete")
+	setID := fs.String("set-identifier", "", "set identifier of a record under a routing policy")
+	if err := parse(fs, args, 3, 3); err != nil {
+		return err
+	}
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	if *setID != "" {
+		q.Set("set_identifier", *setID)
+	}
+	var info model.ChangeInfo
+	path := pathEscape("zones", zone.ID, "records", fs.Arg(1), strings.ToUpper(fs.Arg(2)))
+	if err := c.api.do(ctx, http.MethodDelete, path, q, nil, &info); err != nil {
+		return err
+	}
+	return c.printChange(ctx, info)
+}
+
+// recordsExport writes every record set of a zone in the format records
+// import reads: YAML, or JSON with -o json.
+func (c *ctlCmd) recordsExport(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records export")
+	output := fs.String("f", "", "write to this file instead of standard output")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var records []model.DNSRecord
+	if err := c.api.do(ctx, http.MethodGet, pathEscape("zones", zone.ID, "records"), nil, nil, &records); err != nil {
+		return err
+	}
+
+	var data []byte
+	if c.format == "json" {
+		data, err = json.MarshalIndent(records, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = toYAML(records)
+	}
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = c.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stderr, "Exported %d records of %s to %s\n", len(records), zone.Name, *output)
+	return nil
+}
+
+// recordsImport creates and updates the record sets listed in a file, as
+// written by records export, in one change. Records missing from the file
+// are kept.
+func (c *ctlCmd) recordsImport(ctx context.Context, args []string) error {
+	fs := newFlags("ctl records import")
+	file := fs.String("f", "", "JSON or YAML file to import (- for standard input)")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("%w: ctl records import: -f is required", errUsage)
+	}
+	var records []model.DNSRecord
+	if err := c.readDocument(*file, &records); err != nil {
+		return err
+	}
+	zone, err := c.zone(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var result model.ImportResult
+	if err := c.api.do(ctx, http.MethodPost, pathEscape("zones", zone.ID, "import"), nil, records, &result); err != nil {
+		return err
+	}
+	if result.Change != nil && c.wait {
+		if *result.Change, err = c.waitForChange(ctx, *result.Change); err != nil {
+			return err
+		}
+	}
+	return c.print(result, func(tw io.Writer) {
+		fmt.Fprintf(tw, "%d created, %d updated, %d unchanged, %d skipped\n",
+			result.Created, result.Updated, result.Unchanged, result.Skipped)
+		if result.Change != nil {
+			printChangeLine(tw, *result.Change)
+		}
+	})
+}
+
+// sync sends zone files to the server to plan or apply, like ns116 plan and
+// apply but with the user's permissions. The files are parsed here, so
+// mistakes are reported with their line before anything is sent.
+func (c *ctlCmd) sync(ctx context.Context, cmd string, args []string) error {
+	fs := newFlags("ctl " + cmd)
+	flags := addSyncFlags(fs)
+	if err := parse(fs, args, 1, math.MaxInt); err != nil {
+		return err
+	}
+	if *flags.zone != "" && fs.NArg() > 1 {
+		return fmt.Errorf("%w: ctl %s: -zone takes a single file", errUsage, cmd)
+	}
+	for _, path := range fs.Args() {
+		zone, err := c.zone(ctx, flags.zoneRef(path))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		records, err := zonesync.Parse(data, zone.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if records == nil {
+			records = []model.DNSRecord{}
+		}
+		var plan model.SyncPlan
+		req := model.SyncRequest{Records: records, ManagedOnly: *flags.managedOnly, Comment: flags.comment(path)}
+		if err := c.api.do(ctx, http.MethodPost, pathEscape("zones", zone.ID, cmd), nil, req, &plan); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if c.wait {
+			for i := range plan.Batches {
+				if plan.Batches[i], err = c.waitForChange(ctx, plan.Batches[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if err := c.print(plan, func(tw io.Writer) { printPlan(tw, plan) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ctlCmd) change(ctx context.Context, args []string) error {
+	if err := parse(newFlags("ctl change"), args, 1, 1); err != nil {
+		return err
+	}
+	var info model.ChangeInfo
+	if err := c.api.do(ctx, http.MethodGet, pathEscape("changes", args[0]), nil, nil, &info); err != nil {
+		return err
+	}
+	return c.printChange(ctx, info)
+}
+
+// printChange prints a submitted change, after waiting for it with -wait.
+func (c *ctlCmd) printChange(ctx context.Context, info model.ChangeInfo) error {
+	if c.wait {
+		var err error
+		if info, err = c.waitForChange(ctx, info); err != nil {
+			return err
+		}
+	}
+	return c.print(info, func(tw io.Writer) { printChangeLine(tw, info) })
+}
+
+// waitForChange polls the change until Route53 reports it in sync.
+func (c *ctlCmd) waitForChange(ctx context.Context, info model.ChangeInfo) (model.ChangeInfo, error) {
+	for info.Status != "INSYNC" {
+		fmt.Fprintf(c.Stderr, "Waiting for change %s (%s)...\n", info.ID, info.Status)
+		select {
+		case <-ctx.Done():
+			return info, ctx.Err()
+		case <-time.After(changePollInterval):
+		}
+		if err := c.api.do(ctx, http.MethodGet, pathEscape("changes", info.ID), nil, nil, &info); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+// print writes v as JSON or YAML, or calls table to write it for people.
+func (c *ctlCmd) print(v any, table func(tw io.Writer)) error {
+	switch c.format {
+	case "json":
+		enc := json.NewEncoder(c.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		data, err := toYAML(v)
+		if err != nil {
+			return err
+		}
+		_, err = c.Stdout.Write(data)
+		return err
+	}
+	tw := tabwriter.NewWriter(c.Stdout, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// readDocument decodes a JSON or YAML file into v with the JSON field
+// names of the API. "-" reads standard input.
+func (c *ctlCmd) readDocument(path string, v any) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(c.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	// YAML is a superset of JSON, so both go through the YAML decoder
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// toYAML renders v as block-style YAML with the JSON field names and field
+// order of the API.
+func toYAML(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	plainStyle(&doc)
+	return yaml.Marshal(&doc)
+}
+
+// plainStyle drops the flow style and quoting JSON input leaves on the
+// nodes; the encoder still quotes strings that need it.
+func plainStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, child := range n.Content {
+		plainStyle(child)
+	}
+}
+
+func printRecord(tw io.Writer, r model.DNSRecord) {
+	fmt.Fprintf(tw, "Name:\t%s\n", r.Name)
+	fmt.Fprintf(tw, "Type:\t%s\n", r.Type)
+	if r.IsAlias {
+		fmt.Fprintf(tw, "Alias:\t%s (zone %s, evaluate health %t)\n", r.AliasTarget, r.AliasZoneID, r.EvaluateTargetHealth)
+	} else {
+		fmt.Fprintf(tw, "TTL:\t%d\n", r.TTL)
+		for _, v := range r.Values {
+			fmt.Fprintf(tw, "Value:\t%s\n", v)
+		}
+	}
+	if r.SetIdentifier != "" {
+		fmt.Fprintf(tw, "Set ID:\t%s\n", r.SetIdentifier)
+	}
+	if policy := routingPolicy(r.RoutingPolicy); policy != "" {
+		fmt.Fprintf(tw, "Routing:\t%s\n", policy)
+	}
+	fmt.Fprintf(tw, "Version:\t%s\n", r.Version())
+}
+
+func printChangeLine(tw io.Writer, info model.ChangeInfo) {
+	fmt.Fprintf(tw, "Change %s %s (submitted %s)\n", info.ID, info.Status, info.SubmittedAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+func recordValues(r model.DNSRecord) string {
+	if r.IsAlias {
+		return "ALIAS " + r.AliasTarget
+	}
+	return strings.Join(r.Values, ", ")
+}
+
+func routingPolicy(p model.RoutingPolicy) string {
+	var parts []string
+	if p.Weight != nil {
+		parts = append(parts, fmt.Sprintf("weight=%d", *p.Weight))
+	}
+	if p.Region != "" {
+		parts = append(parts, "region="+p.Region)
+	}
+	if p.Failover != "" {
+		parts = append(parts, "failover="+p.Failover)
+	}
+	if g := p.GeoLocation; g != nil {
+		parts = append(parts, "geo="+strings.Trim(strings.Join([]string{g.ContinentCode, g.CountryCode, g.SubdivisionCode}, "/"), "/"))
+	}
+	if p.MultiValueAnswer {
+		parts = append(parts, "multivalue")
+	}
+	if p.HealthCheckID != "" {
+		parts = append(parts, "health_check="+p.HealthCheckID)
+	}
+	return strings.Join(parts, " ")
+}
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
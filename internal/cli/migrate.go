@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"ns116/internal/database"
+	"ns116/web"
+)
+
+func (env Env) migrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: migrate: missing subcommand", errUsage)
+	}
+	sub, args := args[0], args[1:]
+	fs := newFlags("migrate " + sub)
+	var run func(*database.Migrator) error
+	switch sub {
+	case "up":
+		if err := parse(fs, args, 0, 0); err != nil {
+			return err
+		}
+		run = (*database.Migrator).Up
+	case "down":
+		if err := parse(fs, args, 0, 1); err != nil {
+			return err
+		}
+		steps := 1
+		if fs.NArg() == 1 {
+			n, err := strconv.Atoi(fs.Arg(0))
+			if err != nil || n < 1 {
+				return fmt.Errorf("%w: migrate down: steps must be a positive number", errUsage)
+			}
+			steps = n
+		}
+		run = func(m *database.Migrator) error { return m.Down(steps) }
+	case "force":
+		if err := parse(fs, args, 1, 1); err != nil {
+			return err
+		}
+		version, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || version < -1 {
+			return fmt.Errorf("%w: migrate force: version must be a number, or -1 for none", errUsage)
+		}
+		run = func(m *database.Migrator) error { return m.Force(version) }
+	case "version":
+		if err := parse(fs, args, 0, 0); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: migrate: unknown subcommand %q", errUsage, sub)
+	}
+
+	cfg, err := env.loadConfig()
+	if err != nil {
+		return err
+	}
+	db, err := database.Connect(ctx, cfg.Database.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, err := db.Migrator(web.MigrationsFS())
+	if err != nil {
+		return err
+	}
+	if run != nil {
+		if err := run(m); err != nil {
+			return err
+		}
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	latest, err := m.Latest()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "Schema version %d (latest %d)", version, latest)
+	if dirty {
+		fmt.Fprintf(env.Stdout, ", dirty: migration %d failed halfway", version)
+	}
+	fmt.Fprintln(env.Stdout)
+	return nil
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"ns116/internal/model"
+)
+
+func (env Env) sessions(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "purge" {
+		return fmt.Errorf("%w: sessions: expected purge", errUsage)
+	}
+	fs := newFlags("sessions purge")
+	all := fs.Bool("all", false, "log out every user")
+	username := fs.String("user", "", "log out one user")
+	if err := parse(fs, args[1:], 0, 0); err != nil {
+		return err
+	}
+	if *all && *username != "" {
+		return fmt.Errorf("%w: sessions purge: use either -all or -user", errUsage)
+	}
+	_, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case *all:
+		n, err := db.DeleteAllSessions(ctx)
+		logAudit(ctx, db, model.AuditEntry{Action: "revoke_sessions", Detail: fmt.Sprintf("all sessions=%d", n)}, err)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "%d sessions revoked\n", n)
+	case *username != "":
+		err := db.DeleteUserSessions(ctx, *username)
+		logAudit(ctx, db, model.AuditEntry{Action: "revoke_sessions", Detail: "user=" + *username}, err)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "Sessions of %s revoked\n", *username)
+	default:
+		n, err := db.PurgeExpiredSessions(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "%d expired sessions purged\n", n)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"ns116/internal/model"
+	"ns116/internal/service"
+	"ns116/internal/zonesync"
+)
+
+// syncFlags are the flags shared by plan and apply, here and in ns116 ctl.
+type syncFlags struct {
+	zone        *string
+	managedOnly *bool
+	message     *string
+}
+
+func addSyncFlags(fs *flag.FlagSet) syncFlags {
+	return syncFlags{
+		zone:        fs.String("zone", "", "zone ID, name or label; defaults to the file name"),
+		managedOnly: fs.Bool("managed-only", false, "keep record sets the file does not list"),
+		message:     fs.String("m", "", "change comment for apply; defaults to \"Apply <file>\""),
+	}
+}
+
+// zoneRef returns the zone a file is for: -zone, or the file name without
+// its extension, e.g. example.com for zones/example.com.yaml.
+func (f syncFlags) zoneRef(path string) string {
+	if *f.zone != "" {
+		return *f.zone
+	}
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// comment returns the change comment for applying a file: -m, or "Apply"
+// and the file name.
+func (f syncFlags) comment(path string) string {
+	if *f.message != "" {
+		return *f.message
+	}
+	return "Apply " + filepath.Base(path)
+}
+
+// sync implements plan and apply: it compares zone files with their zones
+// and, for apply, submits the differences. It acts as the system user with
+// the AWS credentials of the configuration.
+func (env Env) sync(ctx context.Context, cmd string, args []string) error {
+	fs := newFlags(cmd)
+	flags := addSyncFlags(fs)
+	if err := parse(fs, args, 1, math.MaxInt); err != nil {
+		return err
+	}
+	if *flags.zone != "" && fs.NArg() > 1 {
+		return fmt.Errorf("%w: %s: -zone takes a single file", errUsage, cmd)
+	}
+	cfg, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r53, err := service.NewDNSService(cfg, db)
+	if err != nil {
+		return err
+	}
+	zones, err := r53.ListZones(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		ref := flags.zoneRef(path)
+		zone, ok := findZone(zones, ref)
+		if !ok {
+			return fmt.Errorf("%s: no zone %q", path, ref)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		desired, err := zonesync.Parse(data, zone.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		plan, err := zonesync.Plan(ctx, r53, zone, desired, *flags.managedOnly || cfg.Sync.ManagedOnly)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if cmd == "apply" && len(plan.Changes) > 0 {
+			err = zonesync.Apply(ctx, r53, plan, cfg.Sync.BatchSize, flags.comment(path), func(batch []model.PlannedChange, changeID string, err error) {
+				for _, c := range batch {
+					entry := zonesync.AuditEntry(model.AuditEntry{ChangeID: changeID}, zone.ID, c)
+					entry.Detail = strings.TrimSpace(entry.Detail + " file=" + strconv.Quote(filepath.Base(path)))
+					logAudit(ctx, db, entry, err)
+				}
+			})
+		}
+		tw := tabwriter.NewWriter(env.Stdout, 0, 0, 2, ' ', 0)
+		printPlan(tw, *plan)
+		if flushErr := tw.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// findZone looks a zone up by ID, name or label.
+func findZone(zones []model.HostedZone, ref string) (model.HostedZone, bool) {
+	name := strings.TrimSuffix(ref, ".") + "."
+	for _, z := range zones {
+		if z.ID == ref || strings.EqualFold(z.Name, name) {
+			return z, true
+		}
+	}
+	for _, z := range zones {
+		if z.Label != "" && z.Label == ref {
+			return z, true
+		}
+	}
+	return model.HostedZone{}, false
+}
+
+// printPlan lists the changes of a plan, marked + for creations, ~ for
+// updates and - for deletions, followed by the batches submitted.
+func printPlan(tw io.Writer, plan model.SyncPlan) {
+	fmt.Fprintf(tw, "%s (%s): %d to create, %d to update, %d to delete, %d unchanged\n", plan.ZoneName, plan.ZoneID,
+		plan.Count("CREATE"), plan.Count("UPDATE"), plan.Count("DELETE"), plan.Unchanged)
+	for _, c := range plan.Changes {
+		rec := c.Record()
+		name := rec.Name
+		if rec.SetIdentifier != "" {
+			name += " [" + rec.SetIdentifier + "]"
+		}
+		switch c.Action {
+		case "CREATE":
+			fmt.Fprintf(tw, "  +\t%s\t%s\t%s\n", name, rec.Type, planValues(rec))
+		case "UPDATE":
+			fmt.Fprintf(tw, "  ~\t%s\t%s\t%s\n", name, rec.Type, zonesync.Summary(c))
+		case "DELETE":
+			fmt.Fprintf(tw, "  -\t%s\t%s\t%s\n", name, rec.Type, planValues(rec))
+		}
+	}
+	if plan.Kept > 0 {
+		fmt.Fprintf(tw, "%d record sets missing from the file are kept (managed records only)\n", plan.Kept)
+	}
+	for _, b := range plan.Batches {
+		printChangeLine(tw, b)
+	}
+}
+
+func planValues(r model.DNSRecord) string {
+	s := recordValues(r)
+	if !r.IsAlias {
+		s = "ttl=" + strconv.FormatInt(r.TTL, 10) + " " + s
+	}
+	if policy := routingPolicy(r.RoutingPolicy); policy != "" {
+		s += " " + policy
+	}
+	return s
+}
@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+func (env Env) user(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: user: missing subcommand", errUsage)
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "list":
+		return env.userList(ctx, args)
+	case "create":
+		return env.userCreate(ctx, args)
+	case "reset-password":
+		return env.userResetPassword(ctx, args)
+	case "set-role":
+		return env.userSetRole(ctx, args)
+	case "enable", "disable":
+		return env.userSetActive(ctx, args, sub == "enable")
+	}
+	return fmt.Errorf("%w: user: unknown subcommand %q", errUsage, sub)
+}
+
+func (env Env) userList(ctx context.Context, args []string) error {
+	if err := parse(newFlags("user list"), args, 0, 0); err != nil {
+		return err
+	}
+	_, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	users, err := db.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(env.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tROLE\tSTATUS\tSOURCE\tEMAIL")
+	for _, u := range users {
+		status := "active"
+		if !u.Active {
+			status = "disabled"
+		} else if u.MustChangePassword {
+			status = "must change password"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.Username, u.Role, status, u.AuthSource, u.Email)
+	}
+	return tw.Flush()
+}
+
+func (env Env) userCreate(ctx context.Context, args []string) error {
+	fs := newFlags("user create")
+	role := fs.String("role", "editor", "admin or editor")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	username := fs.Arg(0)
+	if err := checkRole(*role); err != nil {
+		return err
+	}
+	cfg, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	password, generated, err := env.password(cfg.PasswordPolicy, *fromStdin)
+	if err != nil {
+		return err
+	}
+	err = db.CreateUser(ctx, username, password, *role)
+	if err == nil && generated {
+		// A generated password is temporary like one set by an admin
+		err = db.ResetUserPassword(ctx, username, password)
+	}
+	logAudit(ctx, db, model.AuditEntry{
+		Action: "create_user",
+		Detail: fmt.Sprintf("created user=%s role=%s", username, *role),
+		After:  model.AuditState(map[string]any{"username": username, "role": *role, "active": true}),
+	}, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "User %s created with role %s\n", username, *role)
+	env.printPassword(password, generated)
+	return nil
+}
+
+func (env Env) userResetPassword(ctx context.Context, args []string) error {
+	fs := newFlags("user reset-password")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	username := fs.Arg(0)
+	cfg, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	target, err := lookupUser(ctx, db, username)
+	if err != nil {
+		return err
+	}
+	if target.AuthSource != "local" {
+		return fmt.Errorf("the password of %s user %s is managed externally", target.AuthSource, username)
+	}
+	password, generated, err := env.password(cfg.PasswordPolicy, *fromStdin)
+	if err != nil {
+		return err
+	}
+	err = db.ResetUserPassword(ctx, username, password)
+	if err == nil {
+		err = db.DeleteUserSessions(ctx, username)
+	}
+	logAudit(ctx, db, model.AuditEntry{Action: "reset_password", Detail: "user=" + username}, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "Password of %s reset; they must change it at the next login\n", username)
+	if !target.Active {
+		fmt.Fprintf(env.Stdout, "The account is disabled; run ns116 user enable %s to let them sign in\n", username)
+	}
+	env.printPassword(password, generated)
+	return nil
+}
+
+func (env Env) userSetRole(ctx context.Context, args []string) error {
+	fs := newFlags("user set-role")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	username, role := fs.Arg(0), fs.Arg(1)
+	if err := checkRole(role); err != nil {
+		return err
+	}
+	_, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	target, err := lookupUser(ctx, db, username)
+	if err != nil {
+		return err
+	}
+	if target.AuthSource != "local" {
+		return fmt.Errorf("the role of %s user %s is managed externally", target.AuthSource, username)
+	}
+	err = userError(db.SetUserRole(ctx, username, role))
+	logAudit(ctx, db, model.AuditEntry{
+		Action: "update_user_role",
+		Detail: fmt.Sprintf("user=%s role: %s -> %s", username, target.Role, role),
+		Before: model.AuditState(map[string]any{"username": username, "role": target.Role, "active": target.Active}),
+		After:  model.AuditState(map[string]any{"username": username, "role": role, "active": target.Active}),
+	}, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "Role of %s changed to %s\n", username, role)
+	return nil
+}
+
+func (env Env) userSetActive(ctx context.Context, args []string, active bool) error {
+	name, action, state := "user enable", "enable_user", "enabled"
+	if !active {
+		name, action, state = "user disable", "disable_user", "disabled"
+	}
+	fs := newFlags(name)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	username := fs.Arg(0)
+	_, db, err := env.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	target, err := lookupUser(ctx, db, username)
+	if err != nil {
+		return err
+	}
+	err = userError(db.SetUserActive(ctx, username, active))
+	if err == nil && !active {
+		err = db.DeleteUserSessions(ctx, username)
+	}
+	logAudit(ctx, db, model.AuditEntry{
+		Action: action,
+		Detail: "user=" + username,
+		Before: model.AuditState(map[string]any{"username": username, "role": target.Role, "active": target.Active}),
+		After:  model.AuditState(map[string]any{"username": username, "role": target.Role, "active": active}),
+	}, err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "User %s %s\n", username, state)
+	return nil
+}
+
+// password reads the password from standard input, or generates a
+// temporary one when fromStdin is false. Both must satisfy the policy.
+func (env Env) password(policy config.PasswordPolicyConfig, fromStdin bool) (string, bool, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(env.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", false, fmt.Errorf("reading the password from standard input: %w", err)
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if err := auth.ValidatePassword(policy, password); err != nil {
+			return "", false, err
+		}
+		return password, false, nil
+	}
+	for range 10 {
+		// rand.Text is upper case letters and digits; a lower case half and a
+		// dash cover the other character classes a policy may require
+		password := rand.Text()[:12] + "-" + strings.ToLower(rand.Text()[:12])
+		if auth.ValidatePassword(policy, password) == nil {
+			return password, true, nil
+		}
+	}
+	return "", false, errors.New("could not generate a password that satisfies password_policy; use -password-stdin")
+}
+
+func (env Env) printPassword(password string, generated bool) {
+	if generated {
+		fmt.Fprintf(env.Stdout, "Temporary password: %s\n", password)
+	}
+}
+
+func lookupUser(ctx context.Context, db *database.DB, username string) (*model.User, error) {
+	u, err := db.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("user %q not found", username)
+	}
+	return u, nil
+}
+
+func checkRole(role string) error {
+	if role != "admin" && role != "editor" {
+		return fmt.Errorf("%w: role must be admin or editor", errUsage)
+	}
+	return nil
+}
+
+func userError(err error) error {
+	if errors.Is(err, database.ErrLastAdmin) {
+		return errors.New("at least one active admin must remain")
+	}
+	return err
+}
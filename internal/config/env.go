@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variable of every configuration field:
+// NS116_ followed by the YAML path in upper case, joined by underscores, e.g.
+// NS116_LDAP_BIND_PASSWORD for ldap.bind_password. NS116_<NAME>_FILE reads
+// the value from a file instead.
+const EnvPrefix = "NS116_"
+
+// envAliases are the variable names used before every field could be set
+// from the environment. They still work when the full name is unset.
+var envAliases = map[string]string{
+	"NS116_SETUP_ADMIN_USERNAME":        "NS116_ADMIN_USERNAME",
+	"NS116_SETUP_ADMIN_PASSWORD":        "NS116_ADMIN_PASSWORD",
+	"NS116_NOTIFICATIONS_SMTP_PASSWORD": "NS116_SMTP_PASSWORD",
+}
+
+// expandRe matches ${NAME}, ${NAME:-default} and the $${ escape.
+var expandRe = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${NAME} in every scalar of the YAML document with the
+// value of the environment variable. ${NAME:-default} falls back to default
+// when NAME is unset or empty; any other unset variable is an error so a
+// typo cannot silently empty a setting. $${ stands for a literal ${.
+func expandEnv(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+		var missing []string
+		value := expandRe.ReplaceAllStringFunc(n.Value, func(m string) string {
+			if m == "$${" {
+				return "${"
+			}
+			sub := expandRe.FindStringSubmatch(m)
+			v, ok := os.LookupEnv(sub[1])
+			if sub[2] != "" && v == "" {
+				return sub[3]
+			}
+			if !ok {
+				missing = append(missing, sub[1])
+			}
+			return v
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("line %d: environment variable %s is not set", n.Line, strings.Join(missing, ", "))
+		}
+		n.Value = value
+		if n.Style == 0 {
+			// Let plain scalars such as "port: ${PORT}" resolve to their
+			// expanded type
+			n.Tag = ""
+		}
+	}
+	for _, c := range n.Content {
+		if err := expandEnv(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveFileRefs replaces "<key>_file: path" with the trimmed contents of
+// the file for every string field <key> of t, so secrets can be mounted as
+// files instead of being written into the configuration.
+func resolveFileRefs(n *yaml.Node, t reflect.Type) error {
+	switch {
+	case n.Kind == yaml.DocumentNode:
+		for _, c := range n.Content {
+			if err := resolveFileRefs(c, t); err != nil {
+				return err
+			}
+		}
+	case n.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, c := range n.Content {
+			if err := resolveFileRefs(c, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		keys := make(map[string]bool, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			keys[n.Content[i].Value] = true
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if f, ok := fieldByTag(t, key.Value); ok {
+				if err := resolveFileRefs(value, f.Type); err != nil {
+					return err
+				}
+				continue
+			}
+			name, isRef := strings.CutSuffix(key.Value, "_file")
+			f, ok := fieldByTag(t, name)
+			if !isRef || !ok || f.Type.Kind() != reflect.String {
+				continue
+			}
+			if keys[name] {
+				return fmt.Errorf("line %d: set either %s or %s, not both", key.Line, name, key.Value)
+			}
+			data, err := readSecretFile(value.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", key.Line, key.Value, err)
+			}
+			key.Value = name
+			*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: data, Line: value.Line}
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides the fields of v from NS116_* environment variables.
+// Scalars take the variable as is, lists are comma-separated (or a YAML
+// flow sequence) and maps and lists of sections are YAML flow collections
+// such as {admin: "cn=admins"}. Sections in a list can also be set one field
+// at a time by index, e.g. NS116_AUDIT_SINKS_0_URL.
+func applyEnv(v reflect.Value, name string) error {
+	t := v.Type()
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			tag := yamlName(t.Field(i))
+			if tag == "" {
+				continue
+			}
+			if err := applyEnv(v.Field(i), name+"_"+strings.ToUpper(tag)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	value, ok, err := lookupEnv(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := setFromEnv(v, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct {
+		for _, i := range envIndexes(name) {
+			for v.Len() <= i {
+				v.Set(reflect.Append(v, reflect.Zero(t.Elem())))
+			}
+			if err := applyEnv(v.Index(i), name+"_"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookupEnv returns the value of name, of the file named by name_FILE or of
+// an older alias of name. Empty variables count as unset.
+func lookupEnv(name string) (string, bool, error) {
+	value := os.Getenv(name)
+	file := os.Getenv(name + "_FILE")
+	if value != "" && file != "" {
+		return "", false, fmt.Errorf("set either %s or %s_FILE, not both", name, name)
+	}
+	if file != "" {
+		data, err := readSecretFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return data, true, nil
+	}
+	if value == "" {
+		if alias, ok := envAliases[name]; ok {
+			value = os.Getenv(alias)
+		}
+	}
+	return value, value != "", nil
+}
+
+func setFromEnv(v reflect.Value, value string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(value, "["):
+		var items []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	}
+	// Everything else parses as YAML, so durations, booleans, numbers and
+	// collections read exactly as they would in the file
+	ptr := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// envIndexes lists the indexes i for which some name_<i>_* variable is set.
+func envIndexes(name string) []int {
+	var indexes []int
+	for _, kv := range os.Environ() {
+		rest, ok := strings.CutPrefix(kv, name+"_")
+		if !ok {
+			continue
+		}
+		digits, _, ok := strings.Cut(rest, "_")
+		if i, err := strconv.Atoi(digits); ok && err == nil && i >= 0 && !slices.Contains(indexes, i) {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	return name
+}
+
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("TEST_PORT", "9090")
+	t.Setenv("TEST_DSN", "postgres://ns116:secret@db/ns116")
+	t.Setenv("TEST_EMPTY", "")
+	path := writeFile(t, "config.yaml", `
+server:
+  port: ${TEST_PORT}
+  host: "${TEST_HOST:-127.0.0.1}"
+database:
+  dsn: ${TEST_DSN}
+setup:
+  token: "literal $${TEST_DSN} and ${TEST_EMPTY:-fallback}"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9090 || cfg.Server.Host != "127.0.0.1" {
+		t.Errorf("server = %s:%d", cfg.Server.Host, cfg.Server.Port)
+	}
+	if cfg.Database.DSN != "postgres://ns116:secret@db/ns116" {
+		t.Errorf("dsn = %q", cfg.Database.DSN)
+	}
+	if want := "literal ${TEST_DSN} and fallback"; cfg.Setup.Token != want {
+		t.Errorf("token = %q, want %q", cfg.Setup.Token, want)
+	}
+}
+
+func TestLoadMissingEnv(t *testing.T) {
+	path := writeFile(t, "config.yaml", "database:\n  dsn: ${TEST_UNSET_DSN}\n")
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "line 2: environment variable TEST_UNSET_DSN is not set") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoadFileRefs(t *testing.T) {
+	password := writeFile(t, "bind_password", "s3cret\n")
+	url := writeFile(t, "url", "https://siem.example.com/ingest\r\n")
+	path := writeFile(t, "config.yaml", `
+ldap:
+  enabled: true
+  url: ldaps://ldap.example.com
+  bind_dn: cn=ns116,dc=example,dc=com
+  bind_password_file: `+password+`
+  base_dn: dc=example,dc=com
+  group_mapping: {admin: cn=admins}
+audit:
+  sinks:
+    - name: siem
+      type: http
+      url_file: `+url+`
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LDAP.BindPassword != "s3cret" {
+		t.Errorf("bind_password = %q", cfg.LDAP.BindPassword)
+	}
+	if len(cfg.Audit.Sinks) != 1 || cfg.Audit.Sinks[0].URL != "https://siem.example.com/ingest" {
+		t.Errorf("sinks = %+v", cfg.Audit.Sinks)
+	}
+}
+
+func TestLoadFileRefErrors(t *testing.T) {
+	password := writeFile(t, "bind_password", "s3cret")
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"both set", "ldap:\n  bind_password: x\n  bind_password_file: " + password, "line 3: set either bind_password or bind_password_file, not both"},
+		{"missing file", "ldap:\n  bind_password_file: /nonexistent/ns116", "line 2: bind_password_file:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFile(t, "config.yaml", tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	t.Setenv("NS116_SERVER_PORT", "9443")
+	t.Setenv("NS116_SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.10")
+	t.Setenv("NS116_AUDIT_CHECKPOINT_INTERVAL", "15m")
+	t.Setenv("NS116_LDAP_GROUP_MAPPING", "{admin: cn=admins, editor: cn=editors}")
+	t.Setenv("NS116_AUDIT_SINKS_1_URL", "https://b.example.com")
+	t.Setenv("NS116_SETUP_ADMIN_USERNAME", "admin")
+	t.Setenv("NS116_ADMIN_PASSWORD", "from-alias")
+	t.Setenv("NS116_DATABASE_DSN_FILE", writeFile(t, "dsn", "postgres://db/ns116\n"))
+	path := writeFile(t, "config.yaml", `
+server:
+  port: 8080
+audit:
+  sinks:
+    - {name: a, type: http, url: https://a.example.com}
+    - {name: b, type: http, url: https://old.example.com}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9443 {
+		t.Errorf("port = %d", cfg.Server.Port)
+	}
+	if want := []string{"10.0.0.0/8", "192.0.2.10"}; !reflect.DeepEqual(cfg.Server.TrustedProxies, want) {
+		t.Errorf("trusted_proxies = %q", cfg.Server.TrustedProxies)
+	}
+	if cfg.Audit.CheckpointInterval != 15*time.Minute {
+		t.Errorf("checkpoint_interval = %s", cfg.Audit.CheckpointInterval)
+	}
+	if want := map[string]string{"admin": "cn=admins", "editor": "cn=editors"}; !reflect.DeepEqual(cfg.LDAP.GroupMapping, want) {
+		t.Errorf("group_mapping = %v", cfg.LDAP.GroupMapping)
+	}
+	if cfg.Audit.Sinks[0].URL != "https://a.example.com" || cfg.Audit.Sinks[1].URL != "https://b.example.com" {
+		t.Errorf("sinks = %+v", cfg.Audit.Sinks)
+	}
+	if cfg.Setup.AdminUsername != "admin" || cfg.Setup.AdminPassword != "from-alias" {
+		t.Errorf("setup admin = %q / %q", cfg.Setup.AdminUsername, cfg.Setup.AdminPassword)
+	}
+	if cfg.Database.DSN != "postgres://db/ns116" {
+		t.Errorf("dsn = %q", cfg.Database.DSN)
+	}
+}
+
+func TestLoadEnvOnly(t *testing.T) {
+	t.Setenv("NS116_SERVER_PORT", "8443")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 8443 || cfg.Server.ClientIPHeader != "X-Forwarded-For" {
+		t.Errorf("server = %+v", cfg.Server)
+	}
+}
+
+func TestLoadEnvConflict(t *testing.T) {
+	t.Setenv("NS116_LDAP_BIND_PASSWORD", "x")
+	t.Setenv("NS116_LDAP_BIND_PASSWORD_FILE", writeFile(t, "bind_password", "y"))
+	_, err := Load("")
+	if err == nil || !strings.Contains(err.Error(), "set either NS116_LDAP_BIND_PASSWORD or NS116_LDAP_BIND_PASSWORD_FILE") {
+		t.Errorf("err = %v", err)
+	}
+}
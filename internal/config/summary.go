@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// setting is one configured value under its dotted YAML path, e.g.
+// "hosted_zones[0].label".
+type setting struct {
+	path  string
+	value string
+	// shown is value with secrets redacted
+	shown string
+}
+
+// Summary lists every setting that differs from its zero value as dotted
+// YAML paths with their values, in field order, for the startup log.
+// Fields tagged secret are redacted; a secret URL such as the database DSN
+// only loses its password.
+func (c *Config) Summary() []slog.Attr {
+	var attrs []slog.Attr
+	for _, s := range flatten(c) {
+		attrs = append(attrs, slog.String(s.path, s.shown))
+	}
+	return attrs
+}
+
+// Change is a setting that differs between two configurations. Secret
+// values are redacted.
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// Diff lists the settings that differ between old and new, in field order.
+func Diff(old, new *Config) []Change {
+	before, after := flatten(old), flatten(new)
+	oldByPath := make(map[string]setting, len(before))
+	for _, s := range before {
+		oldByPath[s.path] = s
+	}
+	var changes []Change
+	seen := make(map[string]bool, len(after))
+	for _, s := range after {
+		seen[s.path] = true
+		if o, ok := oldByPath[s.path]; !ok || o.value != s.value {
+			changes = append(changes, Change{Path: s.path, Old: o.shown, New: s.shown})
+		}
+	}
+	for _, s := range before {
+		if !seen[s.path] {
+			changes = append(changes, Change{Path: s.path, Old: s.shown})
+		}
+	}
+	return changes
+}
+
+func flatten(c *Config) []setting {
+	var out []setting
+	collect(reflect.ValueOf(*c), "", "", &out)
+	return out
+}
+
+func collect(v reflect.Value, path, secret string, out *[]setting) {
+	if v.IsZero() {
+		return
+	}
+	t := v.Type()
+	switch {
+	case t.Kind() == reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := yamlName(f); name != "" {
+				collect(v.Field(i), strings.TrimPrefix(path+"."+name, "."), f.Tag.Get("secret"), out)
+			}
+		}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.String:
+		for i := 0; i < v.Len(); i++ {
+			collect(v.Index(i), fmt.Sprintf("%s[%d]", path, i), secret, out)
+		}
+	case t.Kind() == reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			collect(v.MapIndex(reflect.ValueOf(k)), path+"."+k, secret, out)
+		}
+	default:
+		value := fmt.Sprint(v.Interface())
+		if t.Kind() == reflect.Slice {
+			value = strings.Join(v.Interface().([]string), ",")
+		}
+		*out = append(*out, setting{path: path, value: value, shown: redact(value, secret)})
+	}
+}
+
+func redact(value, secret string) string {
+	switch secret {
+	case "":
+		return value
+	case "url":
+		if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+	}
+	return "REDACTED"
+}
@@ -1,71 +1,336 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"strings"
 
 	"ns116/internal/model"
 )
 
-func (db *DB) LogAudit(entry model.AuditEntry) error {
-	_, err := db.conn.Exec(
-		`INSERT INTO audit_log (username, action, zone_id, record_name, record_type, detail, ip_address)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		entry.Username, entry.Action, entry.ZoneID, entry.RecordName,
+const auditSelect = `SELECT a.id, a.username, a.action, a.zone_id, zc.name, a.record_name, a.record_type, a.detail, a.ip_address, a.created_at,
+	 a.before_state, a.after_state, a.status, a.error, a.change_id, a.auth_method, a.request_id, a.cert_fingerprint, a.prev_hash, a.hash
+	 FROM audit_log a
+	 LEFT JOIN zones_cache zc ON a.zone_id = zc.zone_id`
+
+// LogAudit appends an entry to the audit chain and queues it for the
+// configured sinks, matching webhooks and email subscribers. The chain lock
+// is held for the transaction so the entry links to the last committed hash.
+func (db *DB) LogAudit(ctx context.Context, entry model.AuditEntry) error {
+	if entry.Status == "" {
+		entry.Status = model.AuditSuccess
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, auditChainLock); err != nil {
+		return err
+	}
+	c := chainRow{
+		Username: entry.Username, Action: entry.Action, ZoneID: entry.ZoneID,
+		RecordName: entry.RecordName, RecordType: entry.RecordType, Detail: entry.Detail,
+		IPAddress: entry.IPAddress, Before: string(entry.Before), After: string(entry.After),
+		Status: entry.Status, Error: entry.Error, ChangeID: entry.ChangeID, AuthMethod: entry.AuthMethod,
+		RequestID: entry.RequestID, CertFingerprint: entry.CertFingerprint,
+	}
+	// An entry written after retention emptied the table links to the
+	// anchor, the last archived entry
+	err = tx.QueryRowContext(ctx, `SELECT nextval(pg_get_serial_sequence('audit_log', 'id')), LOCALTIMESTAMP,
+		 COALESCE((SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1),
+		   (SELECT value::jsonb->>'hash' FROM settings WHERE key = 'audit_chain_anchor'), '')`).Scan(&c.ID, &c.CreatedAt, &c.PrevHash)
+	if err != nil {
+		return err
+	}
+	c.Hash = c.hash()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO audit_log (id, created_at, username, action, zone_id, record_name, record_type, detail, ip_address,
+		   before_state, after_state, status, error, change_id, auth_method, request_id, cert_fingerprint, prev_hash, hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		c.ID, c.CreatedAt, entry.Username, entry.Action, entry.ZoneID, entry.RecordName,
 		entry.RecordType, entry.Detail, entry.IPAddress,
+		nullJSON(entry.Before), nullJSON(entry.After), entry.Status,
+		nullString(entry.Error), nullString(entry.ChangeID), nullString(entry.AuthMethod),
+		nullString(entry.RequestID), nullString(entry.CertFingerprint), c.PrevHash, c.Hash,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Queue the entry for external sinks in the same transaction so it is
+	// shipped even if a sink is down or the process stops right after commit
+	if len(db.auditSinks) > 0 {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO audit_outbox (sink, audit_id) SELECT unnest($1::text[]), $2",
+			db.auditSinks, c.ID); err != nil {
+			return err
+		}
+	}
+
+	// Only changes that actually happened are announced to webhooks and
+	// email subscribers
+	if entry.Status == model.AuditSuccess {
+		ev := model.WebhookEvent{
+			Event: entry.Action, AuditID: c.ID, OccurredAt: c.CreatedAt, Actor: entry.Username,
+			ZoneID: entry.ZoneID, RecordName: entry.RecordName, RecordType: entry.RecordType,
+			Detail: entry.Detail, Before: entry.Before, After: entry.After, ChangeID: entry.ChangeID,
+		}
+		if err := enqueueWebhooks(ctx, tx, ev); err != nil {
+			return err
+		}
+		if db.notifications && ev.ZoneID != "" {
+			if err := enqueueNotifications(ctx, tx, ev); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
 }
 
-func (db *DB) ListAuditLog(limit, offset int) ([]model.AuditEntry, int, error) {
-	var total int
-	_ = db.conn.QueryRow("SELECT COUNT(*) FROM audit_log").Scan(&total)
+// auditCountCap bounds CountAuditLog so the total shown on the audit page
+// never costs a scan of the whole table.
+const auditCountCap = 10000
+
+// ListAuditLog returns one page of entries matching the filter, newest first.
+// Paging is by entry ID rather than OFFSET so deep pages stay cheap. more
+// reports whether further entries exist in the cursor's direction.
+func (db *DB) ListAuditLog(ctx context.Context, filter model.AuditFilter, cursor model.AuditCursor, limit int) (entries []model.AuditEntry, more bool, err error) {
+	where, args := auditWhere(filter)
+	order := " ORDER BY a.id DESC"
+	switch {
+	case cursor.After > 0:
+		where, args = andWhere(where, args, "a.id > $%d", cursor.After)
+		order = " ORDER BY a.id ASC"
+	case cursor.Before > 0:
+		where, args = andWhere(where, args, "a.id < $%d", cursor.Before)
+	}
 
-	// Postgres uses $1, $2 for limit and offset
-	rows, err := db.conn.Query(
-		`SELECT a.id, a.username, a.action, a.zone_id, zc.name, a.record_name, a.record_type, a.detail, a.ip_address, a.created_at
-		 FROM audit_log a
-		 LEFT JOIN zones_cache zc ON a.zone_id = zc.zone_id
-		 ORDER BY a.created_at DESC LIMIT $1 OFFSET $2`, limit, offset)
+	rows, err := db.conn.QueryContext(ctx,
+		auditSelect+where+order+fmt.Sprintf(" LIMIT $%d", len(args)+1),
+		append(args, limit+1)...)
 	if err != nil {
-		return nil, 0, err
+		return nil, false, err
 	}
 	defer rows.Close()
 
-	var entries []model.AuditEntry
 	for rows.Next() {
-		var e model.AuditEntry
-		var zoneID, zoneName, recordName, recordType, detail sql.NullString
-		if err := rows.Scan(&e.ID, &e.Username, &e.Action, &zoneID, &zoneName, &recordName,
-			&recordType, &detail, &e.IPAddress, &e.CreatedAt); err != nil {
-			return nil, 0, err
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, false, err
 		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
 
-		e.ZoneID = zoneID.String
-		if zoneName.Valid {
-			e.ZoneName = zoneName.String
-		} else {
-			e.ZoneName = e.ZoneID
+	if len(entries) > limit {
+		entries, more = entries[:limit], true
+	}
+	if cursor.After > 0 {
+		slices.Reverse(entries)
+	}
+	return entries, more, nil
+}
+
+// CountAuditLog counts entries matching the filter up to auditCountCap.
+// capped reports that there are at least that many.
+func (db *DB) CountAuditLog(ctx context.Context, filter model.AuditFilter) (n int, capped bool, err error) {
+	where, args := auditWhere(filter)
+	err = db.conn.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM audit_log a%s LIMIT %d) t", where, auditCountCap+1),
+		args...).Scan(&n)
+	if n > auditCountCap {
+		return auditCountCap, true, err
+	}
+	return n, false, err
+}
+
+// StreamAuditLog calls fn for every entry matching the filter, newest first,
+// without buffering the result set. It stops at the first error from fn.
+func (db *DB) StreamAuditLog(ctx context.Context, filter model.AuditFilter, fn func(model.AuditEntry) error) error {
+	where, args := auditWhere(filter)
+	rows, err := db.conn.QueryContext(ctx, auditSelect+where+" ORDER BY a.id DESC", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
 		}
-		e.RecordName = recordName.String
-		e.RecordType = recordType.String
-		e.Detail = detail.String
-
-		if e.ZoneName != "" && e.ZoneName != e.ZoneID && e.RecordName != "" {
-			zoneDomin := strings.TrimSuffix(e.ZoneName, ".")
-			recNameClean := strings.TrimSuffix(e.RecordName, ".")
-
-			if recNameClean == zoneDomin {
-				e.RecordName = "@"
-			} else {
-				suffix := "." + zoneDomin
-				if strings.HasSuffix(recNameClean, suffix) {
-					e.RecordName = recNameClean[:len(recNameClean)-len(suffix)]
-				}
-			}
+		if err := fn(e); err != nil {
+			return err
 		}
+	}
+	return rows.Err()
+}
 
-		entries = append(entries, e)
+// AuditStats returns the planner's row estimate and the time span of the
+// online audit log, all answered from statistics and indexes.
+func (db *DB) AuditStats(ctx context.Context) (model.AuditStats, error) {
+	var st model.AuditStats
+	var oldest, newest sql.NullTime
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT GREATEST(c.reltuples, 0)::bigint, (SELECT MIN(created_at) FROM audit_log), (SELECT MAX(created_at) FROM audit_log)
+		 FROM pg_class c WHERE c.oid = 'audit_log'::regclass`).Scan(&st.EstimatedRows, &oldest, &newest)
+	st.OldestAt, st.NewestAt = oldest.Time, newest.Time
+	return st, err
+}
+
+// ListAuditActions returns the distinct actions present in the log, for the
+// filter drop-down.
+func (db *DB) ListAuditActions(ctx context.Context) ([]string, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT DISTINCT action FROM audit_log ORDER BY action")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+func auditWhere(f model.AuditFilter) (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Username != "" {
+		add("a.username = $%d", f.Username)
+	}
+	if f.Action != "" {
+		add("a.action = $%d", f.Action)
+	}
+	if f.ZoneID != "" {
+		add("a.zone_id = $%d", f.ZoneID)
+	}
+	if f.RecordName != "" {
+		add("a.record_name LIKE $%d", escapeLike(f.RecordName)+"%")
+	}
+	if f.RecordType != "" {
+		add("a.record_type = $%d", strings.ToUpper(f.RecordType))
+	}
+	if f.IPAddress != "" {
+		add("a.ip_address LIKE $%d", escapeLike(f.IPAddress)+"%")
+	}
+	if !f.From.IsZero() {
+		add("a.created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("a.created_at < $%d", f.To)
+	}
+	if f.Search != "" {
+		add("a.detail ILIKE $%d", "%"+escapeLike(f.Search)+"%")
+	}
+	if f.Status != "" {
+		add("a.status = $%d", f.Status)
+	}
+	if f.Value != "" {
+		// Served by the jsonb_path_ops GIN indexes on both states
+		state := string(model.AuditState(map[string][]string{"values": {f.Value}}))
+		args = append(args, state)
+		conds = append(conds, fmt.Sprintf("(a.before_state @> $%d::jsonb OR a.after_state @> $%d::jsonb)", len(args), len(args)))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// andWhere appends one more condition to a clause built by auditWhere.
+func andWhere(where string, args []any, cond string, arg any) (string, []any) {
+	args = append(args, arg)
+	cond = fmt.Sprintf(cond, len(args))
+	if where == "" {
+		return " WHERE " + cond, args
+	}
+	return where + " AND " + cond, args
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func scanAuditEntry(rows *sql.Rows) (model.AuditEntry, error) {
+	var e model.AuditEntry
+	var zoneID, zoneName, recordName, recordType, detail sql.NullString
+	var before, after, errMsg, changeID, authMethod, requestID, certFingerprint, prevHash, hash sql.NullString
+	if err := rows.Scan(&e.ID, &e.Username, &e.Action, &zoneID, &zoneName, &recordName,
+		&recordType, &detail, &e.IPAddress, &e.CreatedAt,
+		&before, &after, &e.Status, &errMsg, &changeID, &authMethod, &requestID, &certFingerprint, &prevHash, &hash); err != nil {
+		return e, err
+	}
+
+	if before.Valid {
+		e.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		e.After = json.RawMessage(after.String)
+	}
+	e.Error = errMsg.String
+	e.ChangeID = changeID.String
+	e.AuthMethod = authMethod.String
+	e.RequestID = requestID.String
+	e.CertFingerprint = certFingerprint.String
+	e.PrevHash = prevHash.String
+	e.Hash = hash.String
+
+	e.ZoneID = zoneID.String
+	if zoneName.Valid {
+		e.ZoneName = zoneName.String
+	} else {
+		e.ZoneName = e.ZoneID
+	}
+	e.RecordName = recordName.String
+	e.RecordType = recordType.String
+	e.Detail = detail.String
+
+	if e.ZoneName != "" && e.ZoneName != e.ZoneID && e.RecordName != "" {
+		zoneDomin := strings.TrimSuffix(e.ZoneName, ".")
+		recNameClean := strings.TrimSuffix(e.RecordName, ".")
+
+		if recNameClean == zoneDomin {
+			e.RecordName = "@"
+		} else {
+			suffix := "." + zoneDomin
+			if strings.HasSuffix(recNameClean, suffix) {
+				e.RecordName = recNameClean[:len(recNameClean)-len(suffix)]
+			}
+		}
 	}
-	return entries, total, rows.Err()
+	return e, nil
 }
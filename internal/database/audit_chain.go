@@ -0,0 +1,430 @@
+package database
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"ns116/internal/model"
+)
+
+// auditChainLock serialises writers of the audit chain so every row links to
+// the one committed before it.
+const auditChainLock = `SELECT pg_advisory_xact_lock(hashtext('ns116.audit_log'))`
+
+const auditChainSelect = `SELECT id, created_at, username, action, zone_id, record_name, record_type, detail, ip_address,
+	 before_state, after_state, status, error, change_id, auth_method, request_id, cert_fingerprint, prev_hash, hash
+	 FROM audit_log`
+
+// chainRow is an audit row exactly as stored, which is what the hash covers.
+type chainRow struct {
+	ID              int64
+	CreatedAt       time.Time
+	Username        string
+	Action          string
+	ZoneID          string
+	RecordName      string
+	RecordType      string
+	Detail          string
+	IPAddress       string
+	Before          string
+	After           string
+	Status          string
+	Error           string
+	ChangeID        string
+	AuthMethod      string
+	RequestID       string
+	CertFingerprint string
+	PrevHash        string
+	Hash            string
+}
+
+func scanChainRow(row interface{ Scan(...any) error }) (chainRow, error) {
+	var c chainRow
+	var zoneID, recordName, recordType, detail, ip sql.NullString
+	var before, after, errMsg, changeID, authMethod, requestID, certFingerprint, prevHash, hash sql.NullString
+	err := row.Scan(&c.ID, &c.CreatedAt, &c.Username, &c.Action, &zoneID, &recordName, &recordType,
+		&detail, &ip, &before, &after, &c.Status, &errMsg, &changeID, &authMethod, &requestID, &certFingerprint, &prevHash, &hash)
+	c.ZoneID, c.RecordName, c.RecordType = zoneID.String, recordName.String, recordType.String
+	c.Detail, c.IPAddress = detail.String, ip.String
+	c.Before, c.After = before.String, after.String
+	c.Error, c.ChangeID, c.AuthMethod = errMsg.String, changeID.String, authMethod.String
+	c.RequestID, c.CertFingerprint = requestID.String, certFingerprint.String
+	c.PrevHash, c.Hash = prevHash.String, hash.String
+	return c, err
+}
+
+// hash computes the row's chain hash: SHA-256 over a canonical JSON encoding
+// of its content and the previous row's hash. Optional fields are omitted
+// when empty so columns added later do not invalidate older rows.
+func (c chainRow) hash() string {
+	payload := struct {
+		ID              int64           `json:"id"`
+		CreatedAt       string          `json:"created_at"`
+		Username        string          `json:"username"`
+		Action          string          `json:"action"`
+		ZoneID          string          `json:"zone_id,omitempty"`
+		RecordName      string          `json:"record_name,omitempty"`
+		RecordType      string          `json:"record_type,omitempty"`
+		Detail          string          `json:"detail,omitempty"`
+		IPAddress       string          `json:"ip_address,omitempty"`
+		Before          json.RawMessage `json:"before,omitempty"`
+		After           json.RawMessage `json:"after,omitempty"`
+		Status          string          `json:"status"`
+		Error           string          `json:"error,omitempty"`
+		ChangeID        string          `json:"change_id,omitempty"`
+		AuthMethod      string          `json:"auth_method,omitempty"`
+		RequestID       string          `json:"request_id,omitempty"`
+		CertFingerprint string          `json:"cert_fingerprint,omitempty"`
+		PrevHash        string          `json:"prev_hash"`
+	}{
+		c.ID, c.CreatedAt.Format("2006-01-02T15:04:05.000000"), c.Username, c.Action,
+		c.ZoneID, c.RecordName, c.RecordType, c.Detail, c.IPAddress,
+		canonicalJSON(c.Before), canonicalJSON(c.After),
+		c.Status, c.Error, c.ChangeID, c.AuthMethod, c.RequestID, c.CertFingerprint, c.PrevHash,
+	}
+	b, _ := json.Marshal(payload)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON re-encodes a JSON document with sorted keys and no
+// insignificant whitespace, so the value written and the value PostgreSQL
+// returns from a JSONB column hash identically.
+func canonicalJSON(s string) json.RawMessage {
+	if s == "" {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return json.RawMessage(s)
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// entry converts the stored row to an AuditEntry without any of the display
+// adjustments scanAuditEntry makes, for archives that must stay verifiable.
+func (c chainRow) entry() model.AuditEntry {
+	e := model.AuditEntry{
+		ID: c.ID, Username: c.Username, Action: c.Action, ZoneID: c.ZoneID,
+		RecordName: c.RecordName, RecordType: c.RecordType, Detail: c.Detail,
+		IPAddress: c.IPAddress, CreatedAt: c.CreatedAt, Status: c.Status,
+		Error: c.Error, ChangeID: c.ChangeID, AuthMethod: c.AuthMethod,
+		RequestID: c.RequestID, CertFingerprint: c.CertFingerprint, PrevHash: c.PrevHash, Hash: c.Hash,
+	}
+	if c.Before != "" {
+		e.Before = json.RawMessage(c.Before)
+	}
+	if c.After != "" {
+		e.After = json.RawMessage(c.After)
+	}
+	return e
+}
+
+// sealAuditLog links the rows written before the hash chain existed into it.
+// It runs once; afterwards a row without a hash is reported as tampering.
+func (db *DB) sealAuditLog(ctx context.Context) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, auditChainLock); err != nil {
+		return err
+	}
+	var sealed int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM settings WHERE key = 'audit_chain_sealed'").Scan(&sealed); err != nil {
+		return err
+	}
+	if sealed > 0 {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, auditChainSelect+" ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var pending []chainRow
+	for rows.Next() {
+		c, err := scanChainRow(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	prev := ""
+	for _, c := range pending {
+		c.PrevHash = prev
+		c.Hash = c.hash()
+		if _, err := tx.ExecContext(ctx, "UPDATE audit_log SET prev_hash = $1, hash = $2 WHERE id = $3",
+			c.PrevHash, c.Hash, c.ID); err != nil {
+			return fmt.Errorf("sealing audit entry %d: %w", c.ID, err)
+		}
+		prev = c.Hash
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO settings (key, value) VALUES ('audit_chain_sealed', $1)",
+		time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		slog.Info("sealed existing audit entries into the hash chain", "count", len(pending))
+	}
+	return nil
+}
+
+// GetAuditCheckpoint returns the latest signed checkpoint, or nil if none has
+// been written yet.
+func (db *DB) GetAuditCheckpoint(ctx context.Context) (*model.AuditCheckpoint, error) {
+	return db.getCheckpoint(ctx, "audit_checkpoint")
+}
+
+// GetAuditAnchor returns the signed link to the last entry removed by
+// retention, or nil if the chain still starts at its first entry.
+func (db *DB) GetAuditAnchor(ctx context.Context) (*model.AuditCheckpoint, error) {
+	return db.getCheckpoint(ctx, "audit_chain_anchor")
+}
+
+func (db *DB) getCheckpoint(ctx context.Context, key string) (*model.AuditCheckpoint, error) {
+	v, err := db.GetSetting(ctx, key)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	var cp model.AuditCheckpoint
+	if err := json.Unmarshal([]byte(v), &cp); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return &cp, nil
+}
+
+// chainStart returns where the stored chain begins: after the retention
+// anchor if rows have been archived, otherwise at the genesis entry.
+func (db *DB) chainStart(ctx context.Context, key []byte) (int64, string, error) {
+	anchor, err := db.GetAuditAnchor(ctx)
+	if err != nil || anchor == nil {
+		return 0, "", err
+	}
+	if !validCheckpoint(key, anchor) {
+		return 0, "", fmt.Errorf("audit chain anchor has an invalid signature")
+	}
+	return anchor.ID, anchor.Hash, nil
+}
+
+// WriteAuditCheckpoint verifies the entries added since the previous
+// checkpoint and signs the new head of the chain with key. It returns nil
+// when nothing was logged since the last checkpoint.
+func (db *DB) WriteAuditCheckpoint(ctx context.Context, key []byte) (*model.AuditCheckpoint, error) {
+	prev, err := db.GetAuditCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	afterID, prevHash, err := db.chainStart(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		if !validCheckpoint(key, prev) {
+			return nil, fmt.Errorf("previous audit checkpoint has an invalid signature")
+		}
+		if prev.ID > afterID {
+			afterID, prevHash = prev.ID, prev.Hash
+		}
+	}
+
+	res, err := db.walkAuditChain(ctx, afterID, prevHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK() {
+		return nil, fmt.Errorf("audit chain broken at entry %d: %s", res.BrokenID, res.Reason)
+	}
+	if res.Checked == 0 {
+		return nil, nil
+	}
+
+	var hash string
+	if err := db.conn.QueryRowContext(ctx, "SELECT hash FROM audit_log WHERE id = $1", res.LastID).Scan(&hash); err != nil {
+		return nil, err
+	}
+	cp := &model.AuditCheckpoint{ID: res.LastID, Hash: hash, CreatedAt: time.Now().UTC()}
+	cp.Signature = signCheckpoint(key, cp)
+
+	b, _ := json.Marshal(cp)
+	if err := db.SetSetting(ctx, "audit_checkpoint", string(b)); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// VerifyAuditLog walks the whole audit chain and checks it against the
+// latest signed checkpoint, stopping at the first broken link.
+func (db *DB) VerifyAuditLog(ctx context.Context, key []byte) (model.AuditVerification, error) {
+	cp, err := db.GetAuditCheckpoint(ctx)
+	if err != nil {
+		return model.AuditVerification{}, err
+	}
+	if cp != nil && !validCheckpoint(key, cp) {
+		return model.AuditVerification{Checkpoint: cp, Reason: "checkpoint signature is invalid"}, nil
+	}
+	afterID, prevHash, err := db.chainStart(ctx, key)
+	if err != nil {
+		return model.AuditVerification{Checkpoint: cp, Reason: err.Error()}, nil
+	}
+	if cp != nil && cp.ID <= afterID {
+		// The checkpointed entry has been archived; the anchor vouches for it
+		if cp.ID == afterID && cp.Hash != prevHash {
+			return model.AuditVerification{Checkpoint: cp, BrokenID: cp.ID,
+				Reason: "retention anchor does not match the signed checkpoint"}, nil
+		}
+		cp = nil
+	}
+	res, err := db.walkAuditChain(ctx, afterID, prevHash, cp)
+	if res.Checkpoint == nil {
+		res.Checkpoint, _ = db.GetAuditCheckpoint(ctx)
+	}
+	return res, err
+}
+
+// walkAuditChain verifies the rows after afterID, expecting the first of them
+// to link to prev.
+func (db *DB) walkAuditChain(ctx context.Context, afterID int64, prev string, cp *model.AuditCheckpoint) (model.AuditVerification, error) {
+	res := model.AuditVerification{Checkpoint: cp, LastID: afterID}
+	rows, err := db.conn.QueryContext(ctx, auditChainSelect+" WHERE id > $1 ORDER BY id", afterID)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	seenCheckpoint := cp == nil
+	for rows.Next() {
+		c, err := scanChainRow(rows)
+		if err != nil {
+			return res, err
+		}
+		res.Checked++
+
+		switch {
+		case c.Hash == "":
+			res.Reason = "entry has no hash"
+		case c.PrevHash != prev:
+			res.Reason = "previous hash does not match; an earlier entry was removed or altered"
+		case c.hash() != c.Hash:
+			res.Reason = "content does not match its hash; the entry was modified"
+		case cp != nil && c.ID == cp.ID && c.Hash != cp.Hash:
+			res.Reason = "entry does not match the signed checkpoint"
+		}
+		if res.Reason != "" {
+			res.BrokenID = c.ID
+			return res, nil
+		}
+		if cp != nil && c.ID == cp.ID {
+			seenCheckpoint = true
+		}
+		prev = c.Hash
+		res.LastID = c.ID
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+
+	if !seenCheckpoint {
+		res.BrokenID = cp.ID
+		res.Reason = "checkpointed entry is missing; the log was truncated"
+	}
+	return res, nil
+}
+
+// AuditArchiveBound returns the ID of the newest entry older than the given
+// number of days, or 0 if there is none. It stays below the oldest entry
+// still waiting in the audit outbox, so no pending delivery is lost.
+func (db *DB) AuditArchiveBound(ctx context.Context, days int) (int64, error) {
+	var id sql.NullInt64
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT MAX(id) FROM audit_log
+		 WHERE created_at < LOCALTIMESTAMP - make_interval(days => $1)
+		   AND id < COALESCE((SELECT MIN(audit_id) FROM audit_outbox), id + 1)`,
+		days).Scan(&id)
+	return id.Int64, err
+}
+
+// StreamAuditRange calls fn for every stored entry with an ID up to and
+// including toID, oldest first, exactly as stored.
+func (db *DB) StreamAuditRange(ctx context.Context, toID int64, fn func(model.AuditEntry) error) error {
+	rows, err := db.conn.QueryContext(ctx, auditChainSelect+" WHERE id <= $1 ORDER BY id", toID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanChainRow(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(c.entry()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PurgeAuditLog deletes every entry up to and including toID and records a
+// signed anchor to the last one, so the remaining chain still verifies. It
+// returns the number of entries deleted.
+func (db *DB) PurgeAuditLog(ctx context.Context, toID int64, key []byte) (int64, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, auditChainLock); err != nil {
+		return 0, err
+	}
+	anchor := &model.AuditCheckpoint{ID: toID, CreatedAt: time.Now().UTC()}
+	if err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_log WHERE id = $1", toID).Scan(&anchor.Hash); err != nil {
+		return 0, fmt.Errorf("reading last archived entry: %w", err)
+	}
+	anchor.Signature = signCheckpoint(key, anchor)
+
+	// The append-only trigger only lets the table owner delete, through
+	// this function
+	var n int64
+	if err := tx.QueryRowContext(ctx, "SELECT audit_log_purge($1)", toID).Scan(&n); err != nil {
+		return 0, err
+	}
+
+	b, _ := json.Marshal(anchor)
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO settings (key, value) VALUES ('audit_chain_anchor', $1) ON CONFLICT(key) DO UPDATE SET value = $2",
+		string(b), string(b)); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func validCheckpoint(key []byte, cp *model.AuditCheckpoint) bool {
+	return hmac.Equal([]byte(cp.Signature), []byte(signCheckpoint(key, cp)))
+}
+
+func signCheckpoint(key []byte, cp *model.AuditCheckpoint) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%s:%s", cp.ID, cp.Hash, cp.CreatedAt.UTC().Format(time.RFC3339Nano))
+	return hex.EncodeToString(mac.Sum(nil))
+}
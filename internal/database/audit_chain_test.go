@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+func TestPurgeAuditLog(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	key := []byte("test key")
+	for range 3 {
+		if err := db.LogAudit(ctx, model.AuditEntry{Username: "alice", Action: "login"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A full purge leaves the next entry linked to the anchor
+	n, err := db.PurgeAuditLog(ctx, 3, key)
+	if err != nil || n != 3 {
+		t.Fatalf("PurgeAuditLog = %d, %v; want 3 deleted", n, err)
+	}
+	if err := db.LogAudit(ctx, model.AuditEntry{Username: "alice", Action: "logout"}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := db.VerifyAuditLog(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() || v.Checked != 1 {
+		t.Errorf("verification after purge = %+v", v)
+	}
+
+	// Plain deletes are refused, even by the owner
+	if _, err := db.conn.ExecContext(ctx, "DELETE FROM audit_log"); err == nil {
+		t.Error("DELETE without audit_log_purge succeeded")
+	}
+}
+
+func TestAuditLogDeleteBypass(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if err := db.LogAudit(ctx, model.AuditEntry{Username: "alice", Action: "login"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	role := "ns116_test_app_" + hex.EncodeToString(buf)
+	if _, err := db.conn.ExecContext(ctx, "CREATE ROLE "+role); err != nil {
+		t.Skipf("cannot create a role: %v", err)
+	}
+	t.Cleanup(func() {
+		db.conn.ExecContext(ctx, "DROP OWNED BY "+role)
+		db.conn.ExecContext(ctx, "DROP ROLE "+role)
+	})
+	// Even a role granted DELETE outright cannot get past the trigger
+	for _, stmt := range []string{
+		"GRANT USAGE ON SCHEMA " + schemaOf(t, db) + " TO " + role,
+		"GRANT SELECT, DELETE ON audit_log TO " + role,
+	} {
+		if _, err := db.conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{"SET LOCAL ROLE " + role, "SET LOCAL ns116.audit_allow_delete = 'on'"} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM audit_log"); err == nil {
+		t.Error("DELETE with ns116.audit_allow_delete set by another role succeeded")
+	}
+	tx.Rollback()
+
+	// Nor can it purge through the function without being granted EXECUTE
+	tx, err = db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "SET LOCAL ROLE "+role); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT audit_log_purge(1000)"); err == nil {
+		t.Error("audit_log_purge callable by a role without EXECUTE")
+	}
+}
+
+func schemaOf(t *testing.T, db *DB) string {
+	t.Helper()
+	var schema string
+	if err := db.conn.QueryRowContext(context.Background(), "SELECT current_schema()").Scan(&schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
@@ -1,35 +1,53 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"ns116/internal/metrics"
 	"ns116/internal/model"
+	"ns116/internal/tracing"
 )
 
 const cacheTTL = 5 * time.Minute
 
-func (db *DB) CacheZones(zones []model.HostedZone) error {
-	tx, err := db.conn.Begin()
+func (db *DB) CacheZones(ctx context.Context, zones []model.HostedZone) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, _ = tx.Exec("DELETE FROM zones_cache")
-	stmt, err := tx.Prepare(`INSERT INTO zones_cache (zone_id, name, record_count, comment, label) VALUES ($1, $2, $3, $4, $5)`)
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM zones_cache"); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO zones_cache (zone_id, name, record_count, comment, label) VALUES ($1, $2, $3, $4, $5)`)
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
 	for _, z := range zones {
-		_, _ = stmt.Exec(z.ID, z.Name, z.RecordCount, z.Comment, z.Label)
+		if _, err := stmt.ExecContext(ctx, z.ID, z.Name, z.RecordCount, z.Comment, z.Label); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-func (db *DB) GetCachedZones() ([]model.HostedZone, bool) {
+func (db *DB) GetCachedZones(ctx context.Context) ([]model.HostedZone, bool) {
+	ctx, done := tracing.CacheLookup(ctx, "zones")
+	zones, ok := db.cachedZones(ctx)
+	done(ok)
+	metrics.CacheLookup("zones", ok)
+	return zones, ok
+}
+
+func (db *DB) cachedZones(ctx context.Context) ([]model.HostedZone, bool) {
 	var cachedAt time.Time
-	err := db.conn.QueryRow("SELECT cached_at FROM zones_cache LIMIT 1").Scan(&cachedAt)
+	err := db.conn.QueryRowContext(ctx, "SELECT cached_at FROM zones_cache LIMIT 1").Scan(&cachedAt)
 	if err != nil {
 		return nil, false
 	}
@@ -38,7 +56,7 @@ func (db *DB) GetCachedZones() ([]model.HostedZone, bool) {
 		return nil, false
 	}
 
-	rows, err := db.conn.Query("SELECT zone_id, name, record_count, comment, label FROM zones_cache")
+	rows, err := db.conn.QueryContext(ctx, "SELECT zone_id, name, record_count, comment, label FROM zones_cache")
 	if err != nil {
 		return nil, false
 	}
@@ -55,19 +73,21 @@ func (db *DB) GetCachedZones() ([]model.HostedZone, bool) {
 	return zones, len(zones) > 0
 }
 
-func (db *DB) CacheRecords(zoneID string, records []model.DNSRecord) error {
-	tx, err := db.conn.Begin()
+func (db *DB) CacheRecords(ctx context.Context, zoneID string, records []model.DNSRecord) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	// Use $1 for parameter
-	_, _ = tx.Exec("DELETE FROM dns_cache WHERE zone_id = $1", zoneID)
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM dns_cache WHERE zone_id = $1", zoneID); err != nil {
+		return err
+	}
 
-	stmt, err := tx.Prepare(`INSERT INTO dns_cache
-		(zone_id, record_name, record_type, ttl, values_json, is_alias, alias_target, alias_zone_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO dns_cache
+		(zone_id, record_name, record_type, ttl, values_json, is_alias, alias_target, alias_zone_id,
+		 set_identifier, routing_json, evaluate_target_health)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`)
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
@@ -77,14 +97,34 @@ func (db *DB) CacheRecords(zoneID string, records []model.DNSRecord) error {
 		if r.IsAlias {
 			isAlias = 1
 		}
-		_, _ = stmt.Exec(zoneID, r.Name, r.Type, r.TTL, string(vJSON), isAlias, r.AliasTarget, r.AliasZoneID)
+		evalHealth := 0
+		if r.EvaluateTargetHealth {
+			evalHealth = 1
+		}
+		var routingJSON any
+		if r.RoutingPolicy != (model.RoutingPolicy{}) {
+			b, _ := json.Marshal(r.RoutingPolicy)
+			routingJSON = string(b)
+		}
+		if _, err := stmt.ExecContext(ctx, zoneID, r.Name, r.Type, r.TTL, string(vJSON), isAlias, r.AliasTarget, r.AliasZoneID,
+			r.SetIdentifier, routingJSON, evalHealth); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-func (db *DB) GetCachedRecords(zoneID string) ([]model.DNSRecord, bool) {
+func (db *DB) GetCachedRecords(ctx context.Context, zoneID string) ([]model.DNSRecord, bool) {
+	ctx, done := tracing.CacheLookup(ctx, "records", attribute.String("ns116.zone_id", zoneID))
+	records, ok := db.cachedRecords(ctx, zoneID)
+	done(ok)
+	metrics.CacheLookup("records", ok)
+	return records, ok
+}
+
+func (db *DB) cachedRecords(ctx context.Context, zoneID string) ([]model.DNSRecord, bool) {
 	var cachedAt time.Time
-	err := db.conn.QueryRow("SELECT cached_at FROM dns_cache WHERE zone_id = $1 LIMIT 1", zoneID).Scan(&cachedAt)
+	err := db.conn.QueryRowContext(ctx, "SELECT cached_at FROM dns_cache WHERE zone_id = $1 LIMIT 1", zoneID).Scan(&cachedAt)
 	if err != nil {
 		return nil, false
 	}
@@ -93,9 +133,10 @@ func (db *DB) GetCachedRecords(zoneID string) ([]model.DNSRecord, bool) {
 		return nil, false
 	}
 
-	rows, err := db.conn.Query(
-		`SELECT record_name, record_type, ttl, values_json, is_alias, alias_target, alias_zone_id
-		 FROM dns_cache WHERE zone_id = $1`, zoneID)
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT record_name, record_type, ttl, values_json, is_alias, alias_target, alias_zone_id,
+		        set_identifier, routing_json, evaluate_target_health
+		 FROM dns_cache WHERE zone_id = $1 ORDER BY id`, zoneID)
 	if err != nil {
 		return nil, false
 	}
@@ -105,22 +146,32 @@ func (db *DB) GetCachedRecords(zoneID string) ([]model.DNSRecord, bool) {
 	for rows.Next() {
 		var r model.DNSRecord
 		var vJSON string
-		var isAlias int
-		if err := rows.Scan(&r.Name, &r.Type, &r.TTL, &vJSON, &isAlias, &r.AliasTarget, &r.AliasZoneID); err != nil {
+		var isAlias, evalHealth int
+		var routingJSON sql.NullString
+		if err := rows.Scan(&r.Name, &r.Type, &r.TTL, &vJSON, &isAlias, &r.AliasTarget, &r.AliasZoneID,
+			&r.SetIdentifier, &routingJSON, &evalHealth); err != nil {
 			return nil, false
 		}
 		_ = json.Unmarshal([]byte(vJSON), &r.Values)
+		if routingJSON.Valid {
+			_ = json.Unmarshal([]byte(routingJSON.String), &r.RoutingPolicy)
+		}
 		r.IsAlias = isAlias == 1
+		r.EvaluateTargetHealth = evalHealth == 1
 		records = append(records, r)
 	}
 	return records, len(records) > 0
 }
 
-func (db *DB) InvalidateRecordCache(zoneID string) {
-	_, _ = db.conn.Exec("DELETE FROM dns_cache WHERE zone_id = $1", zoneID)
+func (db *DB) InvalidateRecordCache(ctx context.Context, zoneID string) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM dns_cache WHERE zone_id = $1", zoneID)
+	return err
 }
 
-func (db *DB) InvalidateAllCache() {
-	_, _ = db.conn.Exec("DELETE FROM dns_cache")
-	_, _ = db.conn.Exec("DELETE FROM zones_cache")
+func (db *DB) InvalidateAllCache(ctx context.Context) error {
+	if _, err := db.conn.ExecContext(ctx, "DELETE FROM dns_cache"); err != nil {
+		return err
+	}
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM zones_cache")
+	return err
 }
@@ -1,121 +1,138 @@
 package database
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"time"
 
-	"io/fs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
-	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL Driver
+	"ns116/internal/tracing"
 )
 
 type DB struct {
 	conn *sql.DB
+
+	// auditSinks are the sink names each new audit entry is queued for
+	auditSinks []string
+	// notifications enables queueing of email notifications
+	notifications bool
+	// schemaVersion is the migration version applied at startup
+	schemaVersion uint
 }
 
-func Open(dsn string, migrationsFS fs.FS) (*DB, error) {
-	conn, err := sql.Open("pgx", dsn)
+// Open connects to the database and brings the schema to the newest
+// migration in migrationsFS. Without autoMigrate the schema must already be
+// current, so deployments can run "ns116 migrate up" as a separate step.
+func Open(ctx context.Context, dsn string, migrationsFS fs.FS, autoMigrate bool) (*DB, error) {
+	db, err := Connect(ctx, dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
+	}
+	m, err := db.Migrator(migrationsFS)
+	if err == nil && autoMigrate {
+		err = m.Up()
 	}
+	if err == nil {
+		db.schemaVersion, err = m.CheckCurrent()
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migration failed: %w", err)
+	}
+	if autoMigrate {
+		slog.Info("database migrations applied", "version", db.schemaVersion)
+	}
+
+	if err := db.sealAuditLog(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to seal audit log: %w", err)
+	}
+	return db, nil
+}
+
+// Connect opens the connection pool without touching the schema, for the
+// migrate subcommand.
+func Connect(ctx context.Context, dsn string) (*DB, error) {
+	pgxCfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database DSN: %w", err)
+	}
+	pgxCfg.Tracer = tracing.QueryTracer{}
+	conn := stdlib.OpenDB(*pgxCfg)
 
 	// Recommended pool configuration for production
 	conn.SetMaxOpenConns(25)
 	conn.SetMaxIdleConns(25)
 	conn.SetConnMaxLifetime(5 * time.Minute)
 
-	if err := conn.Ping(); err != nil {
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-
-	// Run Migrations
-	if err := runMigrations(conn, dsn, migrationsFS); err != nil {
-		return nil, fmt.Errorf("migration failed: %w", err)
-	}
-
 	return &DB{conn: conn}, nil
 }
 
-func runMigrations(conn *sql.DB, dsn string, migrationsFS fs.FS) error {
-	driver, err := postgres.WithInstance(conn, &postgres.Config{})
+// Ready checks that the database answers and its schema is still at the
+// version applied at startup, with no migration left half-done.
+func (db *DB) Ready(ctx context.Context) error {
+	var version int64
+	var dirty bool
+	err := db.conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty)
 	if err != nil {
-		return fmt.Errorf("could not create migration driver: %w", err)
-	}
-
-	var m *migrate.Migrate
-
-	if migrationsFS != nil {
-		// Use embedded migrations
-		d, err := iofs.New(migrationsFS, "migrations")
-		if err != nil {
-			return fmt.Errorf("could not create iofs source: %w", err)
-		}
-		m, err = migrate.NewWithInstance(
-			"iofs",
-			d,
-			"postgres",
-			driver,
-		)
-	} else {
-		// Fallback to file system (useful for dev without build)
-		m, err = migrate.NewWithDatabaseInstance(
-			"file://db/migrations",
-			"postgres",
-			driver,
-		)
+		return err
 	}
-
-	if err != nil {
-		return fmt.Errorf("could not create migrate instance: %w", err)
+	if dirty {
+		return fmt.Errorf("migration %d is dirty", version)
 	}
-
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("an error occurred while syncing the database: %w", err)
+	if uint(version) != db.schemaVersion {
+		return fmt.Errorf("schema is at version %d, expected %d", version, db.schemaVersion)
 	}
-
-	log.Println("Database migrations applied successfully")
 	return nil
 }
 
+// Stats returns connection pool statistics.
+func (db *DB) Stats() sql.DBStats {
+	return db.conn.Stats()
+}
+
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
-func (db *DB) HasUsers() (bool, error) {
+func (db *DB) HasUsers(ctx context.Context) (bool, error) {
 	var count int
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
 	return count > 0, err
 }
 
-func (db *DB) GetSetting(key string) (string, error) {
+func (db *DB) GetSetting(ctx context.Context, key string) (string, error) {
 	var value string
 	// Updated for Postgres placeholders
-	err := db.conn.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	err := db.conn.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = $1", key).Scan(&value)
 	if err == sql.ErrNoRows {
 		return "", nil
 	}
 	return value, err
 }
 
-func (db *DB) SetSetting(key, value string) error {
+func (db *DB) SetSetting(ctx context.Context, key, value string) error {
 	// Updated for Postgres upsert syntax and placeholders
-	_, err := db.conn.Exec(
+	_, err := db.conn.ExecContext(ctx,
 		"INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT(key) DO UPDATE SET value = $3",
 		key, value, value,
 	)
 	return err
 }
 
-func (db *DB) EnsureSessionSecret() (string, error) {
-	secret, err := db.GetSetting("session_secret")
+func (db *DB) EnsureSessionSecret(ctx context.Context) (string, error) {
+	secret, err := db.GetSetting(ctx, "session_secret")
 	if err != nil {
 		return "", err
 	}
@@ -127,9 +144,9 @@ func (db *DB) EnsureSessionSecret() (string, error) {
 		return "", fmt.Errorf("failed to generate session secret: %w", err)
 	}
 	secret = hex.EncodeToString(b)
-	if err := db.SetSetting("session_secret", secret); err != nil {
+	if err := db.SetSetting(ctx, "session_secret", secret); err != nil {
 		return "", err
 	}
-	log.Println("Generated new session secret")
+	slog.Info("generated new session secret")
 	return secret, nil
 }
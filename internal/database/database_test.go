@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// openTestDB connects to the PostgreSQL server in NS116_TEST_DSN and
+// migrates a fresh schema that is dropped when the test ends. Tests that
+// need it are skipped without NS116_TEST_DSN.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	dsn := os.Getenv("NS116_TEST_DSN")
+	if dsn == "" {
+		t.Skip("NS116_TEST_DSN is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	schema := "ns116_test_" + hex.EncodeToString(buf)
+	if _, err := admin.conn.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.conn.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	db, err := Open(ctx, dsn, os.DirFS("../../db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// Migrator applies the schema migrations in the migrations directory of an
+// fs.FS.
+type Migrator struct {
+	m      *migrate.Migrate
+	source source.Driver
+}
+
+// Migrator prepares the migrations in migrationsFS for this database. A nil
+// migrationsFS reads db/migrations from the working directory, which is
+// useful during development.
+func (db *DB) Migrator(migrationsFS fs.FS) (*Migrator, error) {
+	if migrationsFS == nil {
+		migrationsFS = os.DirFS("db")
+	}
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not create iofs source: %w", err)
+	}
+	driver, err := postgres.WithInstance(db.conn, &postgres.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create migration driver: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		return nil, fmt.Errorf("could not create migrate instance: %w", err)
+	}
+	return &Migrator{m: m, source: src}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() error {
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("an error occurred while syncing the database: %w", err)
+	}
+	return nil
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(steps int) error {
+	if err := m.m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Force records version as applied and clears the dirty flag without running
+// any migration, to recover after a migration failed halfway. -1 means no
+// migration is applied.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// Version returns the applied schema version, 0 when none is, and whether
+// the last migration failed halfway.
+func (m *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Latest returns the newest migration version available.
+func (m *Migrator) Latest() (uint, error) {
+	version, err := m.source.First()
+	if err != nil {
+		return 0, fmt.Errorf("no migrations found: %w", err)
+	}
+	for {
+		next, err := m.source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// CheckCurrent returns the applied schema version, failing unless it is the
+// newest one and no migration was left half-done.
+func (m *Migrator) CheckCurrent() (uint, error) {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return 0, fmt.Errorf("could not read migration version: %w", err)
+	}
+	latest, err := m.Latest()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case dirty:
+		return 0, fmt.Errorf("migration %d failed halfway; fix the schema and run ns116 migrate force %d", version, version)
+	case version < latest:
+		return 0, fmt.Errorf("schema is at version %d, this release needs %d; run ns116 migrate up", version, latest)
+	case version > latest:
+		return 0, fmt.Errorf("schema version %d is newer than this release supports (%d)", version, latest)
+	}
+	return version, nil
+}
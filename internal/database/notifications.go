@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"ns116/internal/model"
+)
+
+// digestEntryLimit caps the entries listed in one digest email.
+const digestEntryLimit = 200
+
+// ConfigureNotifications enables queueing of email notifications for new
+// audit entries. It is left off when no SMTP server is configured so the
+// queue does not grow without anyone sending it.
+func (db *DB) ConfigureNotifications(enabled bool) {
+	db.notifications = enabled
+}
+
+func (db *DB) ListNotificationSubscriptions(ctx context.Context, userID int64) ([]model.NotificationSubscription, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT zone_id, mode, created_at FROM notification_subscriptions WHERE user_id = $1 ORDER BY zone_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []model.NotificationSubscription
+	for rows.Next() {
+		var s model.NotificationSubscription
+		if err := rows.Scan(&s.ZoneID, &s.Mode, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// SetNotificationSubscription subscribes the user to a zone ("" for all
+// zones) or changes the mode of an existing subscription.
+func (db *DB) SetNotificationSubscription(ctx context.Context, userID int64, zoneID, mode string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO notification_subscriptions (user_id, zone_id, mode) VALUES ($1, $2, $3)
+		 ON CONFLICT (user_id, zone_id) DO UPDATE SET mode = $3`,
+		userID, zoneID, mode)
+	return err
+}
+
+func (db *DB) DeleteNotificationSubscription(ctx context.Context, userID int64, zoneID string) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM notification_subscriptions WHERE user_id = $1 AND zone_id = $2", userID, zoneID)
+	return err
+}
+
+// enqueueNotifications queues an instant email for every active user with an
+// address who subscribed to the event's zone, except the user who made the
+// change.
+func enqueueNotifications(ctx context.Context, tx *sql.Tx, ev model.WebhookEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO notification_outbox (user_id, kind, payload)
+		 SELECT DISTINCT u.id, 'event', $1::text FROM notification_subscriptions s
+		   JOIN users u ON u.id = s.user_id
+		  WHERE s.mode = 'instant' AND (s.zone_id = '' OR s.zone_id = $2)
+		    AND u.active = 1 AND u.email <> '' AND u.username <> $3`,
+		string(payload), ev.ZoneID, ev.Actor)
+	return err
+}
+
+// digestState records the last digest so each audit entry is summarised once
+// even with several instances running.
+type digestState struct {
+	Day    string    `json:"day"`
+	LastID int64     `json:"last_id"`
+	At     time.Time `json:"at"`
+}
+
+// QueueNotificationDigests queues the digest for day (YYYY-MM-DD) unless it
+// was already queued, covering every audit entry since the previous digest
+// (or the last 24 hours for the first one). It returns the number of emails
+// queued; users without activity in their digest zones get none.
+func (db *DB) QueueNotificationDigests(ctx context.Context, day string) (int, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('ns116.notification_digest'))"); err != nil {
+		return 0, err
+	}
+	var prev digestState
+	var raw string
+	err = tx.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = 'notification_digest'").Scan(&raw)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &prev); err != nil {
+			return 0, err
+		}
+	}
+	if prev.Day >= day {
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+	next := digestState{Day: day, At: now}
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM audit_log").Scan(&next.LastID); err != nil {
+		return 0, err
+	}
+	if prev.Day == "" {
+		prev.At = now.Add(-24 * time.Hour)
+		err = tx.QueryRowContext(ctx,
+			"SELECT COALESCE(MIN(id) - 1, $1) FROM audit_log WHERE created_at > LOCALTIMESTAMP - INTERVAL '1 day'",
+			next.LastID).Scan(&prev.LastID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT DISTINCT s.user_id, a.id, a.username, a.action, a.zone_id, COALESCE(zc.name, ''),
+		        COALESCE(a.record_name, ''), COALESCE(a.record_type, ''), COALESCE(a.detail, ''), a.created_at
+		   FROM notification_subscriptions s
+		   JOIN users u ON u.id = s.user_id AND u.active = 1 AND u.email <> ''
+		   JOIN audit_log a ON a.id > $1 AND a.id <= $2 AND a.status = 'success'
+		    AND a.zone_id <> '' AND (s.zone_id = '' OR s.zone_id = a.zone_id)
+		   LEFT JOIN zones_cache zc ON zc.zone_id = a.zone_id
+		  WHERE s.mode = 'digest'
+		  ORDER BY s.user_id, a.id`, prev.LastID, next.LastID)
+	if err != nil {
+		return 0, err
+	}
+	digests := make(map[int64]*model.NotificationDigest)
+	var order []int64
+	for rows.Next() {
+		var userID int64
+		var e model.AuditEntry
+		if err := rows.Scan(&userID, &e.ID, &e.Username, &e.Action, &e.ZoneID, &e.ZoneName,
+			&e.RecordName, &e.RecordType, &e.Detail, &e.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		d, ok := digests[userID]
+		if !ok {
+			d = &model.NotificationDigest{Since: prev.At}
+			digests[userID] = d
+			order = append(order, userID)
+		}
+		if len(d.Entries) < digestEntryLimit {
+			d.Entries = append(d.Entries, e)
+		} else {
+			d.More++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, userID := range order {
+		payload, err := json.Marshal(digests[userID])
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO notification_outbox (user_id, kind, payload) VALUES ($1, 'digest', $2)",
+			userID, string(payload)); err != nil {
+			return 0, err
+		}
+	}
+
+	state, err := json.Marshal(next)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO settings (key, value) VALUES ('notification_digest', $1)
+		 ON CONFLICT (key) DO UPDATE SET value = $1`, string(state)); err != nil {
+		return 0, err
+	}
+	return len(order), tx.Commit()
+}
+
+// ClaimNotifications leases up to limit due emails for leaseSeconds so
+// concurrent notifiers skip them. The recipient's current address is
+// returned with each.
+func (db *DB) ClaimNotifications(ctx context.Context, limit, leaseSeconds int) ([]model.Notification, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`UPDATE notification_outbox o SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		   FROM users u
+		  WHERE u.id = o.user_id AND o.id IN (
+		    SELECT id FROM notification_outbox WHERE next_attempt_at <= NOW()
+		     ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		 RETURNING o.id, o.user_id, u.username, u.email, o.kind, o.payload, o.attempts`, limit, leaseSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.Notification
+	for rows.Next() {
+		var n model.Notification
+		var payload string
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Username, &n.Email, &n.Kind, &payload, &n.Attempts); err != nil {
+			return nil, err
+		}
+		n.Payload = json.RawMessage(payload)
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// RecordNotificationAttempt removes a sent email, or schedules a retry with
+// exponential backoff. After maxAttempts failures the email is dropped and
+// dropped is true.
+func (db *DB) RecordNotificationAttempt(ctx context.Context, id int64, sendErr error, maxAttempts int) (dropped bool, err error) {
+	if sendErr == nil {
+		_, err := db.conn.ExecContext(ctx, "DELETE FROM notification_outbox WHERE id = $1", id)
+		return false, err
+	}
+	res, err := db.conn.ExecContext(ctx, "DELETE FROM notification_outbox WHERE id = $1 AND attempts + 1 >= $2", id, maxAttempts)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	_, err = db.conn.ExecContext(ctx,
+		`UPDATE notification_outbox SET attempts = attempts + 1, last_error = $1,
+		   next_attempt_at = NOW() + LEAST(INTERVAL '30 seconds' * POWER(2, attempts), INTERVAL '1 hour')
+		 WHERE id = $2`, sendErr.Error(), id)
+	return false, err
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"ns116/internal/model"
+)
+
+// ConfigureAuditOutbox sets the sinks every new audit entry is queued for and
+// drops pending deliveries for sinks that are no longer configured.
+func (db *DB) ConfigureAuditOutbox(ctx context.Context, sinks []string) error {
+	db.SetAuditSinks(sinks)
+	if sinks == nil {
+		sinks = []string{}
+	}
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM audit_outbox WHERE NOT (sink = ANY($1))", sinks)
+	return err
+}
+
+// SetAuditSinks sets the sinks every new audit entry is queued for without
+// touching pending deliveries, for commands that audit changes but leave
+// shipping them to the server.
+func (db *DB) SetAuditSinks(sinks []string) {
+	db.auditSinks = sinks
+}
+
+// ProcessAuditOutbox hands up to limit pending entries for sink to send,
+// oldest first and exactly as stored. Delivered entries are removed; the
+// first failure is recorded with an exponential backoff and ends the batch.
+// Nothing later is sent before the failed entry is due again, so entries
+// reach the sink in order. It returns the number delivered.
+//
+// Only one instance processes a given sink at a time. It holds a session
+// lock rather than a transaction, so no transaction stays open while send
+// talks to the sink.
+func (db *DB) ProcessAuditOutbox(ctx context.Context, sink string, limit int, send func(model.AuditEntry) error) (int, error) {
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext('ns116.audit_outbox.' || $1))", sink).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtext('ns116.audit_outbox.' || $1))", sink)
+		if err != nil {
+			// Never hand a connection still holding the lock back to the pool
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	pending, err := dueOutboxEntries(ctx, conn, sink, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, e := range pending {
+		if sendErr := send(e); sendErr != nil {
+			_, err := conn.ExecContext(ctx,
+				`UPDATE audit_outbox SET attempts = attempts + 1, last_error = $1,
+				   next_attempt_at = NOW() + LEAST(INTERVAL '1 second' * POWER(2, attempts), INTERVAL '5 minutes')
+				 WHERE sink = $2 AND audit_id = $3`,
+				sendErr.Error(), sink, e.ID)
+			if err != nil {
+				return delivered, err
+			}
+			return delivered, sendErr
+		}
+		if _, err := conn.ExecContext(ctx, "DELETE FROM audit_outbox WHERE sink = $1 AND audit_id = $2", sink, e.ID); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// dueOutboxEntries returns the oldest limit entries queued for sink, or none
+// while the oldest one is backing off. Entries carry their stored values,
+// not the display adjustments of scanAuditEntry, plus the zone name.
+func dueOutboxEntries(ctx context.Context, conn *sql.Conn, sink string, limit int) ([]model.AuditEntry, error) {
+	var due bool
+	err := conn.QueryRowContext(ctx,
+		"SELECT next_attempt_at <= NOW() FROM audit_outbox WHERE sink = $1 ORDER BY audit_id LIMIT 1", sink).Scan(&due)
+	if err == sql.ErrNoRows || (err == nil && !due) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		auditChainSelect+` WHERE id IN (SELECT audit_id FROM audit_outbox WHERE sink = $1 ORDER BY audit_id LIMIT $2)
+		 ORDER BY id`, sink, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []model.AuditEntry
+	var zoneIDs []string
+	for rows.Next() {
+		c, err := scanChainRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, c.entry())
+		if c.ZoneID != "" {
+			zoneIDs = append(zoneIDs, c.ZoneID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(zoneIDs) == 0 {
+		return entries, nil
+	}
+
+	names := make(map[string]string)
+	zrows, err := conn.QueryContext(ctx, "SELECT zone_id, name FROM zones_cache WHERE zone_id = ANY($1)", zoneIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer zrows.Close()
+	for zrows.Next() {
+		var id, name string
+		if err := zrows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	if err := zrows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if name, ok := names[entries[i].ZoneID]; ok {
+			entries[i].ZoneName = name
+		} else {
+			entries[i].ZoneName = entries[i].ZoneID
+		}
+	}
+	return entries, nil
+}
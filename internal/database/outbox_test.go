@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+func TestProcessAuditOutboxOrder(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.conn.ExecContext(ctx,
+		"INSERT INTO zones_cache (zone_id, name) VALUES ('Z1', 'example.com.')"); err != nil {
+		t.Fatal(err)
+	}
+	db.SetAuditSinks([]string{"siem"})
+	for _, name := range []string{"example.com.", "www.example.com.", "mail.example.com."} {
+		err := db.LogAudit(ctx, model.AuditEntry{Username: "alice", Action: "create_record", ZoneID: "Z1", RecordName: name, RecordType: "A"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent []model.AuditEntry
+	sendFailing := func(failID int64) func(model.AuditEntry) error {
+		return func(e model.AuditEntry) error {
+			if e.ID == failID {
+				return errors.New("collector down")
+			}
+			sent = append(sent, e)
+			return nil
+		}
+	}
+
+	// The second entry fails and ends the batch
+	n, err := db.ProcessAuditOutbox(ctx, "siem", 10, sendFailing(2))
+	if n != 1 || err == nil {
+		t.Fatalf("first pass = %d, %v; want 1 delivered and the send error", n, err)
+	}
+	// While it backs off, the third entry is held back
+	n, err = db.ProcessAuditOutbox(ctx, "siem", 10, sendFailing(0))
+	if n != 0 || err != nil {
+		t.Fatalf("pass during backoff = %d, %v; want nothing delivered", n, err)
+	}
+	if _, err := db.conn.ExecContext(ctx, "UPDATE audit_outbox SET next_attempt_at = NOW()"); err != nil {
+		t.Fatal(err)
+	}
+	n, err = db.ProcessAuditOutbox(ctx, "siem", 10, sendFailing(0))
+	if n != 2 || err != nil {
+		t.Fatalf("retry = %d, %v; want 2 delivered", n, err)
+	}
+
+	var ids []int64
+	for _, e := range sent {
+		ids = append(ids, e.ID)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("delivered %v, want %v", ids, want)
+	}
+	// Entries are shipped as stored, not shortened for display
+	if sent[0].RecordName != "example.com." || sent[0].ZoneName != "example.com." || sent[0].Hash == "" {
+		t.Errorf("first entry = %+v", sent[0])
+	}
+	var left int
+	if err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_outbox").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d outbox rows left", left)
+	}
+}
@@ -1,39 +1,77 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"time"
+
+	"ns116/internal/model"
 )
 
-func (db *DB) CreateSession(token, csrfToken, username string, expiresAt time.Time) error {
-	_, err := db.conn.Exec(
-		"INSERT INTO sessions (token, csrf_token, username, expires_at) VALUES ($1, $2, $3, $4)",
-		token, csrfToken, username, expiresAt,
+func (db *DB) CreateSession(ctx context.Context, token, csrfToken, username, authMethod string, expiresAt time.Time) error {
+	_, err := db.conn.ExecContext(ctx,
+		"INSERT INTO sessions (token, csrf_token, username, auth_method, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		token, csrfToken, username, authMethod, expiresAt,
 	)
 	return err
 }
 
-func (db *DB) GetSession(token string) (string, string, time.Time, error) {
-	var username, csrfToken string
-	var expiresAt time.Time
-	err := db.conn.QueryRow(
-		"SELECT username, csrf_token, expires_at FROM sessions WHERE token = $1", token,
-	).Scan(&username, &csrfToken, &expiresAt)
+// GetSession returns the session for a token, or nil if it does not exist.
+func (db *DB) GetSession(ctx context.Context, token string) (*model.Session, error) {
+	s := &model.Session{Token: token}
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT username, csrf_token, auth_method, created_at, expires_at FROM sessions WHERE token = $1", token,
+	).Scan(&s.Username, &s.CSRFToken, &s.AuthMethod, &s.CreatedAt, &s.ExpiresAt)
 	if err == sql.ErrNoRows {
-		return "", "", time.Time{}, nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (db *DB) DeleteSession(ctx context.Context, token string) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM sessions WHERE token = $1", token)
+	return err
+}
+
+// PurgeExpiredSessions deletes expired sessions and returns how many there
+// were.
+func (db *DB) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	res, err := db.conn.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
 	}
+	return res.RowsAffected()
+}
+
+// DeleteAllSessions logs every user out and returns how many sessions there
+// were.
+func (db *DB) DeleteAllSessions(ctx context.Context) (int64, error) {
+	res, err := db.conn.ExecContext(ctx, "DELETE FROM sessions")
 	if err != nil {
-		return "", "", time.Time{}, err
+		return 0, err
 	}
-	return username, csrfToken, expiresAt, nil
+	return res.RowsAffected()
 }
 
-func (db *DB) DeleteSession(token string) error {
-	_, err := db.conn.Exec("DELETE FROM sessions WHERE token = $1", token)
+// DeleteUserSessions logs a user out everywhere, e.g. after their account is
+// disabled or their password changes.
+func (db *DB) DeleteUserSessions(ctx context.Context, username string) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM sessions WHERE username = $1", username)
 	return err
 }
 
-func (db *DB) PurgeExpiredSessions() error {
-	_, err := db.conn.Exec("DELETE FROM sessions WHERE expires_at < NOW()")
+// DeleteUserSessionsExcept removes every session of a user but the given one.
+func (db *DB) DeleteUserSessionsExcept(ctx context.Context, username, keepToken string) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM sessions WHERE username = $1 AND token <> $2", username, keepToken)
 	return err
 }
+
+// CountActiveSessions returns the number of unexpired sessions.
+func (db *DB) CountActiveSessions(ctx context.Context) (int, error) {
+	var n int
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions WHERE expires_at >= NOW()").Scan(&n)
+	return n, err
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"ns116/internal/model"
+)
+
+const tokenColumns = "t.id, t.user_id, u.username, t.name, t.prefix, t.created_at, t.last_used_at, t.expires_at"
+
+func scanAPIToken(row interface{ Scan(...any) error }, t *model.APIToken) error {
+	var lastUsed, expires sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &t.CreatedAt, &lastUsed, &expires); err != nil {
+		return err
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		t.ExpiresAt = &expires.Time
+	}
+	return nil
+}
+
+func (db *DB) ListAPITokens(ctx context.Context, userID int64) ([]model.APIToken, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+tokenColumns+" FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.user_id = $1 ORDER BY t.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []model.APIToken
+	for rows.Next() {
+		var t model.APIToken
+		if err := scanAPIToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// CreateAPIToken stores a token by the hash of its secret. expiresAt may be
+// nil for a token that does not expire.
+func (db *DB) CreateAPIToken(ctx context.Context, userID int64, name, hash, prefix string, expiresAt *time.Time) (int64, error) {
+	var id int64
+	err := db.conn.QueryRowContext(ctx,
+		`INSERT INTO api_tokens (user_id, name, token_hash, prefix, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		userID, name, hash, prefix, expiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// DeleteAPIToken revokes a token. userID scopes the delete to the owner's
+// tokens.
+func (db *DB) DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	return err
+}
+
+// UseAPIToken looks up a token by the hash of its secret and notes that it
+// was used. It returns nil if no token matches; expiry is left to the caller.
+// last_used_at is only written once a minute to spare busy clients a write
+// per request.
+func (db *DB) UseAPIToken(ctx context.Context, hash string) (*model.APIToken, error) {
+	t := &model.APIToken{}
+	err := scanAPIToken(db.conn.QueryRowContext(ctx,
+		"SELECT "+tokenColumns+" FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.token_hash = $1", hash), t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.conn.ExecContext(ctx,
+		`UPDATE api_tokens SET last_used_at = NOW()
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, t.ID)
+	return t, err
+}
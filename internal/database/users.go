@@ -1,29 +1,44 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"ns116/internal/model"
 )
 
-func (db *DB) GetUserByUsername(username string) (*model.User, error) {
+// ErrLastAdmin is returned when a change would leave the system without
+// any active admin account.
+var ErrLastAdmin = errors.New("cannot remove the last active admin")
+
+const userColumns = "id, username, pass_hash, role, active, auth_source, email, must_change_password, created_at, updated_at"
+
+// lastAdminGuard is appended to UPDATE/DELETE statements on users so that the
+// last active admin can never be demoted, disabled or deleted. It is only
+// race-safe inside execGuarded, which locks the admin rows first.
+const lastAdminGuard = `(role <> 'admin' OR active = 0 OR
+	(SELECT COUNT(*) FROM users WHERE role = 'admin' AND active = 1) > 1)`
+
+func scanUser(row interface{ Scan(...any) error }, u *model.User) error {
+	return row.Scan(&u.ID, &u.Username, &u.PassHash, &u.Role, &u.Active, &u.AuthSource, &u.Email,
+		&u.MustChangePassword, &u.CreatedAt, &u.UpdatedAt)
+}
+
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
 	u := &model.User{}
-	err := db.conn.QueryRow(
-		"SELECT id, username, pass_hash, role, active, auth_source, created_at, updated_at FROM users WHERE username = $1",
-		username,
-	).Scan(&u.ID, &u.Username, &u.PassHash, &u.Role, &u.Active, &u.AuthSource, &u.CreatedAt, &u.UpdatedAt)
+	err := scanUser(db.conn.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username), u)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return u, err
 }
 
-func (db *DB) ListUsers() ([]model.User, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, username, pass_hash, role, active, auth_source, created_at, updated_at FROM users ORDER BY id",
-	)
+func (db *DB) ListUsers(ctx context.Context) ([]model.User, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -32,7 +47,7 @@ func (db *DB) ListUsers() ([]model.User, error) {
 	var users []model.User
 	for rows.Next() {
 		var u model.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.PassHash, &u.Role, &u.Active, &u.AuthSource, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if err := scanUser(rows, &u); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -40,47 +55,129 @@ func (db *DB) ListUsers() ([]model.User, error) {
 	return users, rows.Err()
 }
 
-func (db *DB) CreateUser(username, password, role string) error {
+func (db *DB) CreateUser(ctx context.Context, username, password, role string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
 		return err
 	}
-	_, err = db.conn.Exec(
+	_, err = db.conn.ExecContext(ctx,
 		"INSERT INTO users (username, pass_hash, role) VALUES ($1, $2, $3)",
 		username, string(hash), role,
 	)
 	return err
 }
 
-func (db *DB) UpdateUserPassword(username, newPassword string) error {
+// CreateFirstAdmin creates an admin account only if the users table is still
+// empty. It reports false when another admin won the race.
+func (db *DB) CreateFirstAdmin(ctx context.Context, username, password string) (bool, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return false, err
+	}
+	res, err := db.conn.ExecContext(ctx,
+		`INSERT INTO users (username, pass_hash, role)
+		 SELECT $1, $2, 'admin' WHERE NOT EXISTS (SELECT 1 FROM users)`,
+		username, string(hash),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// UpdateUserPassword sets a new password chosen by the user themselves and
+// clears any pending forced reset.
+func (db *DB) UpdateUserPassword(ctx context.Context, username, newPassword string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
 	if err != nil {
 		return err
 	}
-	_, err = db.conn.Exec("UPDATE users SET pass_hash = $1, updated_at = NOW() WHERE username = $2",
+	_, err = db.conn.ExecContext(ctx,
+		"UPDATE users SET pass_hash = $1, must_change_password = 0, updated_at = NOW() WHERE username = $2",
+		string(hash), username)
+	return err
+}
+
+// ResetUserPassword sets a temporary password on behalf of an admin; the user
+// is required to choose a new one on their next login.
+func (db *DB) ResetUserPassword(ctx context.Context, username, tempPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(tempPassword), 12)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.ExecContext(ctx,
+		"UPDATE users SET pass_hash = $1, must_change_password = 1, updated_at = NOW() WHERE username = $2",
 		string(hash), username)
 	return err
 }
 
-func (db *DB) SetUserActive(username string, active bool) error {
+func (db *DB) SetUserActive(ctx context.Context, username string, active bool) error {
 	activeInt := 0
 	if active {
 		activeInt = 1
 	}
 	// Postgres boolean is preferred, but schema uses INTEGER for compatibility with original design
 	// Let's stick to INTEGER 0/1 as per schema.
-	_, err := db.conn.Exec("UPDATE users SET active = $1, updated_at = NOW() WHERE username = $2",
-		activeInt, username)
-	return err
+	if active {
+		_, err := db.conn.ExecContext(ctx, "UPDATE users SET active = $1, updated_at = NOW() WHERE username = $2",
+			activeInt, username)
+		return err
+	}
+	return db.execGuarded(ctx,
+		"UPDATE users SET active = $1, updated_at = NOW() WHERE username = $2 AND "+lastAdminGuard,
+		username, activeInt, username)
 }
 
-func (db *DB) DeleteUser(username string) error {
-	_, err := db.conn.Exec("DELETE FROM users WHERE username = $1", username)
-	return err
+func (db *DB) SetUserRole(ctx context.Context, username, role string) error {
+	if role == "admin" {
+		_, err := db.conn.ExecContext(ctx, "UPDATE users SET role = $1, updated_at = NOW() WHERE username = $2",
+			role, username)
+		return err
+	}
+	return db.execGuarded(ctx,
+		"UPDATE users SET role = $1, updated_at = NOW() WHERE username = $2 AND "+lastAdminGuard,
+		username, role, username)
 }
 
-func (db *DB) AuthenticateUser(username, password string) (*model.User, error) {
-	u, err := db.GetUserByUsername(username)
+func (db *DB) DeleteUser(ctx context.Context, username string) error {
+	return db.execGuarded(ctx, "DELETE FROM users WHERE username = $1 AND "+lastAdminGuard, username, username)
+}
+
+// execGuarded runs a statement protected by lastAdminGuard and translates
+// "no rows affected" into ErrLastAdmin when the target user does exist.
+// The active admin rows are locked first: under READ COMMITTED, two
+// statements demoting different admins would otherwise each still count the
+// other one and both succeed.
+func (db *DB) execGuarded(ctx context.Context, query, username string, args ...any) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM users WHERE role = 'admin' AND active = 1 ORDER BY id FOR UPDATE"); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return tx.Commit()
+	}
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("user %q not found", username)
+	}
+	return ErrLastAdmin
+}
+
+func (db *DB) AuthenticateUser(ctx context.Context, username, password string) (*model.User, error) {
+	u, err := db.GetUserByUsername(ctx, username)
 	if err != nil || u == nil || !u.Active {
 		return nil, err
 	}
@@ -90,13 +187,26 @@ func (db *DB) AuthenticateUser(username, password string) (*model.User, error) {
 	return u, nil
 }
 
-func (db *DB) CreateLDAPUser(username, role string) error {
-	_, err := db.conn.Exec(
-		`INSERT INTO users (username, pass_hash, role, auth_source)
-		 VALUES ($1, '', $2, 'ldap')
+// CreateExternalUser provisions or refreshes a user authenticated by source
+// ("ldap", "proxy" or "cert"), whose role and email come from that source.
+// A source that sends no email keeps the one stored. The role of an existing
+// user changes through SetUserRole, so a sign-in that would demote the last
+// active admin fails with ErrLastAdmin and leaves the role as it was.
+func (db *DB) CreateExternalUser(ctx context.Context, source, username, role, email string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO users (username, pass_hash, role, auth_source, email)
+		 VALUES ($1, '', $2, $3, $4)
 		 ON CONFLICT(username) DO UPDATE SET
-		   role = $3, auth_source = 'ldap', updated_at = NOW()`,
-		username, role, role,
+		   auth_source = $3, email = COALESCE(NULLIF(EXCLUDED.email, ''), users.email), updated_at = NOW()`,
+		username, role, source, email,
 	)
+	if err != nil {
+		return err
+	}
+	return db.SetUserRole(ctx, username, role)
+}
+
+func (db *DB) SetUserEmail(ctx context.Context, username, email string) error {
+	_, err := db.conn.ExecContext(ctx, "UPDATE users SET email = $1, updated_at = NOW() WHERE username = $2", email, username)
 	return err
 }
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestLastAdminGuard(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	for _, name := range []string{"alice", "bob"} {
+		if err := db.CreateUser(ctx, name, "correct horse battery", "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.SetUserRole(ctx, "alice", "editor"); err != nil {
+		t.Fatalf("demoting one of two admins: %v", err)
+	}
+	for name, change := range map[string]func() error{
+		"demote":  func() error { return db.SetUserRole(ctx, "bob", "editor") },
+		"disable": func() error { return db.SetUserActive(ctx, "bob", false) },
+		"delete":  func() error { return db.DeleteUser(ctx, "bob") },
+	} {
+		if err := change(); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("%s last admin: err = %v, want ErrLastAdmin", name, err)
+		}
+	}
+	if err := db.SetUserRole(ctx, "nobody", "editor"); err == nil || errors.Is(err, ErrLastAdmin) {
+		t.Errorf("unknown user: err = %v, want not found", err)
+	}
+}
+
+func TestLastAdminGuardConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	admins := []string{"alice", "bob", "carol", "dave"}
+	for _, name := range admins {
+		if err := db.CreateUser(ctx, name, "correct horse battery", "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each admin is demoted or disabled by a different request at once;
+	// exactly one must be left
+	var wg sync.WaitGroup
+	errs := make([]error, len(admins))
+	for i, name := range admins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				errs[i] = db.SetUserRole(ctx, name, "editor")
+			} else {
+				errs[i] = db.SetUserActive(ctx, name, false)
+			}
+		}()
+	}
+	wg.Wait()
+
+	refused := 0
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, ErrLastAdmin):
+			refused++
+		case err != nil:
+			t.Errorf("%s: %v", admins[i], err)
+		}
+	}
+	if refused != 1 {
+		t.Errorf("%d changes refused, want 1", refused)
+	}
+	var left int
+	if err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = 'admin' AND active = 1").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("%d active admins left, want 1", left)
+	}
+}
+
+func TestCreateExternalUserKeepsEmail(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if err := db.CreateExternalUser(ctx, "proxy", "alice", "editor", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// A later sign-in without the email header refreshes the role only
+	if err := db.CreateExternalUser(ctx, "proxy", "alice", "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+	u, err := db.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "alice@example.com" || u.Role != "admin" {
+		t.Errorf("user = %s / %s, want alice@example.com / admin", u.Email, u.Role)
+	}
+
+	if err := db.CreateExternalUser(ctx, "proxy", "alice", "admin", "alice@new.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := db.GetUserByUsername(ctx, "alice"); u.Email != "alice@new.example.com" {
+		t.Errorf("email = %q after a new one was sent", u.Email)
+	}
+}
+
+func TestCreateExternalUserLastAdmin(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if err := db.CreateExternalUser(ctx, "ldap", "alice", "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// An external sign-in with a lesser role cannot demote the last admin
+	if err := db.CreateExternalUser(ctx, "ldap", "alice", "editor", "alice@example.com"); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("demoting the last admin: err = %v, want ErrLastAdmin", err)
+	}
+	if u, _ := db.GetUserByUsername(ctx, "alice"); u.Role != "admin" {
+		t.Errorf("role = %s after a refused sign-in, want admin", u.Role)
+	}
+
+	if err := db.CreateUser(ctx, "bob", "correct horse battery", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateExternalUser(ctx, "ldap", "alice", "editor", ""); err != nil {
+		t.Fatalf("demoting one of two admins: %v", err)
+	}
+	if u, _ := db.GetUserByUsername(ctx, "alice"); u.Role != "editor" {
+		t.Errorf("role = %s, want editor", u.Role)
+	}
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"ns116/internal/model"
+)
+
+const webhookColumns = "id, name, url, secret, format, events, zones, active, created_by, created_at"
+
+const deliveryColumns = `id, subscription_id, audit_id, event, payload, status, attempts, next_attempt_at,
+	response_code, error, created_at, delivered_at`
+
+func scanWebhook(row interface{ Scan(...any) error }, s *model.WebhookSubscription) error {
+	var events, zones string
+	if err := row.Scan(&s.ID, &s.Name, &s.URL, &s.Secret, &s.Format, &events, &zones,
+		&s.Active, &s.CreatedBy, &s.CreatedAt); err != nil {
+		return err
+	}
+	s.Events, s.ZoneIDs = splitList(events), splitList(zones)
+	return nil
+}
+
+func scanDelivery(row interface{ Scan(...any) error }, d *model.WebhookDelivery) error {
+	var auditID, code sql.NullInt64
+	var payload string
+	var errMsg sql.NullString
+	var deliveredAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.SubscriptionID, &auditID, &d.Event, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &code, &errMsg, &d.CreatedAt, &deliveredAt); err != nil {
+		return err
+	}
+	d.AuditID, d.ResponseCode, d.Error = auditID.Int64, int(code.Int64), errMsg.String
+	d.Payload = json.RawMessage(payload)
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return nil
+}
+
+func (db *DB) ListWebhooks(ctx context.Context) ([]model.WebhookSubscription, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhook_subscriptions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []model.WebhookSubscription
+	for rows.Next() {
+		var s model.WebhookSubscription
+		if err := scanWebhook(rows, &s); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+func (db *DB) GetWebhook(ctx context.Context, id int) (*model.WebhookSubscription, error) {
+	s := &model.WebhookSubscription{}
+	err := scanWebhook(db.conn.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhook_subscriptions WHERE id = $1", id), s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+func (db *DB) CreateWebhook(ctx context.Context, s model.WebhookSubscription) (int, error) {
+	var id int
+	err := db.conn.QueryRowContext(ctx,
+		`INSERT INTO webhook_subscriptions (name, url, secret, format, events, zones, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		s.Name, s.URL, s.Secret, s.Format, strings.Join(s.Events, ","), strings.Join(s.ZoneIDs, ","), s.CreatedBy,
+	).Scan(&id)
+	return id, err
+}
+
+func (db *DB) SetWebhookActive(ctx context.Context, id int, active bool) error {
+	activeInt := 0
+	if active {
+		activeInt = 1
+	}
+	_, err := db.conn.ExecContext(ctx, "UPDATE webhook_subscriptions SET active = $1, updated_at = NOW() WHERE id = $2", activeInt, id)
+	return err
+}
+
+func (db *DB) DeleteWebhook(ctx context.Context, id int) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	return err
+}
+
+// enqueueWebhooks queues the event for every active subscription whose event
+// and zone filters match, inside the caller's audit transaction.
+func enqueueWebhooks(ctx context.Context, tx *sql.Tx, ev model.WebhookEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (subscription_id, audit_id, event, payload)
+		 SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		  WHERE active = 1
+		    AND (events = '' OR $2 = ANY(string_to_array(events, ',')))
+		    AND (zones = '' OR $4 = ANY(string_to_array(zones, ',')))`,
+		ev.AuditID, ev.Event, string(payload), ev.ZoneID)
+	return err
+}
+
+// EnqueueWebhookDelivery queues an event for one subscription regardless of
+// its filters, e.g. a test ping.
+func (db *DB) EnqueueWebhookDelivery(ctx context.Context, subscriptionID int, ev model.WebhookEvent) (int64, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = db.conn.QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries (subscription_id, event, payload) VALUES ($1, $2, $3) RETURNING id`,
+		subscriptionID, ev.Event, string(payload)).Scan(&id)
+	return id, err
+}
+
+// ReplayWebhookDelivery queues a fresh copy of a past delivery, leaving the
+// original in the log. It returns the new delivery's ID and subscription.
+func (db *DB) ReplayWebhookDelivery(ctx context.Context, id int64) (newID int64, subscriptionID int, err error) {
+	err = db.conn.QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries (subscription_id, audit_id, event, payload)
+		 SELECT subscription_id, audit_id, event, payload FROM webhook_deliveries WHERE id = $1
+		 RETURNING id, subscription_id`, id).Scan(&newID, &subscriptionID)
+	return newID, subscriptionID, err
+}
+
+func (db *DB) ListWebhookDeliveries(ctx context.Context, subscriptionID, limit int) ([]model.WebhookDelivery, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE subscription_id = $1 ORDER BY id DESC LIMIT $2",
+		subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.WebhookDelivery
+	for rows.Next() {
+		var d model.WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries of active
+// subscriptions for leaseSeconds so concurrent dispatchers skip them.
+func (db *DB) ClaimWebhookDeliveries(ctx context.Context, limit, leaseSeconds int) ([]model.WebhookDelivery, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		 WHERE id IN (
+		   SELECT d.id FROM webhook_deliveries d
+		   JOIN webhook_subscriptions s ON s.id = d.subscription_id AND s.active = 1
+		   WHERE d.status = 'pending' AND d.next_attempt_at <= NOW()
+		   ORDER BY d.id LIMIT $1
+		   FOR UPDATE OF d SKIP LOCKED)
+		 RETURNING `+deliveryColumns, limit, leaseSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.WebhookDelivery
+	for rows.Next() {
+		var d model.WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RecordWebhookAttempt stores the outcome of a send. Failures are retried
+// with exponential backoff until maxAttempts, after which the delivery is
+// marked failed.
+func (db *DB) RecordWebhookAttempt(ctx context.Context, id int64, code int, sendErr error, maxAttempts int) error {
+	if sendErr == nil {
+		_, err := db.conn.ExecContext(ctx,
+			`UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1,
+			   response_code = $1, error = NULL, delivered_at = NOW()
+			 WHERE id = $2`, nullInt(code), id)
+		return err
+	}
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET attempts = attempts + 1, response_code = $1, error = $2,
+		   status = CASE WHEN attempts + 1 >= $3 THEN 'failed' ELSE 'pending' END,
+		   next_attempt_at = NOW() + LEAST(INTERVAL '10 seconds' * POWER(2, attempts), INTERVAL '1 hour')
+		 WHERE id = $4`, nullInt(code), sendErr.Error(), maxAttempts, id)
+	return err
+}
+
+// PruneWebhookDeliveries removes finished deliveries older than days.
+func (db *DB) PruneWebhookDeliveries(ctx context.Context, days int) error {
+	_, err := db.conn.ExecContext(ctx,
+		"DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < NOW() - make_interval(days => $1)", days)
+	return err
+}
+
+func nullInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+func TestRecordWebhookAttempt(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	subID, err := db.CreateWebhook(ctx, model.WebhookSubscription{Name: "hook", URL: "https://hooks.example.com", Format: model.WebhookFormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.EnqueueWebhookDelivery(ctx, subID, model.WebhookEvent{Event: "login", Actor: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each failure doubles the wait from 10 seconds up to an hour; the last
+	// allowed attempt marks the delivery failed
+	const maxAttempts = 12
+	wantDelay := []float64{10, 20, 40, 80, 160, 320, 640, 1280, 2560, 3600, 3600, 3600}
+	for i, want := range wantDelay {
+		if err := db.RecordWebhookAttempt(ctx, id, 503, errors.New("endpoint returned 503"), maxAttempts); err != nil {
+			t.Fatal(err)
+		}
+		var status string
+		var attempts, code int
+		var delay float64
+		if err := db.conn.QueryRowContext(ctx,
+			`SELECT status, attempts, response_code, EXTRACT(EPOCH FROM next_attempt_at - NOW())
+			 FROM webhook_deliveries WHERE id = $1`, id).Scan(&status, &attempts, &code, &delay); err != nil {
+			t.Fatal(err)
+		}
+		wantStatus := model.WebhookPending
+		if i+1 == maxAttempts {
+			wantStatus = model.WebhookFailed
+		}
+		if status != wantStatus || attempts != i+1 || code != 503 {
+			t.Errorf("attempt %d: status %s, attempts %d, code %d", i+1, status, attempts, code)
+		}
+		if math.Abs(delay-want) > 2 {
+			t.Errorf("attempt %d: retried in %.0fs, want %.0fs", i+1, delay, want)
+		}
+	}
+
+	id, err = db.EnqueueWebhookDelivery(ctx, subID, model.WebhookEvent{Event: "logout", Actor: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordWebhookAttempt(ctx, id, 0, errors.New("connection refused"), maxAttempts); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordWebhookAttempt(ctx, id, 204, nil, maxAttempts); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := db.ListWebhookDeliveries(ctx, subID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := deliveries[0]; d.ID != id || d.Status != model.WebhookDelivered || d.Attempts != 2 ||
+		d.ResponseCode != 204 || d.Error != "" || d.DeliveredAt == nil {
+		t.Errorf("delivered = %+v", d)
+	}
+}
+
+func TestClaimWebhookDeliveries(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	subID, err := db.CreateWebhook(ctx, model.WebhookSubscription{Name: "hook", URL: "https://hooks.example.com", Format: model.WebhookFormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []string{"login", "logout"} {
+		if _, err := db.EnqueueWebhookDelivery(ctx, subID, model.WebhookEvent{Event: ev, Actor: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := db.ClaimWebhookDeliveries(ctx, 10, 60)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("claimed %d, %v; want 2", len(claimed), err)
+	}
+	// Leased deliveries are not handed to another dispatcher
+	if again, err := db.ClaimWebhookDeliveries(ctx, 10, 60); err != nil || len(again) != 0 {
+		t.Errorf("second claim = %d, %v; want none", len(again), err)
+	}
+	// Nor are those of paused subscriptions
+	if _, err := db.conn.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = NOW()"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetWebhookActive(ctx, subID, false); err != nil {
+		t.Fatal(err)
+	}
+	if paused, err := db.ClaimWebhookDeliveries(ctx, 10, 60); err != nil || len(paused) != 0 {
+		t.Errorf("claim while paused = %d, %v; want none", len(paused), err)
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"ns116/internal/model"
+)
+
+const managedZoneColumns = "zone_id, name, enabled, label, description, owner, tags, updated_by, updated_at"
+
+func scanManagedZone(row interface{ Scan(...any) error }, z *model.ManagedZone) error {
+	var tags string
+	if err := row.Scan(&z.ZoneID, &z.Name, &z.Enabled, &z.Label, &z.Description, &z.Owner, &tags,
+		&z.UpdatedBy, &z.UpdatedAt); err != nil {
+		return err
+	}
+	z.Tags = splitList(tags)
+	return nil
+}
+
+func (db *DB) ListManagedZones(ctx context.Context) ([]model.ManagedZone, error) {
+	rows, err := db.conn.QueryContext(ctx, "SELECT "+managedZoneColumns+" FROM managed_zones ORDER BY zone_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var zones []model.ManagedZone
+	for rows.Next() {
+		var z model.ManagedZone
+		if err := scanManagedZone(rows, &z); err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+func (db *DB) GetManagedZone(ctx context.Context, zoneID string) (*model.ManagedZone, error) {
+	z := &model.ManagedZone{}
+	err := scanManagedZone(db.conn.QueryRowContext(ctx, "SELECT "+managedZoneColumns+" FROM managed_zones WHERE zone_id = $1", zoneID), z)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return z, err
+}
+
+// ZonesRestricted reports whether a zone has ever been enabled from the
+// admin UI. From then on only enabled zones are visible, even once all are
+// disabled again: switching zones off must never show every zone.
+func (db *DB) ZonesRestricted(ctx context.Context) (bool, error) {
+	value, err := db.GetSetting(ctx, "zones_restricted")
+	return value != "", err
+}
+
+// SaveManagedZone creates or replaces the settings of a zone.
+func (db *DB) SaveManagedZone(ctx context.Context, z model.ManagedZone) error {
+	enabled := 0
+	if z.Enabled {
+		enabled = 1
+	}
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Zones enabled before the flag existed count as well
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO settings (key, value)
+		 SELECT 'zones_restricted', '1' WHERE $1 = 1 OR EXISTS (SELECT 1 FROM managed_zones WHERE enabled = 1)
+		 ON CONFLICT(key) DO NOTHING`, enabled); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO managed_zones (zone_id, name, enabled, label, description, owner, tags, updated_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 ON CONFLICT(zone_id) DO UPDATE SET
+		   name = $2, enabled = $3, label = $4, description = $5, owner = $6, tags = $7,
+		   updated_by = $8, updated_at = NOW()`,
+		z.ZoneID, z.Name, enabled, z.Label, z.Description, z.Owner, strings.Join(z.Tags, ","), z.UpdatedBy,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
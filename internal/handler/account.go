@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+)
+
+type AccountHandler struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	tmpl       *template.Template
+	policy     config.PasswordPolicyConfig
+}
+
+func NewAccountHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template, policy config.PasswordPolicyConfig) *AccountHandler {
+	return &AccountHandler{db: db, sessionMgr: sm, tmpl: tmpl, policy: policy}
+}
+
+func (h *AccountHandler) PasswordPage(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, "")
+}
+
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	username, _ := h.sessionMgr.GetUsername(r)
+	current := r.FormValue("current_password")
+	password := r.FormValue("password")
+	confirm := r.FormValue("confirm_password")
+
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+	if user == nil || user.AuthSource != "local" {
+		h.render(w, r, "Your password is managed by the directory service")
+		return
+	}
+
+	verified, err := h.db.AuthenticateUser(r.Context(), username, current)
+	if err != nil || verified == nil {
+		h.render(w, r, "Current password is incorrect")
+		return
+	}
+	if password != confirm {
+		h.render(w, r, "Passwords do not match")
+		return
+	}
+	if password == current {
+		h.render(w, r, "New password must differ from the current one")
+		return
+	}
+	if err := auth.ValidatePassword(h.policy, password); err != nil {
+		h.render(w, r, err.Error())
+		return
+	}
+
+	if err := h.db.UpdateUserPassword(r.Context(), username, password); err != nil {
+		h.render(w, r, "Failed to change password: "+err.Error())
+		return
+	}
+	if err := h.sessionMgr.InvalidateOtherSessions(r, username); err != nil {
+		slog.ErrorContext(r.Context(), "revoking other sessions failed", "user", username, "err", err)
+	}
+
+	logAudit(r, h.db, auditEntry(r, h.sessionMgr, "change_password"))
+
+	http.Redirect(w, r, auth.PasswordChangePath+"?msg="+url.QueryEscape("Password changed successfully"), http.StatusSeeOther)
+}
+
+func (h *AccountHandler) render(w http.ResponseWriter, r *http.Request, errMsg string) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	h.tmpl.ExecuteTemplate(w, "layout", map[string]interface{}{
+		"Title":     "Account",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"User":      user,
+		"Policy":    h.policy,
+		"Error":     errMsg,
+		"Flash":     r.URL.Query().Get("msg"),
+	})
+}
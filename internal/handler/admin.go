@@ -1,12 +1,19 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"ns116/internal/audit"
 	"ns116/internal/auth"
+	"ns116/internal/config"
 	"ns116/internal/database"
 	"ns116/internal/model"
 )
@@ -15,17 +22,19 @@ type AdminHandler struct {
 	db         *database.DB
 	sessionMgr *auth.SessionManager
 	tmpl       *template.Template
+	policy     config.PasswordPolicyConfig
+	auditKey   []byte
 }
 
-func NewAdminHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template) *AdminHandler {
-	return &AdminHandler{db: db, sessionMgr: sm, tmpl: tmpl}
+func NewAdminHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template, policy config.PasswordPolicyConfig, auditKey []byte) *AdminHandler {
+	return &AdminHandler{db: db, sessionMgr: sm, tmpl: tmpl, policy: policy, auditKey: auditKey}
 }
 
 func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
-	user, _ := h.db.GetUserByUsername(username)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
 
-	users, err := h.db.ListUsers()
+	users, err := h.db.ListUsers(r.Context())
 	if err != nil {
 		h.tmpl.ExecuteTemplate(w, "layout", map[string]interface{}{
 			"Title":     "Users",
@@ -49,7 +58,6 @@ func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 
 func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	_ = r.ParseForm()
-	username, _ := h.sessionMgr.GetUsername(r)
 	newUsername := r.FormValue("username")
 	password := r.FormValue("password")
 	role := r.FormValue("role")
@@ -58,19 +66,23 @@ func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		role = "editor"
 	}
 
+	if err := auth.ValidatePassword(h.policy, password); err != nil {
+		redirectUsers(w, r, "Error: "+err.Error())
+		return
+	}
+
 	msg := fmt.Sprintf("User '%s' created successfully", newUsername)
-	if err := h.db.CreateUser(newUsername, password, role); err != nil {
+	err := h.db.CreateUser(r.Context(), newUsername, password, role)
+	if err != nil {
 		msg = "Error: " + err.Error()
-	} else {
-		_ = h.db.LogAudit(model.AuditEntry{
-			Username:  username,
-			Action:    "create_user",
-			Detail:    fmt.Sprintf("created user=%s role=%s", newUsername, role),
-			IPAddress: r.RemoteAddr,
-		})
 	}
 
-	http.Redirect(w, r, "/admin/users?msg="+msg, http.StatusSeeOther)
+	entry := auditEntry(r, h.sessionMgr, "create_user")
+	entry.Detail = fmt.Sprintf("created user=%s role=%s", newUsername, role)
+	entry.After = model.AuditState(userState{Username: newUsername, Role: role, Active: true})
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	redirectUsers(w, r, msg)
 }
 
 func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
@@ -79,58 +91,339 @@ func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	targetUser := r.FormValue("username")
 
 	if targetUser == username {
-		http.Redirect(w, r, "/admin/users?msg=Cannot+delete+yourself", http.StatusSeeOther)
+		redirectUsers(w, r, "Cannot delete yourself")
 		return
 	}
 
+	target, _ := h.db.GetUserByUsername(r.Context(), targetUser)
+
 	msg := fmt.Sprintf("User '%s' deleted", targetUser)
-	if err := h.db.DeleteUser(targetUser); err != nil {
+	err := h.db.DeleteUser(r.Context(), targetUser)
+	if err != nil {
+		msg = "Error: " + userErrorMessage(err)
+	}
+
+	entry := auditEntry(r, h.sessionMgr, "delete_user")
+	entry.Detail = fmt.Sprintf("deleted user=%s", targetUser)
+	entry.Before = model.AuditState(newUserState(target))
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	redirectUsers(w, r, msg)
+}
+
+func (h *AdminHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	targetUser := r.FormValue("username")
+	role := r.FormValue("role")
+
+	if role != "admin" && role != "editor" {
+		redirectUsers(w, r, "Error: invalid role")
+		return
+	}
+
+	target, err := h.db.GetUserByUsername(r.Context(), targetUser)
+	if err != nil || target == nil {
+		redirectUsers(w, r, "Error: user not found")
+		return
+	}
+	if target.AuthSource != "local" {
+		// LDAP and proxy roles are derived from group_mapping on every login
+		redirectUsers(w, r, "Error: role of LDAP and proxy users is managed by group mapping")
+		return
+	}
+	if target.Role == role {
+		redirectUsers(w, r, fmt.Sprintf("User '%s' already has role %s", targetUser, role))
+		return
+	}
+
+	msg := fmt.Sprintf("Role of '%s' changed to %s", targetUser, role)
+	err = h.db.SetUserRole(r.Context(), targetUser, role)
+	if err != nil {
+		msg = "Error: " + userErrorMessage(err)
+	}
+
+	entry := auditEntry(r, h.sessionMgr, "update_user_role")
+	entry.Detail = fmt.Sprintf("user=%s role: %s -> %s", targetUser, target.Role, role)
+	entry.Before = model.AuditState(newUserState(target))
+	after := newUserState(target)
+	after.Role = role
+	entry.After = model.AuditState(after)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	redirectUsers(w, r, msg)
+}
+
+func (h *AdminHandler) SetActive(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	username, _ := h.sessionMgr.GetUsername(r)
+	targetUser := r.FormValue("username")
+	active := r.FormValue("active") == "1"
+
+	if targetUser == username && !active {
+		redirectUsers(w, r, "Cannot disable yourself")
+		return
+	}
+
+	msg := fmt.Sprintf("User '%s' enabled", targetUser)
+	action := "enable_user"
+	if !active {
+		msg = fmt.Sprintf("User '%s' disabled", targetUser)
+		action = "disable_user"
+	}
+
+	target, _ := h.db.GetUserByUsername(r.Context(), targetUser)
+
+	err := h.db.SetUserActive(r.Context(), targetUser, active)
+	if err != nil {
+		msg = "Error: " + userErrorMessage(err)
+	} else if !active {
+		if err := h.db.DeleteUserSessions(r.Context(), targetUser); err != nil {
+			slog.ErrorContext(r.Context(), "revoking sessions failed", "user", targetUser, "err", err)
+		}
+	}
+
+	entry := auditEntry(r, h.sessionMgr, action)
+	entry.Detail = fmt.Sprintf("user=%s", targetUser)
+	if target != nil {
+		entry.Before = model.AuditState(newUserState(target))
+		after := newUserState(target)
+		after.Active = active
+		entry.After = model.AuditState(after)
+	}
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	redirectUsers(w, r, msg)
+}
+
+// ResetPassword sets a temporary password chosen by the admin and forces the
+// user to pick a new one on their next login. Existing sessions are revoked.
+func (h *AdminHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	targetUser := r.FormValue("username")
+	password := r.FormValue("password")
+
+	target, err := h.db.GetUserByUsername(r.Context(), targetUser)
+	if err != nil || target == nil {
+		redirectUsers(w, r, "Error: user not found")
+		return
+	}
+	if target.AuthSource != "local" {
+		redirectUsers(w, r, "Error: passwords of LDAP and proxy users are managed externally")
+		return
+	}
+	if err := auth.ValidatePassword(h.policy, password); err != nil {
+		redirectUsers(w, r, "Error: "+err.Error())
+		return
+	}
+
+	msg := fmt.Sprintf("Password of '%s' reset; they must change it at next login", targetUser)
+	err = h.db.ResetUserPassword(r.Context(), targetUser, password)
+	if err != nil {
 		msg = "Error: " + err.Error()
 	} else {
-		_ = h.db.LogAudit(model.AuditEntry{
-			Username:  username,
-			Action:    "delete_user",
-			Detail:    fmt.Sprintf("deleted user=%s", targetUser),
-			IPAddress: r.RemoteAddr,
-		})
+		if err := h.db.DeleteUserSessions(r.Context(), targetUser); err != nil {
+			slog.ErrorContext(r.Context(), "revoking sessions failed", "user", targetUser, "err", err)
+		}
 	}
 
-	http.Redirect(w, r, "/admin/users?msg="+msg, http.StatusSeeOther)
+	entry := auditEntry(r, h.sessionMgr, "reset_password")
+	entry.Detail = fmt.Sprintf("user=%s", targetUser)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	redirectUsers(w, r, msg)
+}
+
+// userState is the audited view of a user account; it deliberately omits the
+// password hash.
+type userState struct {
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	Active     bool   `json:"active"`
+	AuthSource string `json:"auth_source,omitempty"`
+}
+
+func newUserState(u *model.User) *userState {
+	if u == nil {
+		return nil
+	}
+	return &userState{Username: u.Username, Role: u.Role, Active: u.Active, AuthSource: u.AuthSource}
+}
+
+func redirectUsers(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/admin/users?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+func userErrorMessage(err error) string {
+	if errors.Is(err, database.ErrLastAdmin) {
+		return "at least one active admin must remain"
+	}
+	return err.Error()
 }
 
 func (h *AdminHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
 	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
-	user, _ := h.db.GetUserByUsername(username)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
 
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
-	}
+	q := r.URL.Query()
+	var cursor model.AuditCursor
+	cursor.Before, _ = strconv.ParseInt(q.Get("before"), 10, 64)
+	cursor.After, _ = strconv.ParseInt(q.Get("after"), 10, 64)
 	limit := 50
-	offset := (page - 1) * limit
 
-	entries, total, err := h.db.ListAuditLog(limit, offset)
+	actions, err := h.db.ListAuditActions(r.Context())
+	if err != nil {
+		slog.WarnContext(r.Context(), "listing audit actions failed", "err", err)
+	}
+	filterQuery := auditFilterQuery(q)
+
+	filter, err := audit.ParseFilter(q)
+	var entries []model.AuditEntry
+	var more bool
+	if err == nil {
+		entries, more, err = h.db.ListAuditLog(r.Context(), filter, cursor, limit)
+		if err != nil {
+			err = fmt.Errorf("Failed to load audit log: %w", err)
+		}
+	}
 	if err != nil {
 		h.tmpl.ExecuteTemplate(w, "layout", map[string]interface{}{
 			"Title":     "Audit Log",
 			"Username":  username,
 			"CSRFToken": csrfToken,
 			"Role":      roleOf(user),
-			"Error":     "Failed to load audit log: " + err.Error(),
+			"Filter":    q,
+			"Actions":   actions,
+			"Error":     err.Error(),
 		})
 		return
 	}
 
-	totalPages := (total + limit - 1) / limit
+	total, capped, err := h.db.CountAuditLog(r.Context(), filter)
+	if err != nil {
+		slog.WarnContext(r.Context(), "counting audit entries failed", "err", err)
+	}
+
+	// Links page relative to the entries on screen; the direction we came
+	// from always has more entries
+	var newerURL, olderURL template.URL
+	if len(entries) > 0 {
+		if cursor.Before > 0 || (cursor.After > 0 && more) {
+			newerURL = auditPageURL(filterQuery, "after", entries[0].ID)
+		}
+		if cursor.After > 0 || more {
+			olderURL = auditPageURL(filterQuery, "before", entries[len(entries)-1].ID)
+		}
+	}
 
 	h.tmpl.ExecuteTemplate(w, "layout", map[string]interface{}{
-		"Title":      "Audit Log",
-		"Username":   username,
-		"CSRFToken":  csrfToken,
-		"Role":       roleOf(user),
-		"Entries":    entries,
-		"Page":       page,
-		"TotalPages": totalPages,
-		"Total":      total,
+		"Title":       "Audit Log",
+		"Username":    username,
+		"CSRFToken":   csrfToken,
+		"Role":        roleOf(user),
+		"Entries":     entries,
+		"Total":       total,
+		"TotalCapped": capped,
+		"Filter":      q,
+		"Filtered":    filterQuery != "",
+		"Flash":       q.Get("msg"),
+		"Error":       q.Get("error"),
+		"Actions":     actions,
+		"NewerURL":    newerURL,
+		"OlderURL":    olderURL,
+		"CSVURL":      template.URL("/admin/audit/export?format=csv&" + filterQuery),
+		"JSONLURL":    template.URL("/admin/audit/export?format=jsonl&" + filterQuery),
 	})
 }
+
+// VerifyAuditLog walks the audit hash chain and reports the first broken
+// link, if any. The check itself is audited.
+func (h *AdminHandler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	res, err := h.db.VerifyAuditLog(r.Context(), h.auditKey)
+	if err == nil && !res.OK() {
+		err = errors.New(res.String())
+	}
+
+	entry := auditEntry(r, h.sessionMgr, "verify_audit")
+	entry.Detail = fmt.Sprintf("checked=%d last_id=%d", res.Checked, res.LastID)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		http.Redirect(w, r, "/admin/audit?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin/audit?msg="+url.QueryEscape(res.String()), http.StatusSeeOther)
+}
+
+// ExportAuditLog streams the filtered audit log as CSV or JSON Lines straight
+// from PostgreSQL, so large exports never sit in memory.
+func (h *AdminHandler) ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	username, _ := h.sessionMgr.GetUsername(r)
+	q := r.URL.Query()
+
+	filter, err := audit.ParseFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	contentType := map[string]string{"csv": "text/csv; charset=utf-8", "jsonl": "application/x-ndjson"}[format]
+	exp, err := audit.NewExporter(w, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := auditEntry(r, h.sessionMgr, "export_audit")
+	entry.Detail = fmt.Sprintf("format=%s %s", format, auditFilterQuery(q))
+	logAudit(r, h.db, entry)
+
+	filename := fmt.Sprintf("ns116-audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	flusher, _ := w.(http.Flusher)
+	// Large exports may stream for longer than server.write_timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	n := 0
+	err = h.db.StreamAuditLog(r.Context(), filter, func(e model.AuditEntry) error {
+		if err := exp.Write(e); err != nil {
+			return err
+		}
+		n++
+		if n%500 == 0 {
+			_ = exp.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if flushErr := exp.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		// Headers are already sent; the truncated download is all we can signal
+		slog.WarnContext(r.Context(), "audit export aborted", "user", username, "rows", n, "err", err)
+	}
+}
+
+// auditFilterQuery re-encodes only the non-empty filter parameters so that
+// pagination and export links carry the current filter.
+func auditFilterQuery(q url.Values) string {
+	out := url.Values{}
+	for _, k := range audit.FilterParams {
+		if v := strings.TrimSpace(q.Get(k)); v != "" {
+			out.Set(k, v)
+		}
+	}
+	return out.Encode()
+}
+
+func auditPageURL(filterQuery, dir string, id int64) template.URL {
+	u := "/admin/audit?" + dir + "=" + strconv.FormatInt(id, 10)
+	if filterQuery != "" {
+		u += "&" + filterQuery
+	}
+	return template.URL(u)
+}
@@ -0,0 +1,673 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/smithy-go"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+	"ns116/internal/service"
+	"ns116/internal/zonesync"
+)
+
+const (
+	// apiBodyLimit bounds the body of single-record requests
+	apiBodyLimit = 1 << 20
+	// importBodyLimit bounds an import, which carries a whole zone
+	importBodyLimit = 16 << 20
+)
+
+// APIHandler serves the JSON API under /api/v1 that ns116 ctl talks to.
+// Requests authenticate with a personal access token, or a client
+// certificate mapped by server.tls.client_certs, and act with the
+// permissions of its user. Changes are audited like their web UI
+// counterparts, with auth method "token" or "cert".
+type APIHandler struct {
+	r53        *service.DNSService
+	sessionMgr *auth.SessionManager
+	db         *database.DB
+	sync       config.SyncConfig
+	certMapper *auth.CertMapper // nil without client certificates
+}
+
+func NewAPIHandler(r53 *service.DNSService, sm *auth.SessionManager, db *database.DB, sync config.SyncConfig, certMapper *auth.CertMapper) *APIHandler {
+	return &APIHandler{r53: r53, sessionMgr: sm, db: db, sync: sync, certMapper: certMapper}
+}
+
+// RequireToken authenticates the bearer token of the request, or without
+// one its client certificate, and attaches a session of its user, so
+// auditing and user lookups work as for a signed-in browser. Disabled users,
+// and users who must change their password first, are refused.
+func (h *APIHandler) RequireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := auth.BearerToken(r)
+		if secret == "" && h.certMapper != nil {
+			if id, ok := h.certMapper.Identify(r); ok {
+				h.certRequest(w, r, id, next)
+				return
+			}
+		}
+		if secret == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ns116"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing API token")
+			return
+		}
+		token, err := h.db.UseAPIToken(r.Context(), auth.HashAPIToken(secret))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading API token failed", "err", err)
+			writeAPIError(w, http.StatusServiceUnavailable, "could not check the API token")
+			return
+		}
+		if token == nil || token.Expired() {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ns116", error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, "invalid or expired API token")
+			return
+		}
+		user, err := h.db.GetUserByUsername(r.Context(), token.Username)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading token user failed", "user", token.Username, "err", err)
+			writeAPIError(w, http.StatusServiceUnavailable, "could not check the API token")
+			return
+		}
+		if user == nil || !user.Active {
+			writeAPIError(w, http.StatusForbidden, "account is disabled")
+			return
+		}
+		if user.MustChangePassword {
+			writeAPIError(w, http.StatusForbidden, "password change required; sign in to the web UI first")
+			return
+		}
+		ctx := auth.WithSession(r.Context(), &model.Session{
+			Username:   user.Username,
+			AuthMethod: "token",
+			CreatedAt:  token.CreatedAt,
+		})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// certRequest serves a request authenticated by the client certificate
+// identity id, which signs in to or provisions an account like CertAuth.
+// Browsers present the certificate on their own, so requests another site
+// made them send are refused.
+func (h *APIHandler) certRequest(w http.ResponseWriter, r *http.Request, id auth.CertIdentity, next http.HandlerFunc) {
+	if crossSite(r) {
+		writeAPIError(w, http.StatusForbidden, "cross-site requests are not allowed")
+		return
+	}
+	user, err := certAccount(r.Context(), h.db, id)
+	if errors.Is(err, errNoCertAccount) || errors.Is(err, errCertAccountDisabled) || errors.Is(err, errCertLastAdmin) {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading certificate user failed", "user", id.Username, "err", err)
+		writeAPIError(w, http.StatusServiceUnavailable, "could not check the client certificate")
+		return
+	}
+	if user.MustChangePassword {
+		writeAPIError(w, http.StatusForbidden, "password change required; sign in to the web UI first")
+		return
+	}
+	ctx := auth.WithSession(r.Context(), &model.Session{
+		Username:   user.Username,
+		AuthMethod: "cert",
+		CreatedAt:  time.Now(),
+	})
+	next(w, r.WithContext(ctx))
+}
+
+// WhoAmI reports the user the request acts as, so clients can check their
+// credentials.
+func (h *APIHandler) WhoAmI(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, model.APIIdentity{Username: user.Username, Role: user.Role})
+}
+
+func (h *APIHandler) ListZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := h.r53.ListZones(r.Context())
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load zones: "+err.Error())
+		return
+	}
+	if zones == nil {
+		zones = []model.HostedZone{}
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+func (h *APIHandler) GetZone(w http.ResponseWriter, r *http.Request) {
+	zone, err := h.r53.GetZone(r.Context(), r.PathValue("zoneID"))
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load zone: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, zone)
+}
+
+// ListRecords returns the record sets of a zone, optionally only those with
+// the name and type given in the query. The whole list doubles as the zone
+// export.
+func (h *APIHandler) ListRecords(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	records, err := h.r53.ListRecords(r.Context(), zone.ID)
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load records: "+err.Error())
+		return
+	}
+	name, recordType := r.URL.Query().Get("name"), strings.ToUpper(r.URL.Query().Get("type"))
+	if name != "" {
+		name = qualifyName(name, zone.Name)
+	}
+	filtered := []model.DNSRecord{}
+	for _, rec := range records {
+		if (name == "" || strings.EqualFold(rec.Name, name)) && (recordType == "" || rec.Type == recordType) {
+			filtered = append(filtered, rec)
+		}
+	}
+	writeJSON(w, http.StatusOK, filtered)
+}
+
+// GetRecord reads one record set straight from Route53. Its version, for
+// conditional updates and deletes, is returned in the ETag header.
+func (h *APIHandler) GetRecord(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	name, recordType := qualifyName(r.PathValue("name"), zone.Name), strings.ToUpper(r.PathValue("type"))
+	rec, err := h.r53.GetRecord(r.Context(), zone.ID, name, recordType, r.URL.Query().Get("set_identifier"))
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load record: "+err.Error())
+		return
+	}
+	if rec == nil {
+		writeAPIError(w, http.StatusNotFound, "record not found")
+		return
+	}
+	w.Header().Set("ETag", `"`+rec.Version()+`"`)
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// CreateRecord creates the record set in the body. Unlike the web form it
+// accepts every field of a record, including alias targets and routing
+// policies.
+func (h *APIHandler) CreateRecord(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	var rec model.DNSRecord
+	if err := readJSON(w, r, &rec, apiBodyLimit); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := normalizeRecord(&rec, zone.Name); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	changeID, err := h.r53.ApplyChanges(r.Context(), zone.ID, service.ChangeComment("Create", rec),
+		[]model.RecordChange{{Action: "CREATE", Record: rec}})
+
+	entry := createEntry(auditEntry(r, h.sessionMgr, "create_record"), zone.ID, rec)
+	entry.ChangeID = changeID
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to create record: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, submitted(changeID))
+}
+
+// UpdateRecord replaces the record set named in the path with the one in
+// the body. An If-Match header, or the version query parameter, makes the
+// update fail if the record changed since the client read it.
+func (h *APIHandler) UpdateRecord(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	var updated model.DNSRecord
+	if err := readJSON(w, r, &updated, apiBodyLimit); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entry := auditEntry(r, h.sessionMgr, "edit_record")
+	entry.ZoneID = zone.ID
+	entry.RecordName = qualifyName(r.PathValue("name"), zone.Name)
+	entry.RecordType = strings.ToUpper(r.PathValue("type"))
+
+	if updated.Name == "" {
+		updated.Name = entry.RecordName
+	}
+	if updated.Type == "" {
+		updated.Type = entry.RecordType
+	}
+	if err := normalizeRecord(&updated, zone.Name); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	current, err := h.resolveRecord(r, zone.ID, entry.RecordName, entry.RecordType)
+	if err != nil {
+		if current != nil {
+			entry.Before = model.AuditState(current)
+		}
+		logAudit(r, h.db, withOutcome(entry, err))
+		writeAPIError(w, apiStatus(err), "failed to update record: "+apiMessage(err))
+		return
+	}
+
+	entry.RecordName = updated.Name
+	entry.RecordType = updated.Type
+	entry.Before = model.AuditState(current)
+	entry.After = model.AuditState(updated)
+	entry.Detail = changeSummary(entry.Changes())
+
+	changes := []model.RecordChange{{Action: "UPSERT", Record: updated}}
+	if recordKey(*current) != recordKey(updated) {
+		changes = []model.RecordChange{
+			{Action: "DELETE", Record: *current},
+			{Action: "CREATE", Record: updated},
+		}
+	}
+	changeID, err := h.r53.ApplyChanges(r.Context(), zone.ID, service.ChangeComment("Update", updated), changes)
+
+	entry.ChangeID = changeID
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to update record: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, submitted(changeID))
+}
+
+// DeleteRecord deletes the record set named in the path, optionally only
+// if it is still at the version given like for UpdateRecord.
+func (h *APIHandler) DeleteRecord(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	entry := auditEntry(r, h.sessionMgr, "delete_record")
+	entry.ZoneID = zone.ID
+	entry.RecordName = qualifyName(r.PathValue("name"), zone.Name)
+	entry.RecordType = strings.ToUpper(r.PathValue("type"))
+
+	current, err := h.resolveRecord(r, zone.ID, entry.RecordName, entry.RecordType)
+	if current != nil {
+		entry.Before = model.AuditState(current)
+		entry.Detail = recordDetail(*current)
+	}
+	if err != nil {
+		logAudit(r, h.db, withOutcome(entry, err))
+		writeAPIError(w, apiStatus(err), "failed to delete record: "+apiMessage(err))
+		return
+	}
+
+	changeID, err := h.r53.ApplyChanges(r.Context(), zone.ID, service.ChangeComment("Delete", *current),
+		[]model.RecordChange{{Action: "DELETE", Record: *current}})
+
+	entry.ChangeID = changeID
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to delete record: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, submitted(changeID))
+}
+
+// Import creates or updates the record sets in the body in one atomic
+// change batch. Records that already match are left alone and none are
+// deleted. The apex SOA and NS records belong to the hosted zone and are
+// skipped. Each change gets its own audit entry, as if made one by one.
+func (h *APIHandler) Import(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	var records []model.DNSRecord
+	if err := readJSON(w, r, &records, importBodyLimit); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Compare against Route53 rather than a cached copy
+	if err := h.db.InvalidateRecordCache(r.Context(), zone.ID); err != nil {
+		slog.ErrorContext(r.Context(), "invalidating record cache failed", "zone_id", zone.ID, "err", err)
+	}
+	existing, err := h.r53.ListRecords(r.Context(), zone.ID)
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load records: "+err.Error())
+		return
+	}
+	current := make(map[string]model.DNSRecord, len(existing))
+	for _, rec := range existing {
+		current[recordKey(rec)] = rec
+	}
+
+	var result model.ImportResult
+	var changes []model.RecordChange
+	var entries []model.AuditEntry
+	seen := make(map[string]bool, len(records))
+	for i := range records {
+		rec := records[i]
+		if err := normalizeRecord(&rec, zone.Name); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("record %d: %v", i+1, err))
+			return
+		}
+		key := recordKey(rec)
+		if seen[key] {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("record %d: %s %s is listed twice", i+1, rec.Name, rec.Type))
+			return
+		}
+		seen[key] = true
+		if strings.EqualFold(rec.Name, zone.Name) && (rec.Type == "SOA" || rec.Type == "NS") {
+			result.Skipped++
+			continue
+		}
+
+		old, exists := current[key]
+		if !exists {
+			changes = append(changes, model.RecordChange{Action: "CREATE", Record: rec})
+			entries = append(entries, createEntry(auditEntry(r, h.sessionMgr, "create_record"), zone.ID, rec))
+			result.Created++
+			continue
+		}
+		rec.Name = old.Name // Route53's spelling, so only real differences count
+		if rec.Version() == old.Version() {
+			result.Unchanged++
+			continue
+		}
+		changes = append(changes, model.RecordChange{Action: "UPSERT", Record: rec})
+		entry := auditEntry(r, h.sessionMgr, "edit_record")
+		entry.ZoneID, entry.RecordName, entry.RecordType = zone.ID, rec.Name, rec.Type
+		entry.Before, entry.After = model.AuditState(old), model.AuditState(rec)
+		entry.Detail = changeSummary(entry.Changes())
+		entries = append(entries, entry)
+		result.Updated++
+	}
+
+	if len(changes) == 0 {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	comment := fmt.Sprintf("Import %d new and %d updated record sets", result.Created, result.Updated)
+	changeID, err := h.r53.ApplyChanges(r.Context(), zone.ID, comment, changes)
+	for _, entry := range entries {
+		entry.ChangeID = changeID
+		logAudit(r, h.db, withOutcome(entry, err))
+	}
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "import failed: "+err.Error())
+		return
+	}
+	info := submitted(changeID)
+	result.Change = &info
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Plan compares the record sets in the body, as read from a zone file,
+// with the zone and returns the changes Apply would make.
+func (h *APIHandler) Plan(w http.ResponseWriter, r *http.Request) {
+	_, plan, _, ok := h.plan(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// Apply brings the zone in line with the record sets in the body, in
+// change batches of at most sync.batch_size changes. Each change gets its
+// own audit entry. If a batch fails, the batches before it stay applied.
+func (h *APIHandler) Apply(w http.ResponseWriter, r *http.Request) {
+	zone, plan, comment, ok := h.plan(w, r)
+	if !ok {
+		return
+	}
+	if comment == "" {
+		comment = "Apply zone file"
+	}
+	err := zonesync.Apply(r.Context(), h.r53, plan, h.sync.BatchSize, comment, func(batch []model.PlannedChange, changeID string, err error) {
+		for _, c := range batch {
+			entry := zonesync.AuditEntry(auditEntry(r, h.sessionMgr, ""), zone.ID, c)
+			entry.ChangeID = changeID
+			logAudit(r, h.db, withOutcome(entry, err))
+		}
+	})
+	if err != nil {
+		writeAPIError(w, apiStatus(err), fmt.Sprintf("apply failed with %d batches submitted: %v", len(plan.Batches), err))
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// plan reads a SyncRequest and plans it against the zone in the path. The
+// server's managed-only setting overrides the request. It also returns the
+// request's change comment.
+func (h *APIHandler) plan(w http.ResponseWriter, r *http.Request) (model.HostedZone, *model.SyncPlan, string, bool) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return zone, nil, "", false
+	}
+	var req model.SyncRequest
+	if err := readJSON(w, r, &req, importBodyLimit); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return zone, nil, "", false
+	}
+	// An empty list deletes every record set, so it has to be explicit
+	if req.Records == nil {
+		writeAPIError(w, http.StatusBadRequest, "records is required")
+		return zone, nil, "", false
+	}
+	for i := range req.Records {
+		if err := normalizeRecord(&req.Records[i], zone.Name); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("record %d: %v", i+1, err))
+			return zone, nil, "", false
+		}
+	}
+	plan, err := zonesync.Plan(r.Context(), h.r53, zone, req.Records, req.ManagedOnly || h.sync.ManagedOnly)
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to plan: "+err.Error())
+		return zone, nil, "", false
+	}
+	return zone, plan, req.Comment, true
+}
+
+// GetChange reports whether a change has reached all Route53 name servers.
+func (h *APIHandler) GetChange(w http.ResponseWriter, r *http.Request) {
+	info, err := h.r53.GetChange(r.Context(), r.PathValue("changeID"))
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load change: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (h *APIHandler) currentUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	username, _ := h.sessionMgr.GetUsername(r)
+	user, err := h.db.GetUserByUsername(r.Context(), username)
+	if err != nil || user == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "could not load the user")
+		return nil, false
+	}
+	return user, true
+}
+
+// zone loads the zone named in the path, which also checks that the user
+// may see it.
+func (h *APIHandler) zone(w http.ResponseWriter, r *http.Request) (model.HostedZone, bool) {
+	zone, err := h.r53.GetZone(r.Context(), r.PathValue("zoneID"))
+	if err != nil {
+		writeAPIError(w, apiStatus(err), "failed to load zone: "+err.Error())
+		return zone, false
+	}
+	return zone, true
+}
+
+// normalizeRecord qualifies the record name within the zone, defaults the
+// TTL like the web form and checks the fields every record set needs.
+func normalizeRecord(rec *model.DNSRecord, zoneDomain string) error {
+	rec.Type = strings.ToUpper(strings.TrimSpace(rec.Type))
+	if strings.TrimSpace(rec.Name) == "" || rec.Type == "" {
+		return errors.New("name and type are required")
+	}
+	rec.Name = qualifyName(rec.Name, zoneDomain)
+	if rec.IsAlias {
+		return nil
+	}
+	if len(rec.Values) == 0 {
+		return fmt.Errorf("%s %s: at least one value is required", rec.Name, rec.Type)
+	}
+	if rec.TTL == 0 {
+		rec.TTL = 300
+	}
+	return nil
+}
+
+// recordKey identifies a record set within its zone.
+func recordKey(rec model.DNSRecord) string {
+	return strings.ToLower(strings.TrimSuffix(rec.Name, ".")) + " " + rec.Type + " " + rec.SetIdentifier
+}
+
+// createEntry fills in an audit entry for creating rec, worded like the web
+// UI's.
+func createEntry(entry model.AuditEntry, zoneID string, rec model.DNSRecord) model.AuditEntry {
+	entry.ZoneID, entry.RecordName, entry.RecordType = zoneID, rec.Name, rec.Type
+	if rec.IsAlias {
+		entry.Detail = fmt.Sprintf("alias=%s", rec.AliasTarget)
+	} else {
+		entry.Detail = fmt.Sprintf("values=[%s] ttl=%d", strings.Join(rec.Values, ", "), rec.TTL)
+	}
+	entry.After = model.AuditState(rec)
+	return entry
+}
+
+// requestVersion returns the record version the client based its change
+// on, from If-Match or the version query parameter, or "" for none.
+func requestVersion(r *http.Request) string {
+	if v := strings.Trim(r.Header.Get("If-Match"), `"`); v != "" && v != "*" {
+		return v
+	}
+	return r.URL.Query().Get("version")
+}
+
+// submitted describes a change batch Route53 has just accepted.
+func submitted(changeID string) model.ChangeInfo {
+	return model.ChangeInfo{ID: changeID, Status: "PENDING", SubmittedAt: time.Now().UTC()}
+}
+
+// apiStatus maps an error of the DNS service to a response status.
+func apiStatus(err error) int {
+	var ae smithy.APIError
+	switch {
+	case errors.Is(err, service.ErrZoneNotAllowed), errors.Is(err, service.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrRecordChanged):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, zonesync.ErrDuplicateRecord):
+		return http.StatusBadRequest
+	case errors.As(err, &ae):
+		switch ae.ErrorCode() {
+		case "NoSuchHostedZone", "NoSuchChange":
+			return http.StatusNotFound
+		case "InvalidChangeBatch", "InvalidInput":
+			return http.StatusBadRequest
+		case "Throttling", "PriorRequestNotComplete":
+			return http.StatusServiceUnavailable
+		}
+	}
+	return http.StatusBadGateway
+}
+
+// resolveRecord reads the current state of the record set a request
+// changes. With a version, from If-Match or the version query parameter,
+// it must still be at that version; without one the change is
+// unconditional.
+func (h *APIHandler) resolveRecord(r *http.Request, zoneID, name, recordType string) (*model.DNSRecord, error) {
+	setIdentifier := r.URL.Query().Get("set_identifier")
+	if version := requestVersion(r); version != "" {
+		return h.r53.ResolveRecord(r.Context(), zoneID, name, recordType, setIdentifier, version)
+	}
+	return h.r53.CurrentRecord(r.Context(), zoneID, name, recordType, setIdentifier)
+}
+
+// apiMessage rewords the errors of ResolveRecord, which are written for
+// the web UI.
+func apiMessage(err error) string {
+	switch {
+	case errors.Is(err, service.ErrRecordNotFound):
+		return "record not found"
+	case errors.Is(err, service.ErrRecordChanged):
+		return "record was modified since it was read; fetch it again and retry"
+	}
+	return err.Error()
+}
+
+// crossSite reports whether a browser sent r on behalf of another origin,
+// going by Sec-Fetch-Site or, from older browsers, an Origin other than
+// the host the request was sent to.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		return true
+	case "same-origin", "none":
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host == "" || !strings.EqualFold(u.Host, r.Host)
+}
+
+// readJSON decodes the body of r into v. Only application/json bodies are
+// accepted, which HTML forms cannot send.
+func readJSON(w http.ResponseWriter, r *http.Request, v any, limit int64) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errors.New("invalid request body: Content-Type must be application/json")
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("writing API response failed", "err", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, model.APIError{Error: msg})
+}
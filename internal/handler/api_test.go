@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCrossSite(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no headers", nil, false},
+		{"same origin", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "https://ns116.example.com"}, false},
+		{"typed in", map[string]string{"Sec-Fetch-Site": "none"}, false},
+		{"cross site", map[string]string{"Sec-Fetch-Site": "cross-site"}, true},
+		{"sibling subdomain", map[string]string{"Sec-Fetch-Site": "same-site"}, true},
+		{"fetch metadata wins", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://ns116.example.com"}, true},
+		{"matching origin", map[string]string{"Origin": "https://NS116.example.com"}, false},
+		{"foreign origin", map[string]string{"Origin": "https://evil.example.net"}, true},
+		{"null origin", map[string]string{"Origin": "null"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "https://ns116.example.com/api/v1/zones/Z1/apply", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := crossSite(r); got != tt.want {
+				t.Errorf("crossSite = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		ok          bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"text/plain", false},
+		{"application/x-www-form-urlencoded", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/v1/zones/Z1/apply", strings.NewReader(`{"records":[]}`))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		var v struct {
+			Records []string `json:"records"`
+		}
+		if err := readJSON(httptest.NewRecorder(), r, &v, apiBodyLimit); (err == nil) != tt.ok {
+			t.Errorf("Content-Type %q: err = %v, want ok %t", tt.contentType, err, tt.ok)
+		}
+	}
+}
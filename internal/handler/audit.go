@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"ns116/internal/auth"
+	"ns116/internal/database"
+	"ns116/internal/logging"
+	"ns116/internal/model"
+	"ns116/internal/util"
+)
+
+// auditEntry pre-fills the fields every audit entry of a request shares: the
+// acting user, how they authenticated, where they came from, the client
+// certificate they presented and the request ID that ties the entry to the
+// application log.
+func auditEntry(r *http.Request, sm *auth.SessionManager, action string) model.AuditEntry {
+	username, _ := sm.GetUsername(r)
+	return model.AuditEntry{
+		Username:        username,
+		Action:          action,
+		IPAddress:       util.GetClientIP(r),
+		AuthMethod:      sm.AuthMethod(r),
+		RequestID:       logging.RequestID(r.Context()),
+		CertFingerprint: auth.CertFingerprint(r),
+	}
+}
+
+// logAudit writes the entry. The audited operation has already happened by
+// then, so a failure is logged instead of being reported to the user.
+func logAudit(r *http.Request, db *database.DB, e model.AuditEntry) {
+	if err := db.LogAudit(r.Context(), e); err != nil {
+		slog.ErrorContext(r.Context(), "writing audit entry failed",
+			"action", e.Action, "user", e.Username, "status", e.Status, "err", err)
+	}
+}
+
+// withOutcome marks the entry as failed when the audited operation returned
+// an error.
+func withOutcome(e model.AuditEntry, err error) model.AuditEntry {
+	if err != nil {
+		e.Status = model.AuditFailure
+		e.Error = err.Error()
+	} else {
+		e.Status = model.AuditSuccess
+	}
+	return e
+}
@@ -1,12 +1,17 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
 
 	"ns116/internal/auth"
 	"ns116/internal/database"
+	"ns116/internal/logging"
+	"ns116/internal/metrics"
 	"ns116/internal/model"
 	"ns116/internal/util"
 )
@@ -14,12 +19,27 @@ import (
 type AuthHandler struct {
 	db         *database.DB
 	sessionMgr *auth.SessionManager
-	ldap       *auth.LDAPClient
 	tmpl       *template.Template
+	settings   atomic.Pointer[authSettings]
 }
 
-func NewAuthHandler(db *database.DB, sm *auth.SessionManager, ldap *auth.LDAPClient, tmpl *template.Template) *AuthHandler {
-	return &AuthHandler{db: db, sessionMgr: sm, ldap: ldap, tmpl: tmpl}
+// authSettings are the parts of AuthHandler a configuration reload swaps.
+type authSettings struct {
+	ldap *auth.LDAPClient
+	// proxyLogoutURL is where users signed in by the proxy go on logout
+	proxyLogoutURL string
+}
+
+func NewAuthHandler(db *database.DB, sm *auth.SessionManager, ldap *auth.LDAPClient, tmpl *template.Template, proxyLogoutURL string) *AuthHandler {
+	h := &AuthHandler{db: db, sessionMgr: sm, tmpl: tmpl}
+	h.Configure(ldap, proxyLogoutURL)
+	return h
+}
+
+// Configure replaces the LDAP client (nil disables LDAP) and the proxy
+// logout URL for logins from now on.
+func (h *AuthHandler) Configure(ldap *auth.LDAPClient, proxyLogoutURL string) {
+	h.settings.Store(&authSettings{ldap: ldap, proxyLogoutURL: proxyLogoutURL})
 }
 
 func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
@@ -28,7 +48,7 @@ func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	h.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
-		"LDAPEnabled": h.ldap != nil,
+		"LDAPEnabled": h.settings.Load().ldap != nil,
 	})
 }
 
@@ -36,18 +56,23 @@ func (h *AuthHandler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 	_ = r.ParseForm()
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	ldap := h.settings.Load().ldap
 
 	var user *model.User
 	var authMethod string
 
 	// Try LDAP first (if enabled)
-	if h.ldap != nil {
-		result, err := h.ldap.Authenticate(username, password)
-		if err == nil && result != nil {
+	if ldap != nil {
+		result, err := ldap.Authenticate(username, password)
+		if err != nil || result == nil {
+			slog.InfoContext(r.Context(), "LDAP authentication failed", "user", username, "err", err)
+			metrics.Login("ldap", "failure")
+		} else {
 			// LDAP auth succeeded — now check group membership
-			role, allowed := h.ldap.ResolveRole(result.Groups)
+			role, allowed := ldap.ResolveRole(result.Groups)
 			if !allowed {
 				// User authenticated but is not in any mapped group
+				metrics.Login("ldap", "denied")
 				h.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
 					"Error":       "Access denied: you are not in an authorized group",
 					"LDAPEnabled": true,
@@ -56,18 +81,33 @@ func (h *AuthHandler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Auto-provision or update user
-			_ = h.db.CreateLDAPUser(result.Username, role)
-			user, _ = h.db.GetUserByUsername(result.Username)
+			err := h.db.CreateExternalUser(r.Context(), "ldap", result.Username, role, result.Email)
+			if errors.Is(err, database.ErrLastAdmin) {
+				metrics.Login("ldap", "denied")
+				h.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
+					"Error":       "Access denied: your groups no longer grant the admin role, and you are the last active admin",
+					"LDAPEnabled": true,
+				})
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "provisioning LDAP user failed", "user", result.Username, "err", err)
+			}
+			user, _ = h.db.GetUserByUsername(r.Context(), result.Username)
 			authMethod = "ldap"
+			metrics.Login("ldap", "success")
 		}
 	}
 
 	// Local fallback — only for admin when LDAP is enabled
 	if user == nil {
-		u, err := h.db.AuthenticateUser(username, password)
-		if err == nil && u != nil {
-			if h.ldap != nil && u.Role != "admin" {
+		u, err := h.db.AuthenticateUser(r.Context(), username, password)
+		if err != nil || u == nil {
+			metrics.Login("local", "failure")
+		} else {
+			if ldap != nil && u.Role != "admin" {
 				// LDAP is enabled: block non-admin local users
+				metrics.Login("local", "denied")
 				h.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
 					"Error":       "Local login is disabled. Use LDAP credentials.",
 					"LDAPEnabled": true,
@@ -76,25 +116,45 @@ func (h *AuthHandler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 			}
 			user = u
 			authMethod = "local"
+			metrics.Login("local", "success")
 		}
 	}
 
 	// Both failed
 	if user == nil {
+		logAudit(r, h.db, model.AuditEntry{
+			Username:        username,
+			Action:          "login",
+			IPAddress:       util.GetClientIP(r),
+			Status:          model.AuditFailure,
+			Error:           "invalid credentials",
+			RequestID:       logging.RequestID(r.Context()),
+			CertFingerprint: auth.CertFingerprint(r),
+		})
 		h.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
 			"Error":       "Invalid credentials",
-			"LDAPEnabled": h.ldap != nil,
+			"LDAPEnabled": ldap != nil,
 		})
 		return
 	}
 
-	h.sessionMgr.CreateSession(w, user.Username)
+	if _, err := h.sessionMgr.CreateSession(r.Context(), w, user.Username, authMethod); err != nil {
+		slog.ErrorContext(r.Context(), "creating session failed", "user", user.Username, "err", err)
+		h.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
+			"Error":       "Could not start a session. Please try again.",
+			"LDAPEnabled": ldap != nil,
+		})
+		return
+	}
 
-	_ = h.db.LogAudit(model.AuditEntry{
-		Username:  user.Username,
-		Action:    "login",
-		Detail:    fmt.Sprintf("auth=%s", authMethod),
-		IPAddress: util.GetClientIP(r),
+	logAudit(r, h.db, model.AuditEntry{
+		Username:        user.Username,
+		Action:          "login",
+		Detail:          fmt.Sprintf("auth=%s", authMethod),
+		IPAddress:       util.GetClientIP(r),
+		AuthMethod:      authMethod,
+		RequestID:       logging.RequestID(r.Context()),
+		CertFingerprint: auth.CertFingerprint(r),
 	})
 
 	http.Redirect(w, r, "/zones", http.StatusSeeOther)
@@ -102,16 +162,26 @@ func (h *AuthHandler) LoginSubmit(w http.ResponseWriter, r *http.Request) {
 
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	username, _ := h.sessionMgr.GetUsername(r)
+	authMethod := h.sessionMgr.AuthMethod(r)
 
 	h.sessionMgr.DestroySession(w, r)
 
 	if username != "" {
-		_ = h.db.LogAudit(model.AuditEntry{
-			Username:  username,
-			Action:    "logout",
-			IPAddress: util.GetClientIP(r),
+		logAudit(r, h.db, model.AuditEntry{
+			Username:        username,
+			Action:          "logout",
+			IPAddress:       util.GetClientIP(r),
+			AuthMethod:      authMethod,
+			RequestID:       logging.RequestID(r.Context()),
+			CertFingerprint: auth.CertFingerprint(r),
 		})
 	}
 
+	// Without signing out of the proxy too, the next request would start a
+	// new session right away
+	if logoutURL := h.settings.Load().proxyLogoutURL; authMethod == "proxy" && logoutURL != "" {
+		http.Redirect(w, r, logoutURL, http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
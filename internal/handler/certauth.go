@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"ns116/internal/auth"
+	"ns116/internal/database"
+	"ns116/internal/metrics"
+	"ns116/internal/model"
+)
+
+// CertAuth signs in clients that present a verified TLS client certificate
+// matching one of the server.tls.client_certs.users rules. A certificate may
+// sign in to an existing account of any kind; a user is only provisioned
+// when the rule carries a role. A certificate session ends as soon as the
+// client stops presenting the certificate.
+type CertAuth struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	mapper     *auth.CertMapper
+}
+
+func NewCertAuth(db *database.DB, sm *auth.SessionManager, mapper *auth.CertMapper) *CertAuth {
+	return &CertAuth{db: db, sessionMgr: sm, mapper: mapper}
+}
+
+func (c *CertAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.mapper.Identify(r)
+		session, hasSession := c.sessionMgr.Session(r)
+
+		if !ok {
+			if hasSession && session.AuthMethod == "cert" {
+				c.sessionMgr.DestroySession(w, r)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if hasSession && session.Username == id.Username {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.signIn(w, r, id)
+	})
+}
+
+func (c *CertAuth) signIn(w http.ResponseWriter, r *http.Request, id auth.CertIdentity) {
+	user, err := certAccount(r.Context(), c.db, id)
+	if errors.Is(err, errNoCertAccount) || errors.Is(err, errCertAccountDisabled) || errors.Is(err, errCertLastAdmin) {
+		metrics.Login("cert", "denied")
+		http.Error(w, "Access denied: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading certificate user failed", "user", id.Username, "err", err)
+		http.Error(w, "Could not sign you in. Please try again.", http.StatusServiceUnavailable)
+		return
+	}
+	startExternalSession(w, r, c.db, c.sessionMgr, id.Username, "cert", "auth=cert role="+user.Role)
+}
+
+var (
+	errNoCertAccount       = errors.New("no account exists for this certificate")
+	errCertAccountDisabled = errors.New("your account is disabled")
+	errCertLastAdmin       = errors.New("the role for this certificate would demote the last active admin")
+)
+
+// certAccount returns the account a certificate identity signs in to. Users
+// provisioned from a certificate are created, or follow the configured role,
+// when the matching rule carries one.
+func certAccount(ctx context.Context, db *database.DB, id auth.CertIdentity) (*model.User, error) {
+	user, err := db.GetUserByUsername(ctx, id.Username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil && id.Role == "" {
+		return nil, errNoCertAccount
+	}
+	if user != nil && !user.Active {
+		return nil, errCertAccountDisabled
+	}
+	if user != nil && (user.AuthSource != "cert" || id.Role == "") {
+		return user, nil
+	}
+	err = db.CreateExternalUser(ctx, "cert", id.Username, id.Role, id.Email)
+	if errors.Is(err, database.ErrLastAdmin) {
+		return nil, errCertLastAdmin
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.GetUserByUsername(ctx, id.Username)
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+// ConfigReloader applies a fresh copy of the configuration file to the
+// running server.
+type ConfigReloader interface {
+	// Current returns the configuration in effect.
+	Current() *config.Config
+	// Reload re-reads the configuration, audited on behalf of actor, and
+	// returns the changes applied and those that need a restart.
+	Reload(ctx context.Context, actor model.AuditEntry) (applied, restart []config.Change, err error)
+}
+
+type ConfigHandler struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	tmpl       *template.Template
+	reloader   ConfigReloader
+}
+
+func NewConfigHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template, reloader ConfigReloader) *ConfigHandler {
+	return &ConfigHandler{db: db, sessionMgr: sm, tmpl: tmpl, reloader: reloader}
+}
+
+func (h *ConfigHandler) Page(w http.ResponseWriter, r *http.Request) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	h.tmpl.ExecuteTemplate(w, "layout", map[string]interface{}{
+		"Title":     "Configuration",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Settings":  h.reloader.Current().Summary(),
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	})
+}
+
+func (h *ConfigHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	applied, restart, err := h.reloader.Reload(r.Context(), auditEntry(r, h.sessionMgr, ""))
+	if err != nil {
+		http.Redirect(w, r, "/admin/config?error="+url.QueryEscape("Configuration rejected, nothing changed: "+err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	msg := "Configuration reloaded: no changes"
+	if len(applied) > 0 {
+		msg = fmt.Sprintf("Configuration reloaded: %d setting(s) applied", len(applied))
+	}
+	if len(restart) > 0 {
+		paths := make([]string, len(restart))
+		for i, c := range restart {
+			paths[i] = c.Path
+		}
+		msg += "; restart required for " + strings.Join(paths, ", ")
+	}
+	http.Redirect(w, r, "/admin/config?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
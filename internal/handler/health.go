@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"ns116/internal/database"
+	"ns116/internal/service"
+)
+
+const readyCheckTimeout = 5 * time.Second
+
+// HealthHandler serves the liveness and readiness probes. Both are public
+// and answer in plain text.
+type HealthHandler struct {
+	db  *database.DB
+	r53 *service.DNSService // nil unless Route53 is part of readiness
+}
+
+func NewHealthHandler(db *database.DB, r53 *service.DNSService) *HealthHandler {
+	return &HealthHandler{db: db, r53: r53}
+}
+
+type readyCheck struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// Healthz reports that the process is up and serving requests.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// Readyz reports whether the instance can serve users: the database answers
+// with the expected schema and, when configured, Route53 is reachable. Error
+// details are logged rather than returned to the unauthenticated caller.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []readyCheck{{"database", h.db.Ready}}
+	if h.r53 != nil {
+		checks = append(checks, readyCheck{"route53", h.r53.Ping})
+	}
+
+	var b strings.Builder
+	ready := true
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+		err := c.fn(ctx)
+		cancel()
+		if err != nil {
+			slog.WarnContext(r.Context(), "readiness check failed", "check", c.name, "err", err)
+			fmt.Fprintf(&b, "[-] %s failed\n", c.name)
+			ready = false
+			continue
+		}
+		fmt.Fprintf(&b, "[+] %s ok\n", c.name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"ns116/internal/auth"
+	"ns116/internal/database"
+	"ns116/internal/model"
+	"ns116/internal/notify"
+	"ns116/internal/service"
+)
+
+type NotificationHandler struct {
+	r53        *service.DNSService
+	sessionMgr *auth.SessionManager
+	db         *database.DB
+	tmpl       *template.Template
+	notifier   *notify.Notifier // nil when no SMTP server is configured
+}
+
+func NewNotificationHandler(r53 *service.DNSService, sm *auth.SessionManager, db *database.DB, tmpl *template.Template, notifier *notify.Notifier) *NotificationHandler {
+	return &NotificationHandler{r53: r53, sessionMgr: sm, db: db, tmpl: tmpl, notifier: notifier}
+}
+
+func (h *NotificationHandler) Page(w http.ResponseWriter, r *http.Request) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	data := map[string]interface{}{
+		"Title":     "Notifications",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"User":      user,
+		"Enabled":   h.notifier != nil,
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	}
+	if user == nil {
+		h.tmpl.ExecuteTemplate(w, "layout", data)
+		return
+	}
+
+	subs, err := h.db.ListNotificationSubscriptions(r.Context(), user.ID)
+	if err != nil {
+		data["Error"] = "Failed to load subscriptions: " + err.Error()
+	}
+	zones, err := h.r53.ListZones(r.Context())
+	if err != nil {
+		data["Error"] = "Failed to load zones: " + err.Error()
+	}
+	names := make(map[string]string, len(zones))
+	for _, z := range zones {
+		names[z.ID] = z.Name
+	}
+	data["Subscriptions"] = subs
+	data["Zones"] = zones
+	data["ZoneNames"] = names
+
+	h.tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+// SetEmail changes the address of a local user. LDAP users' addresses are
+// taken from the directory at every login.
+func (h *NotificationHandler) SetEmail(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	username, _ := h.sessionMgr.GetUsername(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+	if user == nil || user.AuthSource != "local" {
+		redirectNotifications(w, r, "error", "Your email address is managed by the directory service")
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Name != "" {
+			redirectNotifications(w, r, "error", "Invalid email address")
+			return
+		}
+		email = addr.Address
+	}
+	if email == user.Email {
+		redirectNotifications(w, r, "msg", "Email address unchanged")
+		return
+	}
+
+	err := h.db.SetUserEmail(r.Context(), username, email)
+	entry := auditEntry(r, h.sessionMgr, "update_email")
+	entry.Detail = fmt.Sprintf("email: %q -> %q", user.Email, email)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectNotifications(w, r, "error", "Failed to save email address: "+err.Error())
+		return
+	}
+	redirectNotifications(w, r, "msg", "Email address saved")
+}
+
+func (h *NotificationHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	user := h.currentUser(r)
+	zoneID := r.FormValue("zone_id")
+	mode := r.FormValue("mode")
+	if mode != model.NotifyInstant && mode != model.NotifyDigest {
+		redirectNotifications(w, r, "error", "Unknown notification mode")
+		return
+	}
+	if user == nil {
+		redirectNotifications(w, r, "error", "User not found")
+		return
+	}
+	if zoneID != "" {
+		// Only zones the user can see may be subscribed to
+		if _, err := h.r53.GetZone(r.Context(), zoneID); err != nil {
+			redirectNotifications(w, r, "error", "Unknown zone")
+			return
+		}
+	}
+
+	if err := h.db.SetNotificationSubscription(r.Context(), user.ID, zoneID, mode); err != nil {
+		redirectNotifications(w, r, "error", "Failed to subscribe: "+err.Error())
+		return
+	}
+	redirectNotifications(w, r, "msg", "Subscription saved")
+}
+
+func (h *NotificationHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	user := h.currentUser(r)
+	if user == nil {
+		redirectNotifications(w, r, "error", "User not found")
+		return
+	}
+	if err := h.db.DeleteNotificationSubscription(r.Context(), user.ID, r.FormValue("zone_id")); err != nil {
+		redirectNotifications(w, r, "error", "Failed to unsubscribe: "+err.Error())
+		return
+	}
+	redirectNotifications(w, r, "msg", "Subscription removed")
+}
+
+// SendTest emails the current user directly so SMTP problems show up on the
+// page instead of in the retry queue.
+func (h *NotificationHandler) SendTest(w http.ResponseWriter, r *http.Request) {
+	user := h.currentUser(r)
+	if h.notifier == nil {
+		redirectNotifications(w, r, "error", "Email notifications are not configured")
+		return
+	}
+	if user == nil || user.Email == "" {
+		redirectNotifications(w, r, "error", "Set an email address first")
+		return
+	}
+	if err := h.notifier.SendTest(r.Context(), user.Email, user.Username); err != nil {
+		redirectNotifications(w, r, "error", "Sending failed: "+err.Error())
+		return
+	}
+	redirectNotifications(w, r, "msg", "Test email sent to "+user.Email)
+}
+
+func (h *NotificationHandler) currentUser(r *http.Request) *model.User {
+	username, _ := h.sessionMgr.GetUsername(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+	return user
+}
+
+func redirectNotifications(w http.ResponseWriter, r *http.Request, key, msg string) {
+	http.Redirect(w, r, "/account/notifications?"+key+"="+url.QueryEscape(msg), http.StatusSeeOther)
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/logging"
+	"ns116/internal/metrics"
+	"ns116/internal/model"
+	"ns116/internal/util"
+)
+
+// ProxyAuth signs in users authenticated by a reverse proxy. On requests
+// from a trusted proxy that carry the user header it provisions the user,
+// with a role from the groups header, and starts a session, so the login
+// page is never shown. A proxy session ends as soon as the proxy stops
+// sending the header or names someone else. Nothing happens while
+// proxy_auth is disabled.
+type ProxyAuth struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	cfg        atomic.Pointer[config.ProxyAuthConfig]
+	// clientIP holds server.trusted_proxies, which a reload may change
+	clientIP *util.ClientIP
+}
+
+func NewProxyAuth(db *database.DB, sm *auth.SessionManager, cfg config.ProxyAuthConfig, clientIP *util.ClientIP) *ProxyAuth {
+	p := &ProxyAuth{db: db, sessionMgr: sm, clientIP: clientIP}
+	p.Configure(cfg)
+	return p
+}
+
+// Configure replaces the proxy_auth settings for requests from now on.
+func (p *ProxyAuth) Configure(cfg config.ProxyAuthConfig) {
+	p.cfg.Store(&cfg)
+}
+
+func (p *ProxyAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := p.cfg.Load()
+		if !cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var username string
+		if p.clientIP.FromTrustedProxy(r) {
+			username = strings.TrimSpace(r.Header.Get(cfg.UserHeader))
+		}
+		session, hasSession := p.sessionMgr.Session(r)
+
+		if username == "" {
+			if hasSession && session.AuthMethod == "proxy" {
+				// The request no longer comes through the proxy, or the
+				// proxy signed the user out
+				p.sessionMgr.DestroySession(w, r)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		role, ok := resolveProxyRole(cfg, r)
+		if !ok {
+			metrics.Login("proxy", "denied")
+			if hasSession {
+				p.sessionMgr.DestroySession(w, r)
+			}
+			http.Error(w, "Access denied: you are not in an authorized group", http.StatusForbidden)
+			return
+		}
+		user, err := p.db.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading proxy user failed", "user", username, "err", err)
+			http.Error(w, "Could not sign you in. Please try again.", http.StatusServiceUnavailable)
+			return
+		}
+		// A proxy session carries on only while the groups header still
+		// maps to the stored role, so group changes at the identity
+		// provider apply on the next request
+		if hasSession && session.Username == username &&
+			(session.AuthMethod != "proxy" || user != nil && user.Role == role) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p.signIn(w, r, cfg, user, username, role)
+	})
+}
+
+func resolveProxyRole(cfg *config.ProxyAuthConfig, r *http.Request) (string, bool) {
+	if cfg.GroupsHeader != "" {
+		var groups []string
+		for _, g := range strings.Split(r.Header.Get(cfg.GroupsHeader), cfg.GroupsSeparator) {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+		if role, ok := auth.ResolveRole(cfg.GroupMapping, groups); ok {
+			return role, true
+		}
+	}
+	return cfg.DefaultRole, cfg.DefaultRole != ""
+}
+
+// signIn provisions username, stored as user if it exists, with role and
+// starts a new session for it.
+func (p *ProxyAuth) signIn(w http.ResponseWriter, r *http.Request, cfg *config.ProxyAuthConfig, user *model.User, username, role string) {
+	if user != nil && user.AuthSource == "local" {
+		// Never let a proxy identity take over a password account such as
+		// the break-glass admin
+		metrics.Login("proxy", "denied")
+		p.sessionMgr.DestroySession(w, r)
+		http.Error(w, "Access denied: a local account with this name exists", http.StatusForbidden)
+		return
+	}
+	if user != nil && !user.Active {
+		metrics.Login("proxy", "denied")
+		p.sessionMgr.DestroySession(w, r)
+		http.Error(w, "Access denied: your account is disabled", http.StatusForbidden)
+		return
+	}
+
+	var email string
+	if cfg.EmailHeader != "" {
+		email = strings.TrimSpace(r.Header.Get(cfg.EmailHeader))
+	}
+	err := p.db.CreateExternalUser(r.Context(), "proxy", username, role, email)
+	if errors.Is(err, database.ErrLastAdmin) {
+		metrics.Login("proxy", "denied")
+		p.sessionMgr.DestroySession(w, r)
+		http.Error(w, "Access denied: your groups no longer grant the admin role, and you are the last active admin", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "provisioning proxy user failed", "user", username, "err", err)
+		http.Error(w, "Could not sign you in. Please try again.", http.StatusServiceUnavailable)
+		return
+	}
+	startExternalSession(w, r, p.db, p.sessionMgr, username, "proxy", "auth=proxy role="+role)
+}
+
+// startExternalSession signs in a user authenticated by something other than
+// the login form, audits it and sends the client back to the page it asked
+// for.
+func startExternalSession(w http.ResponseWriter, r *http.Request, db *database.DB, sm *auth.SessionManager, username, authMethod, detail string) {
+	if _, err := sm.ReplaceSession(w, r, username, authMethod); err != nil {
+		slog.ErrorContext(r.Context(), "creating session failed", "user", username, "err", err)
+		http.Error(w, "Could not start a session. Please try again.", http.StatusServiceUnavailable)
+		return
+	}
+	metrics.Login(authMethod, "success")
+
+	logAudit(r, db, model.AuditEntry{
+		Username:        username,
+		Action:          "login",
+		Detail:          detail,
+		IPAddress:       util.GetClientIP(r),
+		AuthMethod:      authMethod,
+		RequestID:       logging.RequestID(r.Context()),
+		CertFingerprint: auth.CertFingerprint(r),
+	})
+
+	// The new session cookie only applies from the next request on
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -12,7 +13,6 @@ import (
 	"ns116/internal/database"
 	"ns116/internal/model"
 	"ns116/internal/service"
-	"ns116/internal/util"
 )
 
 type RecordHandler struct {
@@ -29,7 +29,7 @@ func NewRecordHandler(r53 *service.DNSService, sm *auth.SessionManager, db *data
 func (h *RecordHandler) List(w http.ResponseWriter, r *http.Request) {
 	zoneID := r.PathValue("zoneID")
 	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
-	user, _ := h.db.GetUserByUsername(username)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
 
 	zone, err := h.r53.GetZone(r.Context(), zoneID)
 	if err != nil {
@@ -94,7 +94,6 @@ func qualifyName(name, zoneDomain string) string {
 
 func (h *RecordHandler) Create(w http.ResponseWriter, r *http.Request) {
 	zoneID := r.PathValue("zoneID")
-	username, _ := h.sessionMgr.GetUsername(r)
 	_ = r.ParseForm()
 
 	zone, err := h.r53.GetZone(r.Context(), zoneID)
@@ -112,26 +111,25 @@ func (h *RecordHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	msg := "Record created successfully"
-	if err := h.r53.ChangeRecord(r.Context(), zoneID, req); err != nil {
+	changeID, err := h.r53.ChangeRecord(r.Context(), zoneID, req)
+	if err != nil {
 		msg = "Error: " + err.Error()
 	}
 
-	_ = h.db.LogAudit(model.AuditEntry{
-		Username:   username,
-		Action:     "create_record",
-		ZoneID:     zoneID,
-		RecordName: req.Name,
-		RecordType: req.Type,
-		Detail:     fmt.Sprintf("values=[%s] ttl=%d", strings.Join(req.Values, ", "), req.TTL),
-		IPAddress:  util.GetClientIP(r),
-	})
+	entry := auditEntry(r, h.sessionMgr, "create_record")
+	entry.ZoneID = zoneID
+	entry.RecordName = req.Name
+	entry.RecordType = req.Type
+	entry.Detail = fmt.Sprintf("values=[%s] ttl=%d", strings.Join(req.Values, ", "), req.TTL)
+	entry.After = model.AuditState(requestRecord(req))
+	entry.ChangeID = changeID
+	logAudit(r, h.db, withOutcome(entry, err))
 
-	http.Redirect(w, r, fmt.Sprintf("/zones/%s/records?msg=%s", zoneID, url.QueryEscape(msg)), http.StatusSeeOther)
+	redirectRecords(w, r, zoneID, msg)
 }
 
 func (h *RecordHandler) Edit(w http.ResponseWriter, r *http.Request) {
 	zoneID := r.PathValue("zoneID")
-	username, _ := h.sessionMgr.GetUsername(r)
 	_ = r.ParseForm()
 
 	zone, err := h.r53.GetZone(r.Context(), zoneID)
@@ -140,131 +138,124 @@ func (h *RecordHandler) Edit(w http.ResponseWriter, r *http.Request) {
 		zoneDomain = zone.Name
 	}
 
-	originalName := r.FormValue("original_name")
-	originalType := r.FormValue("original_type")
-	newName := qualifyName(r.FormValue("name"), zoneDomain)
-	newType := r.FormValue("type")
-
-	var diffs []string
-	if originalName != newName {
-		diffs = append(diffs, fmt.Sprintf("name: %s -> %s", originalName, newName))
-	}
-	if originalType != newType {
-		diffs = append(diffs, fmt.Sprintf("type: %s -> %s", originalType, newType))
-	}
-	oldTTLStr := r.FormValue("original_ttl")
-	newTTLStr := r.FormValue("ttl")
-	if oldTTLStr != newTTLStr {
-		diffs = append(diffs, fmt.Sprintf("ttl: %s -> %s", oldTTLStr, newTTLStr))
+	entry := auditEntry(r, h.sessionMgr, "edit_record")
+	entry.ZoneID = zoneID
+	entry.RecordName = r.FormValue("original_name")
+	entry.RecordType = r.FormValue("original_type")
+
+	// The hidden fields only identify the record; its current contents come
+	// from Route53 so a stale page cannot delete or audit the wrong values.
+	current, err := h.r53.ResolveRecord(r.Context(), zoneID, entry.RecordName, entry.RecordType,
+		r.FormValue("set_identifier"), r.FormValue("version"))
+	if err == nil && current.IsAlias {
+		err = fmt.Errorf("alias records cannot be edited here")
 	}
-	oldVals := strings.Join(r.Form["original_value"], ", ")
-	newVals := strings.Join(r.Form["value"], ", ")
-	if oldVals != newVals {
-		diffs = append(diffs, fmt.Sprintf("values: [%s] -> [%s]", oldVals, newVals))
-	}
-	detailStr := strings.Join(diffs, "; ")
-
-	// If Name and Type are unchanged, use UPSERT (atomic update)
-	if originalName == newName && originalType == newType {
-		upsertReq := model.RecordChangeRequest{
-			Action: "UPSERT",
-			Name:   newName,
-			Type:   newType,
-			TTL:    parseTTL(r.FormValue("ttl")),
-			Values: r.Form["value"],
-		}
-
-		msg := "Record updated successfully"
-		if err := h.r53.ChangeRecord(r.Context(), zoneID, upsertReq); err != nil {
-			msg = "Error updating record: " + err.Error()
+	if err != nil {
+		if current != nil {
+			entry.Before = model.AuditState(current)
 		}
-
-		_ = h.db.LogAudit(model.AuditEntry{
-			Username:   username,
-			Action:     "edit_record",
-			ZoneID:     zoneID,
-			RecordName: upsertReq.Name,
-			RecordType: upsertReq.Type,
-			Detail:     detailStr,
-			IPAddress:  util.GetClientIP(r),
-		})
-
-		http.Redirect(w, r, fmt.Sprintf("/zones/%s/records?msg=%s", zoneID, url.QueryEscape(msg)), http.StatusSeeOther)
-		return
-	}
-
-	// If Name or Type changed, we must DELETE old and CREATE new (non-atomic 2-step process)
-	deleteReq := model.RecordChangeRequest{
-		Action: "DELETE",
-		Name:   originalName,
-		Type:   originalType,
-		TTL:    parseTTL(r.FormValue("original_ttl")),
-		Values: r.Form["original_value"],
-	}
-
-	if err := h.r53.ChangeRecord(r.Context(), zoneID, deleteReq); err != nil {
-		msg := "Error deleting old record: " + err.Error()
-		http.Redirect(w, r, fmt.Sprintf("/zones/%s/records?msg=%s", zoneID, url.QueryEscape(msg)), http.StatusSeeOther)
+		logAudit(r, h.db, withOutcome(entry, err))
+		redirectRecords(w, r, zoneID, "Error: "+err.Error())
 		return
 	}
 
-	createReq := model.RecordChangeRequest{
-		Action: "CREATE",
-		Name:   newName,
-		Type:   newType,
-		TTL:    parseTTL(r.FormValue("ttl")),
-		Values: r.Form["value"],
+	// Start from the current record so routing policy and set identifier
+	// carry over unchanged
+	updated := *current
+	updated.Name = qualifyName(r.FormValue("name"), zoneDomain)
+	updated.Type = r.FormValue("type")
+	updated.TTL = parseTTL(r.FormValue("ttl"))
+	updated.Values = r.Form["value"]
+
+	entry.RecordName = updated.Name
+	entry.RecordType = updated.Type
+	entry.Before = model.AuditState(current)
+	entry.After = model.AuditState(updated)
+	entry.Detail = changeSummary(entry.Changes())
+
+	// Same name and type is an in-place UPSERT; otherwise the old set is
+	// deleted and the new one created in a single atomic batch
+	changes := []model.RecordChange{{Action: "UPSERT", Record: updated}}
+	if !strings.EqualFold(current.Name, updated.Name) || current.Type != updated.Type {
+		changes = []model.RecordChange{
+			{Action: "DELETE", Record: *current},
+			{Action: "CREATE", Record: updated},
+		}
 	}
 
 	msg := "Record updated successfully"
-	if err := h.r53.ChangeRecord(r.Context(), zoneID, createReq); err != nil {
-		msg = "Error creating new record: " + err.Error()
+	changeID, err := h.r53.ApplyChanges(r.Context(), zoneID, service.ChangeComment("Update", updated), changes)
+	if err != nil {
+		msg = "Error updating record: " + err.Error()
 	}
 
-	_ = h.db.LogAudit(model.AuditEntry{
-		Username:   username,
-		Action:     "edit_record",
-		ZoneID:     zoneID,
-		RecordName: createReq.Name,
-		RecordType: createReq.Type,
-		Detail:     detailStr,
-		IPAddress:  util.GetClientIP(r),
-	})
+	entry.ChangeID = changeID
+	logAudit(r, h.db, withOutcome(entry, err))
 
-	http.Redirect(w, r, fmt.Sprintf("/zones/%s/records?msg=%s", zoneID, url.QueryEscape(msg)), http.StatusSeeOther)
+	redirectRecords(w, r, zoneID, msg)
 }
 
 func (h *RecordHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	zoneID := r.PathValue("zoneID")
-	username, _ := h.sessionMgr.GetUsername(r)
 	_ = r.ParseForm()
 
-	req := model.RecordChangeRequest{
-		Action: "DELETE",
-		Name:   r.FormValue("name"),
-		Type:   r.FormValue("type"),
-		TTL:    parseTTL(r.FormValue("ttl")),
-		Values: r.Form["value"],
+	entry := auditEntry(r, h.sessionMgr, "delete_record")
+	entry.ZoneID = zoneID
+	entry.RecordName = r.FormValue("name")
+	entry.RecordType = r.FormValue("type")
+
+	current, err := h.r53.ResolveRecord(r.Context(), zoneID, entry.RecordName, entry.RecordType,
+		r.FormValue("set_identifier"), r.FormValue("version"))
+	if current != nil {
+		entry.Before = model.AuditState(current)
+		entry.Detail = recordDetail(*current)
+	}
+	if err != nil {
+		logAudit(r, h.db, withOutcome(entry, err))
+		redirectRecords(w, r, zoneID, "Error: "+err.Error())
+		return
 	}
 
 	msg := "Record deleted successfully"
-	if err := h.r53.ChangeRecord(r.Context(), zoneID, req); err != nil {
+	changeID, err := h.r53.ApplyChanges(r.Context(), zoneID, service.ChangeComment("Delete", *current),
+		[]model.RecordChange{{Action: "DELETE", Record: *current}})
+	if err != nil {
 		msg = "Error: " + err.Error()
 	}
 
-	_ = h.db.LogAudit(model.AuditEntry{
-		Username:   username,
-		Action:     "delete_record",
-		ZoneID:     zoneID,
-		RecordName: req.Name,
-		RecordType: req.Type,
-		Detail:     fmt.Sprintf("ttl=%d values=[%s]", req.TTL, strings.Join(req.Values, ", ")),
-		IPAddress:  util.GetClientIP(r),
-	})
+	entry.ChangeID = changeID
+	logAudit(r, h.db, withOutcome(entry, err))
 
+	redirectRecords(w, r, zoneID, msg)
+}
+
+func redirectRecords(w http.ResponseWriter, r *http.Request, zoneID, msg string) {
 	http.Redirect(w, r, fmt.Sprintf("/zones/%s/records?msg=%s", zoneID, url.QueryEscape(msg)), http.StatusSeeOther)
 }
 
+// changeSummary renders audit field changes in the legacy detail format,
+// e.g. "ttl: 300 -> 60; values: [a] -> [b]".
+func changeSummary(changes []model.AuditChange) string {
+	parts := make([]string, 0, len(changes))
+	for _, c := range changes {
+		parts = append(parts, fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// recordDetail describes a deleted record set for the audit detail.
+func recordDetail(rec model.DNSRecord) string {
+	if rec.IsAlias {
+		return fmt.Sprintf("alias=%s", rec.AliasTarget)
+	}
+	return fmt.Sprintf("ttl=%d values=[%s]", rec.TTL, strings.Join(rec.Values, ", "))
+}
+
+// requestRecord is the record state a change request describes.
+func requestRecord(req model.RecordChangeRequest) model.DNSRecord {
+	return model.DNSRecord{Name: req.Name, Type: req.Type, TTL: req.TTL, Values: req.Values}
+}
+
 func parseTTL(s string) int64 {
 	v, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
@@ -275,6 +266,8 @@ func parseTTL(s string) int64 {
 
 func (h *RecordHandler) RefreshRecords(w http.ResponseWriter, r *http.Request) {
 	zoneID := r.PathValue("zoneID")
-	h.db.InvalidateRecordCache(zoneID)
+	if err := h.db.InvalidateRecordCache(r.Context(), zoneID); err != nil {
+		slog.ErrorContext(r.Context(), "invalidating record cache failed", "zone_id", zoneID, "err", err)
+	}
 	http.Redirect(w, r, fmt.Sprintf("/zones/%s/records", zoneID), http.StatusSeeOther)
 }
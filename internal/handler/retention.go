@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"ns116/internal/audit"
+	"ns116/internal/auth"
+	"ns116/internal/database"
+)
+
+type RetentionHandler struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	tmpl       *template.Template
+	retention  *audit.Retention
+}
+
+func NewRetentionHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template, retention *audit.Retention) *RetentionHandler {
+	return &RetentionHandler{db: db, sessionMgr: sm, tmpl: tmpl, retention: retention}
+}
+
+func (h *RetentionHandler) Status(w http.ResponseWriter, r *http.Request) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	data := map[string]interface{}{
+		"Title":     "Audit Retention",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Enabled":   h.retention.Enabled(),
+		"Days":      h.retention.Days(),
+		"Dir":       h.retention.Dir(),
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	}
+
+	stats, err := h.db.AuditStats(r.Context())
+	if err != nil {
+		data["Error"] = "Failed to load audit statistics: " + err.Error()
+	}
+	data["Stats"] = stats
+	if run, err := h.retention.LastRun(r.Context()); err == nil && run != nil {
+		data["LastRun"] = run
+	}
+	if anchor, err := h.db.GetAuditAnchor(r.Context()); err == nil && anchor != nil {
+		data["Anchor"] = anchor
+	}
+	if archives, err := h.retention.Archives(); err == nil {
+		data["Archives"] = archives
+	} else {
+		data["Error"] = "Failed to list archives: " + err.Error()
+	}
+
+	h.tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+// RunNow performs a retention pass immediately instead of waiting for the
+// daily schedule.
+func (h *RetentionHandler) RunNow(w http.ResponseWriter, r *http.Request) {
+	run, err := h.retention.Run(r.Context(), auditEntry(r, h.sessionMgr, ""))
+	if err != nil {
+		http.Redirect(w, r, "/admin/audit/retention?error="+url.QueryEscape("Retention failed: "+err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	msg := "Nothing to archive"
+	if run.Archived > 0 {
+		msg = fmt.Sprintf("Archived %d entries (#%d–#%d)", run.Archived, run.FirstID, run.LastID)
+	}
+	http.Redirect(w, r, "/admin/audit/retention?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
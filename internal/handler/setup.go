@@ -1,59 +1,90 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"html/template"
+	"log/slog"
 	"net/http"
 
+	"ns116/internal/auth"
+	"ns116/internal/config"
 	"ns116/internal/database"
+	"ns116/internal/util"
 )
 
 type SetupHandler struct {
-	db   *database.DB
-	tmpl *template.Template
+	db     *database.DB
+	tmpl   *template.Template
+	policy config.PasswordPolicyConfig
+	token  string
+	// disabled is set when the first admin must come from configuration
+	disabled bool
 }
 
-func NewSetupHandler(db *database.DB, tmpl *template.Template) *SetupHandler {
-	return &SetupHandler{db: db, tmpl: tmpl}
+func NewSetupHandler(db *database.DB, tmpl *template.Template, policy config.PasswordPolicyConfig, token string, disabled bool) *SetupHandler {
+	return &SetupHandler{db: db, tmpl: tmpl, policy: policy, token: token, disabled: disabled}
 }
 
 func (h *SetupHandler) SetupPage(w http.ResponseWriter, r *http.Request) {
-	hasUsers, _ := h.db.HasUsers()
+	hasUsers, err := h.db.HasUsers(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "checking for users failed", "err", err)
+	}
 	if hasUsers {
 		http.NotFound(w, r)
 		return
 	}
+	if h.disabled {
+		http.Error(w, "Web setup is disabled. Bootstrap the admin account via setup.admin_username/admin_password.", http.StatusServiceUnavailable)
+		return
+	}
 	h.tmpl.ExecuteTemplate(w, "setup.html", nil)
 }
 
 func (h *SetupHandler) SetupSubmit(w http.ResponseWriter, r *http.Request) {
-	hasUsers, _ := h.db.HasUsers()
-	if hasUsers {
+	hasUsers, err := h.db.HasUsers(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "checking for users failed", "err", err)
+	}
+	if hasUsers || h.disabled {
 		http.NotFound(w, r)
 		return
 	}
 
 	_ = r.ParseForm()
+	token := r.FormValue("setup_token")
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 	confirm := r.FormValue("confirm_password")
 
-	if username == "" {
-		h.renderError(w, "Username is required")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		slog.WarnContext(r.Context(), "rejected setup attempt with invalid token", "ip", util.GetClientIP(r))
+		h.renderError(w, "Invalid setup token. Check the server log or NS116_SETUP_TOKEN.")
 		return
 	}
-	if len(password) < 6 {
-		h.renderError(w, "Password must be at least 6 characters")
+	if username == "" {
+		h.renderError(w, "Username is required")
 		return
 	}
 	if password != confirm {
 		h.renderError(w, "Passwords do not match")
 		return
 	}
+	if err := auth.ValidatePassword(h.policy, password); err != nil {
+		h.renderError(w, err.Error())
+		return
+	}
 
-	if err := h.db.CreateUser(username, password, "admin"); err != nil {
+	created, err := h.db.CreateFirstAdmin(r.Context(), username, password)
+	if err != nil {
 		h.renderError(w, "Failed to create user: "+err.Error())
 		return
 	}
+	if !created {
+		http.NotFound(w, r)
+		return
+	}
+	slog.InfoContext(r.Context(), "initial admin created via web setup", "user", username, "ip", util.GetClientIP(r))
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
@@ -64,7 +95,12 @@ func (h *SetupHandler) renderError(w http.ResponseWriter, msg string) {
 
 func RequireSetupComplete(db *database.DB, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		hasUsers, _ := db.HasUsers()
+		hasUsers, err := db.HasUsers(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "checking for users failed", "err", err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
 		if !hasUsers {
 			http.Redirect(w, r, "/setup", http.StatusSeeOther)
 			return
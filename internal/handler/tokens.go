@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ns116/internal/auth"
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+// tokenLifetimes are the expiry choices offered for new API tokens, in
+// days; 0 means the token does not expire.
+var tokenLifetimes = []int{30, 90, 365, 0}
+
+type TokenHandler struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	tmpl       *template.Template
+}
+
+func NewTokenHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template) *TokenHandler {
+	return &TokenHandler{db: db, sessionMgr: sm, tmpl: tmpl}
+}
+
+func (h *TokenHandler) Page(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, "")
+}
+
+// Create issues a token for the current user. The secret is only ever
+// shown in this response, so the page is rendered directly instead of
+// redirecting.
+func (h *TokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	username, _ := h.sessionMgr.GetUsername(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+	if user == nil {
+		redirectTokens(w, r, "error", "User not found")
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		redirectTokens(w, r, "error", "Name is required")
+		return
+	}
+	days, err := strconv.Atoi(r.FormValue("expires_days"))
+	if err != nil || days < 0 {
+		redirectTokens(w, r, "error", "Invalid expiry")
+		return
+	}
+	var expiresAt *time.Time
+	if days > 0 {
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	secret, hash, prefix := auth.NewAPIToken()
+	_, err = h.db.CreateAPIToken(r.Context(), user.ID, name, hash, prefix, expiresAt)
+	entry := auditEntry(r, h.sessionMgr, "create_token")
+	entry.Detail = fmt.Sprintf("name=%q prefix=%s expires_days=%d", name, prefix, days)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectTokens(w, r, "error", "Failed to create token: "+err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.render(w, r, secret)
+}
+
+func (h *TokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	username, _ := h.sessionMgr.GetUsername(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if user == nil || err != nil {
+		redirectTokens(w, r, "error", "Token not found")
+		return
+	}
+
+	tokens, err := h.db.ListAPITokens(r.Context(), user.ID)
+	if err != nil {
+		redirectTokens(w, r, "error", "Failed to load tokens: "+err.Error())
+		return
+	}
+	i := slices.IndexFunc(tokens, func(t model.APIToken) bool { return t.ID == id })
+	if i < 0 {
+		redirectTokens(w, r, "error", "Token not found")
+		return
+	}
+
+	err = h.db.DeleteAPIToken(r.Context(), user.ID, id)
+	entry := auditEntry(r, h.sessionMgr, "revoke_token")
+	entry.Detail = fmt.Sprintf("name=%q prefix=%s", tokens[i].Name, tokens[i].Prefix)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectTokens(w, r, "error", "Failed to revoke token: "+err.Error())
+		return
+	}
+	redirectTokens(w, r, "msg", "Token revoked")
+}
+
+// render shows the user's tokens; newToken is the secret of a token that
+// was just created, or "".
+func (h *TokenHandler) render(w http.ResponseWriter, r *http.Request, newToken string) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	data := map[string]interface{}{
+		"Title":     "API Tokens",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Lifetimes": tokenLifetimes,
+		"NewToken":  newToken,
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	}
+	if user != nil {
+		tokens, err := h.db.ListAPITokens(r.Context(), user.ID)
+		if err != nil {
+			data["Error"] = "Failed to load tokens: " + err.Error()
+		}
+		data["Tokens"] = tokens
+	}
+	h.tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+func redirectTokens(w http.ResponseWriter, r *http.Request, key, msg string) {
+	http.Redirect(w, r, "/account/tokens?"+key+"="+url.QueryEscape(msg), http.StatusSeeOther)
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ns116/internal/auth"
+	"ns116/internal/database"
+	"ns116/internal/model"
+	"ns116/internal/webhook"
+)
+
+type WebhookHandler struct {
+	db         *database.DB
+	sessionMgr *auth.SessionManager
+	tmpl       *template.Template
+}
+
+func NewWebhookHandler(db *database.DB, sm *auth.SessionManager, tmpl *template.Template) *WebhookHandler {
+	return &WebhookHandler{db: db, sessionMgr: sm, tmpl: tmpl}
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	data := map[string]interface{}{
+		"Title":     "Webhooks",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Events":    webhook.Events,
+		"Formats":   webhook.Formats,
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	}
+	subs, err := h.db.ListWebhooks(r.Context())
+	if err != nil {
+		data["Error"] = "Failed to load webhooks: " + err.Error()
+	}
+	data["Webhooks"] = subs
+
+	h.tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	username, _ := h.sessionMgr.GetUsername(r)
+
+	sub := model.WebhookSubscription{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		URL:       strings.TrimSpace(r.FormValue("url")),
+		Format:    r.FormValue("format"),
+		Active:    true,
+		CreatedBy: username,
+	}
+	if sub.Name == "" {
+		redirectWebhooks(w, r, "error", "Name is required")
+		return
+	}
+	if u, err := url.Parse(sub.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		redirectWebhooks(w, r, "error", "URL must be an absolute http(s) URL")
+		return
+	}
+	if !slices.Contains(webhook.Formats, sub.Format) {
+		redirectWebhooks(w, r, "error", "Unknown format")
+		return
+	}
+	for _, ev := range r.Form["events"] {
+		if slices.Contains(webhook.Events, ev) {
+			sub.Events = append(sub.Events, ev)
+		}
+	}
+	for _, z := range strings.FieldsFunc(r.FormValue("zones"), func(c rune) bool { return c == ',' || c == ' ' }) {
+		sub.ZoneIDs = append(sub.ZoneIDs, strings.TrimPrefix(z, "/hostedzone/"))
+	}
+
+	var err error
+	if sub.Secret, err = webhook.NewSecret(); err != nil {
+		redirectWebhooks(w, r, "error", "Failed to generate secret: "+err.Error())
+		return
+	}
+	sub.ID, err = h.db.CreateWebhook(r.Context(), sub)
+
+	entry := auditEntry(r, h.sessionMgr, "create_webhook")
+	entry.Detail = fmt.Sprintf("webhook=%s url=%s format=%s", sub.Name, sub.URL, sub.Format)
+	entry.After = model.AuditState(newWebhookState(&sub))
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectWebhooks(w, r, "error", "Failed to create webhook: "+err.Error())
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d?msg=%s", sub.ID,
+		url.QueryEscape("Webhook created. Copy the signing secret below.")), http.StatusSeeOther)
+}
+
+func (h *WebhookHandler) SetActive(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+	active := r.FormValue("active") == "1"
+
+	err := h.db.SetWebhookActive(r.Context(), sub.ID, active)
+	entry := auditEntry(r, h.sessionMgr, "update_webhook")
+	entry.Detail = fmt.Sprintf("webhook=%s active: %t -> %t", sub.Name, sub.Active, active)
+	entry.Before = model.AuditState(newWebhookState(sub))
+	after := newWebhookState(sub)
+	after.Active = active
+	entry.After = model.AuditState(after)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectWebhooks(w, r, "error", "Failed to update webhook: "+err.Error())
+		return
+	}
+	state := "paused"
+	if active {
+		state = "resumed"
+	}
+	redirectWebhooks(w, r, "msg", fmt.Sprintf("Webhook '%s' %s", sub.Name, state))
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.db.DeleteWebhook(r.Context(), sub.ID)
+	entry := auditEntry(r, h.sessionMgr, "delete_webhook")
+	entry.Detail = fmt.Sprintf("webhook=%s url=%s", sub.Name, sub.URL)
+	entry.Before = model.AuditState(newWebhookState(sub))
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectWebhooks(w, r, "error", "Failed to delete webhook: "+err.Error())
+		return
+	}
+	redirectWebhooks(w, r, "msg", fmt.Sprintf("Webhook '%s' deleted", sub.Name))
+}
+
+// Ping queues a test event for the subscription, bypassing its filters.
+func (h *WebhookHandler) Ping(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+	username, _ := h.sessionMgr.GetUsername(r)
+
+	_, err := h.db.EnqueueWebhookDelivery(r.Context(), sub.ID, model.WebhookEvent{
+		Event:      "ping",
+		OccurredAt: time.Now().UTC(),
+		Actor:      username,
+		Detail:     "test delivery",
+	})
+	if err != nil {
+		redirectDeliveries(w, r, sub.ID, "error", "Failed to queue ping: "+err.Error())
+		return
+	}
+	msg := "Ping queued"
+	if !sub.Active {
+		msg += "; it will be sent when the webhook is resumed"
+	}
+	redirectDeliveries(w, r, sub.ID, "msg", msg)
+}
+
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	data := map[string]interface{}{
+		"Title":     "Webhook " + sub.Name,
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Webhook":   sub,
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	}
+	deliveries, err := h.db.ListWebhookDeliveries(r.Context(), sub.ID, 100)
+	if err != nil {
+		data["Error"] = "Failed to load deliveries: " + err.Error()
+	}
+	data["Deliveries"] = deliveries
+
+	h.tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+// Replay queues a copy of a past delivery with its original payload.
+func (h *WebhookHandler) Replay(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, _ := strconv.ParseInt(r.FormValue("delivery_id"), 10, 64)
+
+	newID, subID, err := h.db.ReplayWebhookDelivery(r.Context(), deliveryID)
+	if err == nil && subID != sub.ID {
+		err = fmt.Errorf("delivery %d does not belong to this webhook", deliveryID)
+	}
+	entry := auditEntry(r, h.sessionMgr, "replay_webhook")
+	entry.Detail = fmt.Sprintf("webhook=%s delivery=%d new_delivery=%d", sub.Name, deliveryID, newID)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectDeliveries(w, r, sub.ID, "error", "Replay failed: "+err.Error())
+		return
+	}
+	redirectDeliveries(w, r, sub.ID, "msg", fmt.Sprintf("Delivery #%d queued again as #%d", deliveryID, newID))
+}
+
+// subscription loads the webhook named by the {id} path value, redirecting
+// to the list when it does not exist.
+func (h *WebhookHandler) subscription(w http.ResponseWriter, r *http.Request) (*model.WebhookSubscription, bool) {
+	_ = r.ParseForm()
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	sub, err := h.db.GetWebhook(r.Context(), id)
+	if err != nil || sub == nil {
+		redirectWebhooks(w, r, "error", "Webhook not found")
+		return nil, false
+	}
+	return sub, true
+}
+
+// webhookState is the audited view of a subscription; the secret is left out.
+type webhookState struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Format  string   `json:"format"`
+	Events  []string `json:"events,omitempty"`
+	ZoneIDs []string `json:"zone_ids,omitempty"`
+	Active  bool     `json:"active"`
+}
+
+func newWebhookState(s *model.WebhookSubscription) webhookState {
+	return webhookState{Name: s.Name, URL: s.URL, Format: s.Format, Events: s.Events, ZoneIDs: s.ZoneIDs, Active: s.Active}
+}
+
+func redirectWebhooks(w http.ResponseWriter, r *http.Request, key, msg string) {
+	http.Redirect(w, r, "/admin/webhooks?"+key+"="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+func redirectDeliveries(w http.ResponseWriter, r *http.Request, id int, key, msg string) {
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d?%s=%s", id, key, url.QueryEscape(msg)), http.StatusSeeOther)
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"html/template"
+	"log/slog"
 	"net/http"
 
 	"ns116/internal/auth"
@@ -23,7 +24,7 @@ func NewZoneHandler(r53 *service.DNSService, sm *auth.SessionManager, db *databa
 
 func (h *ZoneHandler) List(w http.ResponseWriter, r *http.Request) {
 	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
-	user, _ := h.db.GetUserByUsername(username)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
 
 	zones, err := h.r53.ListZones(r.Context())
 	if err != nil {
@@ -54,6 +55,8 @@ func roleOf(u *model.User) string {
 }
 
 func (h *ZoneHandler) RefreshZones(w http.ResponseWriter, r *http.Request) {
-	h.db.InvalidateAllCache()
+	if err := h.db.InvalidateAllCache(r.Context()); err != nil {
+		slog.ErrorContext(r.Context(), "invalidating zone cache failed", "err", err)
+	}
 	http.Redirect(w, r, "/zones", http.StatusSeeOther)
 }
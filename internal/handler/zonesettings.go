@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"ns116/internal/auth"
+	"ns116/internal/database"
+	"ns116/internal/model"
+	"ns116/internal/service"
+)
+
+// ZoneSettingsHandler lets admins choose which of the zones visible to the
+// AWS credentials NS116 manages, and keep labels, descriptions, owners and
+// tags for them. Zones listed in hosted_zones stay enabled and keep their
+// configured label.
+type ZoneSettingsHandler struct {
+	r53        *service.DNSService
+	sessionMgr *auth.SessionManager
+	db         *database.DB
+	tmpl       *template.Template
+}
+
+func NewZoneSettingsHandler(r53 *service.DNSService, sm *auth.SessionManager, db *database.DB, tmpl *template.Template) *ZoneSettingsHandler {
+	return &ZoneSettingsHandler{r53: r53, sessionMgr: sm, db: db, tmpl: tmpl}
+}
+
+func (h *ZoneSettingsHandler) List(w http.ResponseWriter, r *http.Request) {
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	data := map[string]interface{}{
+		"Title":     "Zones",
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	}
+	zones, err := h.r53.DiscoverZones(r.Context())
+	if err != nil {
+		data["Error"] = "Failed to load zones: " + err.Error()
+	}
+	enabled := 0
+	for _, z := range zones {
+		if z.Enabled {
+			enabled++
+		}
+	}
+	data["Zones"] = zones
+	data["EnabledCount"] = enabled
+
+	h.tmpl.ExecuteTemplate(w, "layout", data)
+}
+
+func (h *ZoneSettingsHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	username, csrfToken, _ := h.sessionMgr.GetSessionInfo(r)
+	user, _ := h.db.GetUserByUsername(r.Context(), username)
+
+	h.tmpl.ExecuteTemplate(w, "layout", map[string]interface{}{
+		"Title":     "Zone " + zone.Name,
+		"Username":  username,
+		"CSRFToken": csrfToken,
+		"Role":      roleOf(user),
+		"Zone":      zone,
+		"Flash":     r.URL.Query().Get("msg"),
+		"Error":     r.URL.Query().Get("error"),
+	})
+}
+
+// Save stores the settings submitted from the edit form. The enabled flag
+// and label of zones that hosted_zones sets are left as they were.
+func (h *ZoneSettingsHandler) Save(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	current, err := h.db.GetManagedZone(r.Context(), zone.ID)
+	if err != nil {
+		redirectZoneSettings(w, r, "error", "Failed to load zone settings: "+err.Error())
+		return
+	}
+
+	next := model.ManagedZone{
+		ZoneID:      zone.ID,
+		Name:        zone.Name,
+		Enabled:     r.FormValue("enabled") == "1",
+		Label:       strings.TrimSpace(r.FormValue("label")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		Owner:       strings.TrimSpace(r.FormValue("owner")),
+		Tags:        parseTags(r.FormValue("tags")),
+	}
+	if zone.InConfig {
+		next.Enabled = current != nil && current.Enabled
+	}
+	if zone.LabelInConfig {
+		next.Label = ""
+		if current != nil {
+			next.Label = current.Label
+		}
+	}
+	h.save(w, r, zone, current, next, fmt.Sprintf("Settings of %s saved", zone.Name))
+}
+
+// SetEnabled adds the zone to the allowlist or removes it.
+func (h *ZoneSettingsHandler) SetEnabled(w http.ResponseWriter, r *http.Request) {
+	zone, ok := h.zone(w, r)
+	if !ok {
+		return
+	}
+	if zone.InConfig {
+		redirectZoneSettings(w, r, "error", zone.Name+" is listed in hosted_zones and always enabled")
+		return
+	}
+	current, err := h.db.GetManagedZone(r.Context(), zone.ID)
+	if err != nil {
+		redirectZoneSettings(w, r, "error", "Failed to load zone settings: "+err.Error())
+		return
+	}
+
+	next := model.ManagedZone{ZoneID: zone.ID, Name: zone.Name}
+	if current != nil {
+		next = *current
+		next.Name = zone.Name
+	}
+	next.Enabled = r.FormValue("enabled") == "1"
+	state := "disabled"
+	if next.Enabled {
+		state = "enabled"
+	}
+	h.save(w, r, zone, current, next, fmt.Sprintf("Zone %s %s", zone.Name, state))
+}
+
+func (h *ZoneSettingsHandler) save(w http.ResponseWriter, r *http.Request, zone *model.ZoneSettings, current *model.ManagedZone, next model.ManagedZone, msg string) {
+	username, _ := h.sessionMgr.GetUsername(r)
+	next.UpdatedBy = username
+
+	err := h.db.SaveManagedZone(r.Context(), next)
+	h.r53.InvalidateManagedZones()
+
+	entry := auditEntry(r, h.sessionMgr, "update_zone")
+	entry.ZoneID, entry.ZoneName = zone.ID, zone.Name
+	entry.Detail = fmt.Sprintf("enabled=%t", next.Enabled)
+	if current != nil {
+		entry.Detail = fmt.Sprintf("enabled: %t -> %t", current.Enabled, next.Enabled)
+		entry.Before = model.AuditState(current)
+	}
+	entry.After = model.AuditState(next)
+	logAudit(r, h.db, withOutcome(entry, err))
+
+	if err != nil {
+		redirectZoneSettings(w, r, "error", "Failed to save zone settings: "+err.Error())
+		return
+	}
+	redirectZoneSettings(w, r, "msg", msg)
+}
+
+// zone looks up the zone named by the {id} path value among the zones the
+// AWS credentials can see, redirecting to the list when there is none.
+func (h *ZoneSettingsHandler) zone(w http.ResponseWriter, r *http.Request) (*model.ZoneSettings, bool) {
+	_ = r.ParseForm()
+	zones, err := h.r53.DiscoverZones(r.Context())
+	if err != nil {
+		redirectZoneSettings(w, r, "error", "Failed to load zones: "+err.Error())
+		return nil, false
+	}
+	id := r.PathValue("id")
+	for i := range zones {
+		if zones[i].ID == id {
+			return &zones[i], true
+		}
+	}
+	redirectZoneSettings(w, r, "error", "Zone not found")
+	return nil, false
+}
+
+// parseTags splits a comma-separated tag list, dropping blanks and
+// duplicates.
+func parseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func redirectZoneSettings(w http.ResponseWriter, r *http.Request, key, msg string) {
+	http.Redirect(w, r, "/admin/zones?"+key+"="+url.QueryEscape(msg), http.StatusSeeOther)
+}
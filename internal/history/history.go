@@ -0,0 +1,393 @@
+// Package history keeps a Git repository with a zone file per hosted zone
+// and commits to it after every change made through NS116, authored by the
+// user who made it, with the comment of its Route53 change batch as the
+// message. It gives git log and git blame over DNS and an archive that does
+// not depend on the database.
+//
+// The repository is fed from the audit outbox like an audit sink, so
+// changes are committed in order and none are lost while Git or Route53 is
+// unavailable. It runs the git command, which must be installed.
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+	"ns116/internal/service"
+	"ns116/internal/zonesync"
+)
+
+const (
+	remoteName     = "origin"
+	committerName  = "NS116"
+	pushInterval   = 10 * time.Second
+	closeTimeout   = 30 * time.Second
+	headerTemplate = "# Hosted zone %s (%s)\n# Maintained by NS116: every change made through NS116 is committed here.\n"
+)
+
+// headerRE reads the zone ID back from the header of a zone file.
+var headerRE = regexp.MustCompile(`^# Hosted zone \S+ \((\S+)\)`)
+
+// Repo is the zone history. It implements audit.Sink.
+type Repo struct {
+	dir    string
+	remote string
+	branch string
+	domain string
+	db     *database.DB
+	r53    *service.DNSService
+
+	mu    sync.Mutex
+	zones map[string]model.HostedZone // by ID; names never change
+	// lastChange was committed as lastCommit. Later entries of the same
+	// change batch are folded into that commit until it is pushed.
+	lastChange string
+	lastCommit string
+	pushed     string // the commit pushed last
+}
+
+// New opens the repository in cfg.Dir, creating it if needed. A new
+// repository starts from the branch on the remote, if there is one.
+func New(ctx context.Context, cfg config.HistoryConfig, db *database.DB, r53 *service.DNSService) (*Repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("zone history: %w", err)
+	}
+	r := &Repo{
+		dir:    cfg.Dir,
+		remote: cfg.Remote,
+		branch: cfg.Branch,
+		domain: cfg.AuthorDomain,
+		db:     db,
+		r53:    r53,
+		zones:  make(map[string]model.HostedZone),
+	}
+	if err := r.init(ctx); err != nil {
+		return nil, fmt.Errorf("zone history in %s: %w", r.dir, err)
+	}
+	return r, nil
+}
+
+func (r *Repo) init(ctx context.Context) error {
+	if err := os.MkdirAll(r.dir, 0o750); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if _, err := r.git(ctx, nil, "init", "--quiet"); err != nil {
+			return err
+		}
+		if _, err := r.git(ctx, nil, "symbolic-ref", "HEAD", "refs/heads/"+r.branch); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	branch, err := r.git(ctx, nil, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+	if branch != r.branch {
+		return fmt.Errorf("branch %s is checked out, not %s", branch, r.branch)
+	}
+	if r.remote == "" {
+		return nil
+	}
+
+	if current, err := r.git(ctx, nil, "remote", "get-url", remoteName); err != nil {
+		if _, err := r.git(ctx, nil, "remote", "add", remoteName, r.remote); err != nil {
+			return err
+		}
+	} else if current != r.remote {
+		if _, err := r.git(ctx, nil, "remote", "set-url", remoteName, r.remote); err != nil {
+			return err
+		}
+	}
+	if _, err := r.git(ctx, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		return nil
+	}
+	// A repository without commits continues the history already pushed,
+	// e.g. after a container lost its volume
+	_, err = r.git(ctx, nil, "ls-remote", "--exit-code", remoteName, "refs/heads/"+r.branch)
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return nil // nothing pushed yet
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := r.git(ctx, nil, "fetch", "--quiet", remoteName, r.branch); err != nil {
+		return err
+	}
+	_, err = r.git(ctx, nil, "reset", "--quiet", "--hard", "FETCH_HEAD")
+	return err
+}
+
+func (r *Repo) Name() string { return config.HistorySinkName }
+
+// Send commits a successful record change to the zone file of its zone.
+// Other audit entries are ignored. The first change to a zone writes the
+// whole zone as read from Route53.
+func (r *Repo) Send(ctx context.Context, e model.AuditEntry) error {
+	if e.Status != model.AuditSuccess || e.ChangeID == "" || e.ZoneID == "" {
+		return nil
+	}
+	before, err := record(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := record(e.After)
+	if err != nil || (before == nil && after == nil) {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zone, err := r.zone(ctx, e.ZoneID)
+	if errors.Is(err, service.ErrZoneNotAllowed) {
+		slog.WarnContext(ctx, "zone history: zone is no longer managed; change not recorded",
+			"zone_id", e.ZoneID, "change_id", e.ChangeID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	path, err := r.zoneFile(zone)
+	if err != nil {
+		return err
+	}
+	records, err := r.records(ctx, zone, path)
+	if err != nil {
+		return err
+	}
+	data, err := zonesync.Marshal(zone, zonesync.Replace(records, before, after))
+	if err != nil {
+		return err
+	}
+	data = append(fmt.Appendf(nil, headerTemplate, zone.Name, zone.ID), data...)
+	if err := os.WriteFile(filepath.Join(r.dir, path), data, 0o640); err != nil {
+		return err
+	}
+
+	if _, err := r.git(ctx, nil, "add", "--", path); err != nil {
+		return err
+	}
+	status, err := r.git(ctx, nil, "status", "--porcelain", "--", path)
+	if err != nil || status == "" {
+		return err // already committed, e.g. when an entry is retried
+	}
+	return r.commit(ctx, e, zone)
+}
+
+// commit records the staged zone file for the change of e.
+func (r *Repo) commit(ctx context.Context, e model.AuditEntry, zone model.HostedZone) error {
+	author, err := r.author(ctx, e.Username)
+	if err != nil {
+		return err
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=" + e.Username,
+		"GIT_AUTHOR_EMAIL=" + author,
+		"GIT_AUTHOR_DATE=" + e.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	return r.commitChange(ctx, e.ChangeID, env, func() (string, error) { return r.message(ctx, e, zone) })
+}
+
+// commitChange commits what is staged for the change batch changeID. It
+// amends the last commit if that is for the same change batch and not
+// pushed yet; only a new commit asks message for its message.
+func (r *Repo) commitChange(ctx context.Context, changeID string, env []string, message func() (string, error)) error {
+	args := []string{"commit", "--quiet", "--no-verify"}
+	if changeID == r.lastChange && r.lastCommit != r.pushed {
+		args = append(args, "--amend", "--no-edit")
+	} else {
+		msg, err := message()
+		if err != nil {
+			return err
+		}
+		args = append(args, "-m", msg)
+	}
+	if _, err := r.git(ctx, env, args...); err != nil {
+		return err
+	}
+	head, err := r.git(ctx, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	r.lastChange, r.lastCommit = changeID, head
+	return nil
+}
+
+// message is the comment of the change batch with the zone and change ID
+// as trailers.
+func (r *Repo) message(ctx context.Context, e model.AuditEntry, zone model.HostedZone) (string, error) {
+	info, err := r.r53.GetChange(ctx, e.ChangeID)
+	var noChange *types.NoSuchChange
+	if errors.As(err, &noChange) {
+		// Route53 has forgotten the change; describe it from the entry
+		verb := map[string]string{"create_record": "Create", "edit_record": "Update", "delete_record": "Delete"}[e.Action]
+		info.Comment = strings.TrimSpace(verb + " " + e.RecordName + " " + e.RecordType)
+	} else if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\n\nZone: %s (%s)\nRoute53-Change-Id: %s\n", info.Comment, zone.Name, zone.ID, e.ChangeID), nil
+}
+
+// author returns the address commits by username are authored with: the
+// user's email address, or one made up from the username.
+func (r *Repo) author(ctx context.Context, username string) (string, error) {
+	u, err := r.db.GetUserByUsername(ctx, username)
+	if err != nil {
+		return "", err
+	}
+	if u != nil && u.Email != "" {
+		return u.Email, nil
+	}
+	return username + "@" + r.domain, nil
+}
+
+func (r *Repo) zone(ctx context.Context, zoneID string) (model.HostedZone, error) {
+	if z, ok := r.zones[zoneID]; ok {
+		return z, nil
+	}
+	z, err := r.r53.GetZone(ctx, zoneID)
+	if err != nil {
+		return z, err
+	}
+	r.zones[zoneID] = z
+	return z, nil
+}
+
+// zoneFile returns the path of the zone's file within the repository: the
+// zone name with .yaml, as ns116 apply expects, or with the zone ID added
+// if another zone of the same name, e.g. a private one, has that file.
+func (r *Repo) zoneFile(zone model.HostedZone) (string, error) {
+	base := strings.TrimSuffix(zone.Name, ".")
+	path := base + ".yaml"
+	data, err := os.ReadFile(filepath.Join(r.dir, path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return path, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if m := headerRE.FindSubmatch(data); m != nil && string(m[1]) != zone.ID {
+		return base + "." + zone.ID + ".yaml", nil
+	}
+	return path, nil
+}
+
+// records reads the zone's record sets from its file, or from Route53 if
+// there is no file yet.
+func (r *Repo) records(ctx context.Context, zone model.HostedZone, path string) ([]model.DNSRecord, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, path))
+	if errors.Is(err, fs.ErrNotExist) {
+		r.r53.InvalidateRecords(ctx, zone.ID)
+		return r.r53.ListRecords(ctx, zone.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	records, err := zonesync.Parse(data, zone.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return records, nil
+}
+
+// Run pushes new commits to the remote every few seconds until ctx is
+// cancelled. Close makes a last push.
+func (r *Repo) Run(ctx context.Context) {
+	if r.remote == "" {
+		return
+	}
+	ticker := time.NewTicker(pushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.push(ctx); err != nil {
+				slog.WarnContext(ctx, "zone history: push failed", "err", err)
+			}
+		}
+	}
+}
+
+func (r *Repo) push(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	head, err := r.git(ctx, nil, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil || head == r.pushed {
+		return nil // nothing committed yet, or nothing new
+	}
+	if _, err := r.git(ctx, nil, "push", "--quiet", remoteName, "HEAD:refs/heads/"+r.branch); err != nil {
+		return err
+	}
+	r.pushed = head
+	return nil
+}
+
+func (r *Repo) Close() error {
+	if r.remote == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return r.push(ctx)
+}
+
+// git runs a git command in the repository with the extra environment env
+// and returns its output.
+func (r *Repo) git(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_COMMITTER_NAME="+committerName,
+		"GIT_COMMITTER_EMAIL=ns116@"+r.domain)
+	cmd.Env = append(cmd.Env, env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, r.redact(strings.TrimSpace(stderr.String())))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// redact hides credentials in the remote URL from messages.
+func (r *Repo) redact(msg string) string {
+	if u, err := url.Parse(r.remote); err == nil && u.User != nil {
+		return strings.ReplaceAll(msg, r.remote, u.Redacted())
+	}
+	return msg
+}
+
+func record(state json.RawMessage) (*model.DNSRecord, error) {
+	if len(state) == 0 || string(state) == "null" {
+		return nil, nil
+	}
+	var rec model.DNSRecord
+	if err := json.Unmarshal(state, &rec); err != nil {
+		return nil, fmt.Errorf("reading record state: %w", err)
+	}
+	return &rec, nil
+}
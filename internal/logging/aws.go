@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// AWSMiddleware logs every Route53 operation with the request ID of the
+// calling context and the AWS request ID, at debug level on success and
+// warn level on failure. Add it to the client's APIOptions.
+func AWSMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NS116Logging",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleInitialize(ctx, in)
+
+			attrs := []slog.Attr{
+				slog.String("operation", awsmiddleware.GetOperationName(ctx)),
+				slog.Float64("duration_ms", millis(time.Since(start))),
+			}
+			if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+				attrs = append(attrs, slog.String("aws_request_id", id))
+			}
+			if err != nil {
+				slog.LogAttrs(ctx, slog.LevelWarn, "route53 call failed", append(attrs, slog.Any("err", err))...)
+			} else {
+				slog.LogAttrs(ctx, slog.LevelDebug, "route53 call", attrs...)
+			}
+			return out, md, err
+		}), middleware.After)
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"ns116/internal/util"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware assigns every request an ID, echoes it in the response headers,
+// stores it in the request context and writes an access log line once the
+// response is complete. An ID set by an upstream proxy is kept when it looks
+// sane so logs can be joined across both.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r, matched := util.WithRoute(r.WithContext(WithRequestID(r.Context(), id)))
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ns116.request_id", id))
+
+		rec := &util.ResponseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		switch {
+		case rec.Status() >= 500:
+			level = slog.LevelError
+		case strings.HasPrefix(r.URL.Path, "/static/"), r.URL.Path == "/healthz", r.URL.Path == "/readyz":
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(r.Context(), level, "http request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", matched()),
+			slog.Int("status", rec.Status()),
+			slog.Int64("bytes", rec.Bytes),
+			slog.Float64("duration_ms", millis(time.Since(start))),
+			slog.String("ip", util.GetClientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts up to 64 characters from [A-Za-z0-9._-], so a
+// client-supplied ID cannot inject anything into logs or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,95 @@
+// Package logging configures the structured application log and carries the
+// per-request ID through contexts so every line written while serving a
+// request can be correlated.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"ns116/internal/config"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// level is the level of the logger installed by Setup, changed by SetLevel.
+var level slog.LevelVar
+
+// Setup installs the configured logger as the slog default, which also
+// routes the standard log package through it, and returns it.
+func Setup(cfg config.LogConfig) *slog.Logger {
+	level.Set(parseLevel(cfg.Level))
+	logger := newLogger(cfg, os.Stderr, &level)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// SetLevel changes the level of the logger installed by Setup.
+func SetLevel(s string) {
+	level.Set(parseLevel(s))
+}
+
+// New builds a logger writing to w. Records logged with a context that
+// carries a request ID or a trace get request_id, trace_id and span_id
+// attributes.
+func New(cfg config.LogConfig, w io.Writer) *slog.Logger {
+	return newLogger(cfg, w, parseLevel(cfg.Level))
+}
+
+func newLogger(cfg config.LogConfig, w io.Writer, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+func parseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// contextHandler adds the request ID and the trace and span IDs from the
+// record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
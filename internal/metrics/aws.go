@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var route53Throttles = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ns116_route53_throttled_attempts_total",
+	Help: "Individual Route53 API attempts rejected with a throttling error, before retries.",
+}, []string{"operation"})
+
+func init() {
+	Registry.MustRegister(route53Throttles)
+}
+
+// throttleCodes are the error codes Route53 returns when rate limiting.
+var throttleCodes = map[string]bool{
+	"Throttling":              true,
+	"ThrottlingException":     true,
+	"PriorRequestNotComplete": true,
+}
+
+// AWSMiddleware records Route53 operation metrics. Add it to the client's
+// APIOptions: the operation as a whole (including retries) is measured in the
+// initialize step and every throttled attempt in the finalize step.
+func AWSMiddleware(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NS116Metrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleInitialize(ctx, in)
+
+			op := awsmiddleware.GetOperationName(ctx)
+			route53Duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+			route53Requests.WithLabelValues(op, awsResult(err)).Inc()
+			return out, md, err
+		}), middleware.After)
+	if err != nil {
+		return err
+	}
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("NS116ThrottleMetrics",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, md, err := next.HandleFinalize(ctx, in)
+			if isThrottle(err) {
+				route53Throttles.WithLabelValues(awsmiddleware.GetOperationName(ctx)).Inc()
+			}
+			return out, md, err
+		}), middleware.After)
+}
+
+func awsResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case isThrottle(err):
+		return "throttled"
+	default:
+		return "error"
+	}
+}
+
+func isThrottle(err error) bool {
+	var ae smithy.APIError
+	return errors.As(err, &ae) && throttleCodes[ae.ErrorCode()]
+}
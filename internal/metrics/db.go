@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DBSource is the database as seen by the collector.
+type DBSource interface {
+	Stats() sql.DBStats
+	CountActiveSessions(ctx context.Context) (int, error)
+}
+
+var (
+	dbOpenDesc      = prometheus.NewDesc("ns116_db_connections_open", "Open database connections.", nil, nil)
+	dbInUseDesc     = prometheus.NewDesc("ns116_db_connections_in_use", "Database connections currently in use.", nil, nil)
+	dbIdleDesc      = prometheus.NewDesc("ns116_db_connections_idle", "Idle database connections.", nil, nil)
+	dbMaxOpenDesc   = prometheus.NewDesc("ns116_db_connections_max_open", "Maximum open database connections (0 = unlimited).", nil, nil)
+	dbWaitCountDesc = prometheus.NewDesc("ns116_db_wait_total", "Times a query waited for a free connection.", nil, nil)
+	dbWaitDesc      = prometheus.NewDesc("ns116_db_wait_seconds_total", "Total time spent waiting for a free connection.", nil, nil)
+	sessionsDesc    = prometheus.NewDesc("ns116_sessions_active", "Unexpired user sessions.", nil, nil)
+)
+
+type dbCollector struct{ db DBSource }
+
+// RegisterDB exports connection pool statistics and the active session
+// count of db. Both are read at scrape time.
+func RegisterDB(db DBSource) {
+	Registry.MustRegister(dbCollector{db: db})
+}
+
+func (c dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{dbOpenDesc, dbInUseDesc, dbIdleDesc, dbMaxOpenDesc, dbWaitCountDesc, dbWaitDesc, sessionsDesc} {
+		ch <- d
+	}
+}
+
+func (c dbCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(dbOpenDesc, prometheus.GaugeValue, float64(s.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUseDesc, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdleDesc, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDesc, prometheus.CounterValue, s.WaitDuration.Seconds())
+
+	// A failed count is left out of the scrape rather than reported as 0
+	if n, err := c.db.CountActiveSessions(context.Background()); err == nil {
+		ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(n))
+	}
+}
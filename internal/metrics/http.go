@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"ns116/internal/util"
+)
+
+// Middleware counts and times requests. Requests are labelled with the
+// ServeMux pattern that matched (e.g. "GET /zones/{zoneID}/records") so
+// zone IDs and other path values do not create new series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, matched := util.WithRoute(r)
+		rec := &util.ResponseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := matched()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := methodLabel(r.Method)
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(rec.Status())).Inc()
+		httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel returns the method for the method label, or "other" for
+// anything but the standard methods, which clients could otherwise use to
+// create new series at will.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// Handler serves the registry. When token or username is set, scrapes must
+// authenticate with a bearer token or HTTP basic auth respectively.
+func Handler(token, username, password string) http.Handler {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" && username == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token, username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ns116 metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func authorized(r *http.Request, token, username, password string) bool {
+	if token != "" && equal(r.Header.Get("Authorization"), "Bearer "+token) {
+		return true
+	}
+	if username != "" {
+		u, p, ok := r.BasicAuth()
+		return ok && equal(u, username) && equal(p, password)
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
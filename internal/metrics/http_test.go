@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareMethodLabel(t *testing.T) {
+	h := Middleware(http.NotFoundHandler())
+	for _, method := range []string{"GET", "PROPFIND", "X-RANDOM-1", "X-RANDOM-2", "get"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/nowhere", nil))
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", "GET", "404")); got != 1 {
+		t.Errorf("GET requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", "other", "404")); got != 4 {
+		t.Errorf("other requests = %v, want 4", got)
+	}
+	// Only the two label values above exist
+	if n := testutil.CollectAndCount(httpDuration); n != 2 {
+		t.Errorf("%d duration series, want 2", n)
+	}
+}
@@ -0,0 +1,70 @@
+// Package metrics defines the Prometheus metrics NS116 exports on /metrics
+// and the helpers that record them.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry holds every NS116 metric plus the Go runtime and process
+// collectors. A private registry keeps metrics of imported libraries out.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ns116_http_requests_total",
+		Help: "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ns116_http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	route53Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ns116_route53_requests_total",
+		Help: "Route53 API operations by outcome (success, error or throttled), including retries.",
+	}, []string{"operation", "result"})
+
+	route53Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ns116_route53_request_duration_seconds",
+		Help:    "Route53 API operation latency including retries.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ns116_cache_lookups_total",
+		Help: "DNS cache lookups by cache (zones or records) and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ns116_logins_total",
+		Help: "Login attempts by auth source (local or ldap) and result (success, failure or denied).",
+	}, []string{"source", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		route53Requests, route53Duration,
+		cacheLookups, logins,
+	)
+}
+
+// CacheLookup records a hit or miss of the zones or records cache.
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// Login records a login attempt against one auth source.
+func Login(source, result string) {
+	logins.WithLabelValues(source, result).Inc()
+}
@@ -1,23 +1,175 @@
 package model
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type HostedZone struct {
-	ID          string
-	Name        string
-	RecordCount int64
-	Comment     string
-	Label       string
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	RecordCount int64  `json:"record_count"`
+	Comment     string `json:"comment,omitempty"`
+	Label       string `json:"label,omitempty"`
+	// Description, Owner and Tags come from the zone's managed settings
+	Description string   `json:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// ManagedZone holds the settings admins keep for a hosted zone in the
+// database. Enabled zones join the allowlist of config hosted_zones, whose
+// entries take precedence.
+type ManagedZone struct {
+	ZoneID      string    `json:"zone_id"`
+	Name        string    `json:"name"`
+	Enabled     bool      `json:"enabled"`
+	Label       string    `json:"label,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	UpdatedBy   string    `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
+
+// ZoneSettings is a hosted zone visible to the AWS credentials as shown on
+// the admin Zones page, with the settings in effect for it.
+type ZoneSettings struct {
+	HostedZone
+	// Visible reports whether users can see and edit the zone
+	Visible bool
+	// Enabled reports whether the zone is on the allowlist
+	Enabled bool
+	// InConfig marks zones listed in hosted_zones, which are always enabled
+	InConfig bool
+	// LabelInConfig reports whether hosted_zones sets the label, so it
+	// cannot be changed from the UI
+	LabelInConfig bool
 }
 
 type DNSRecord struct {
-	Name        string
-	Type        string
-	TTL         int64
-	Values      []string
-	IsAlias     bool
-	AliasTarget string
-	AliasZoneID string
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	TTL         int64    `json:"ttl,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	IsAlias     bool     `json:"alias,omitempty"`
+	AliasTarget string   `json:"alias_target,omitempty"`
+	AliasZoneID string   `json:"alias_zone_id,omitempty"`
+	// EvaluateTargetHealth only applies to alias records
+	EvaluateTargetHealth bool `json:"evaluate_target_health,omitempty"`
+	// SetIdentifier distinguishes record sets sharing a name and type under
+	// a routing policy
+	SetIdentifier string `json:"set_identifier,omitempty"`
+	RoutingPolicy
+}
+
+// RoutingPolicy holds the Route53 routing options of a record set. At most
+// one of Weight, Region, Failover, GeoLocation and MultiValueAnswer is set.
+type RoutingPolicy struct {
+	Weight           *int64       `json:"weight,omitempty"`
+	Region           string       `json:"region,omitempty"`
+	Failover         string       `json:"failover,omitempty"` // PRIMARY or SECONDARY
+	GeoLocation      *GeoLocation `json:"geolocation,omitempty"`
+	MultiValueAnswer bool         `json:"multivalue,omitempty"`
+	HealthCheckID    string       `json:"health_check_id,omitempty"`
+}
+
+type GeoLocation struct {
+	ContinentCode   string `json:"continent,omitempty"`
+	CountryCode     string `json:"country,omitempty"`
+	SubdivisionCode string `json:"subdivision,omitempty"`
+}
+
+// Version is a short content hash of the record set. Pages embed it so that
+// edits and deletes can detect that the record changed since it was shown.
+func (r DNSRecord) Version() string {
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ChangeInfo is the status of a submitted Route53 change batch.
+type ChangeInfo struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"` // PENDING or INSYNC
+	SubmittedAt time.Time `json:"submitted_at"`
+	Comment     string    `json:"comment,omitempty"`
+}
+
+// ImportResult summarizes a record import. Change is nil when every record
+// was already up to date.
+type ImportResult struct {
+	Change    *ChangeInfo `json:"change,omitempty"`
+	Created   int         `json:"created"`
+	Updated   int         `json:"updated"`
+	Unchanged int         `json:"unchanged"`
+	// Skipped counts the apex SOA and NS records, which are left alone
+	Skipped int `json:"skipped"`
+}
+
+// SyncRequest is the body of the plan and apply API calls: the desired
+// record sets of a zone, as read from its zone file.
+type SyncRequest struct {
+	Records []DNSRecord `json:"records"`
+	// ManagedOnly keeps record sets missing from Records instead of
+	// deleting them. The server setting sync.managed_only forces it on.
+	ManagedOnly bool `json:"managed_only,omitempty"`
+	// Comment is stored with the change batches Apply submits and becomes
+	// the message of their commits in the zone history.
+	Comment string `json:"comment,omitempty"`
+}
+
+// PlannedChange is one difference between a zone file and the zone. Before
+// is nil for CREATE and After is nil for DELETE.
+type PlannedChange struct {
+	Action string     `json:"action"` // CREATE, UPDATE or DELETE
+	Before *DNSRecord `json:"before,omitempty"`
+	After  *DNSRecord `json:"after,omitempty"`
+}
+
+// Record is the record set the change is about.
+func (c PlannedChange) Record() DNSRecord {
+	if c.After != nil {
+		return *c.After
+	}
+	return *c.Before
+}
+
+// SyncPlan lists the changes that bring a zone in line with its zone file,
+// in the order they are applied.
+type SyncPlan struct {
+	ZoneID    string          `json:"zone_id"`
+	ZoneName  string          `json:"zone_name"`
+	Changes   []PlannedChange `json:"changes"`
+	Unchanged int             `json:"unchanged"`
+	// Kept counts the record sets missing from the file that managed-only
+	// mode leaves in place
+	Kept        int  `json:"kept,omitempty"`
+	ManagedOnly bool `json:"managed_only,omitempty"`
+	// Batches are the change batches submitted, once the plan is applied
+	Batches []ChangeInfo `json:"batches,omitempty"`
+}
+
+// Count returns the number of changes with the given action.
+func (p SyncPlan) Count(action string) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// APIError is the body of an unsuccessful API response.
+type APIError struct {
+	Error string `json:"error"`
 }
 
 type RecordChangeRequest struct {
@@ -28,35 +180,239 @@ type RecordChangeRequest struct {
 	Values []string
 }
 
+// RecordChange is one entry of a change batch. Record must describe the
+// complete record set; for DELETE it has to match Route53 exactly.
+type RecordChange struct {
+	Action string // CREATE, UPSERT or DELETE
+	Record DNSRecord
+}
+
 type User struct {
 	ID         int64
 	Username   string
 	PassHash   string
 	Role       string
 	Active     bool
-	AuthSource string // "local" or "ldap"
+	AuthSource string // "local", "ldap", "proxy" or "cert"
+	Email      string // set by the user, or from the directory for LDAP users
+	// MustChangePassword is set after an admin reset; the user is sent to
+	// the password change page until they pick a new password.
+	MustChangePassword bool
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// APIToken is a personal access token for the HTTP API. Only a hash of the
+// secret is stored; the secret itself is shown once, when it is created.
+type APIToken struct {
+	ID       int64
+	UserID   int64
+	Username string
+	Name     string
+	// Prefix is the start of the secret, to tell tokens apart
+	Prefix     string
 	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time // nil for tokens that do not expire
+}
+
+// Expired reports whether the token can no longer be used.
+func (t APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// APIIdentity is the user an API token acts as.
+type APIIdentity struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
 }
 
 type Session struct {
-	Token     string
-	Username  string
-	CreatedAt time.Time
-	ExpiresAt time.Time
+	Token      string
+	CSRFToken  string
+	Username   string
+	AuthMethod string // "local", "ldap", "proxy" or "cert"
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
 }
 
+// Audit entry outcomes.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
 type AuditEntry struct {
-	ID         int64
+	ID         int64     `json:"id"`
+	Username   string    `json:"username"`
+	Action     string    `json:"action"`
+	ZoneID     string    `json:"zone_id,omitempty"`
+	ZoneName   string    `json:"zone_name,omitempty"`
+	RecordName string    `json:"record_name,omitempty"`
+	RecordType string    `json:"record_type,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Before and After hold the JSON state of the affected object (a
+	// DNSRecord for record changes) and are stored as JSONB.
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	ChangeID   string          `json:"change_id,omitempty"`
+	AuthMethod string          `json:"auth_method,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	// CertFingerprint is the SHA-256 of the client certificate presented
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+
+	// PrevHash and Hash link the entry into the tamper-evident audit chain.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// AuditCursor selects a page of the audit log by entry ID: entries older
+// than Before or newer than After. The zero value is the newest page.
+type AuditCursor struct {
+	Before int64
+	After  int64
+}
+
+// AuditCheckpoint is a signed record of the head of the audit chain at a
+// point in time. Rows removed or rewritten behind it are detectable even if
+// the whole chain after them is recomputed.
+type AuditCheckpoint struct {
+	ID        int64     `json:"id"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Signature string    `json:"signature"`
+}
+
+// AuditRetentionRun records the outcome of one audit retention pass.
+type AuditRetentionRun struct {
+	StartedAt time.Time `json:"started_at"`
+	Archived  int64     `json:"archived"`
+	FirstID   int64     `json:"first_id,omitempty"`
+	LastID    int64     `json:"last_id,omitempty"`
+	File      string    `json:"file,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditStats summarises the online audit log without scanning it.
+type AuditStats struct {
+	EstimatedRows int64
+	OldestAt      time.Time
+	NewestAt      time.Time
+}
+
+// AuditVerification is the result of walking the audit chain. Reason is
+// empty when the chain is intact; otherwise BrokenID is the first entry that
+// fails (0 when the problem is the checkpoint itself).
+type AuditVerification struct {
+	Checked    int
+	LastID     int64
+	BrokenID   int64
+	Reason     string
+	Checkpoint *AuditCheckpoint
+}
+
+func (v AuditVerification) OK() bool {
+	return v.Reason == ""
+}
+
+func (v AuditVerification) String() string {
+	switch {
+	case v.OK() && v.Checkpoint != nil:
+		return fmt.Sprintf("Audit log intact: %d entries verified, signed checkpoint at entry #%d (%s)",
+			v.Checked, v.Checkpoint.ID, v.Checkpoint.CreatedAt.UTC().Format("2006-01-02 15:04:05 UTC"))
+	case v.OK():
+		return fmt.Sprintf("Audit log intact: %d entries verified, no checkpoint written yet", v.Checked)
+	case v.BrokenID != 0:
+		return fmt.Sprintf("Audit chain broken at entry #%d: %s", v.BrokenID, v.Reason)
+	default:
+		return "Audit chain broken: " + v.Reason
+	}
+}
+
+// AuditChange is one field that differs between an entry's Before and After.
+type AuditChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// AuditState marshals v for use as AuditEntry.Before/After. A nil pointer
+// yields no state.
+func AuditState(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil
+	}
+	return b
+}
+
+// Changes lists the top-level fields that differ between Before and After,
+// sorted by field name. Creations and deletions list every field.
+func (e AuditEntry) Changes() []AuditChange {
+	var before, after map[string]any
+	_ = json.Unmarshal(e.Before, &before)
+	_ = json.Unmarshal(e.After, &after)
+
+	fields := make(map[string]bool)
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	var changes []AuditChange
+	for k := range fields {
+		oldV, newV := formatStateValue(before[k]), formatStateValue(after[k])
+		if oldV != newV {
+			changes = append(changes, AuditChange{Field: k, Old: oldV, New: newV})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func formatStateValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case []any:
+		parts := make([]string, len(t))
+		for i, p := range t {
+			parts[i] = formatStateValue(p)
+		}
+		return strings.Join(parts, ", ")
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
+
+// AuditFilter narrows audit log queries. Zero-valued fields are ignored.
+type AuditFilter struct {
 	Username   string
 	Action     string
 	ZoneID     string
-	ZoneName   string
-	RecordName string
+	RecordName string // prefix match on the fully-qualified name
 	RecordType string
-	Detail     string
-	IPAddress  string
-	CreatedAt  time.Time
+	IPAddress  string // prefix match, so "10.1." matches a subnet
+	From       time.Time
+	To         time.Time // exclusive
+	Search     string    // case-insensitive substring of Detail
+	Status     string    // AuditSuccess or AuditFailure
+	Value      string    // record value present in Before or After
 }
 
 type CachedRecord struct {
@@ -70,3 +426,96 @@ type CachedRecord struct {
 	AliasZoneID string
 	CachedAt    time.Time
 }
+
+// Webhook payload formats and delivery states.
+const (
+	WebhookFormatJSON  = "json"
+	WebhookFormatSlack = "slack"
+	WebhookFormatTeams = "teams"
+
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookSubscription sends matching events to an external URL. Empty Events
+// or ZoneIDs match everything.
+type WebhookSubscription struct {
+	ID        int
+	Name      string
+	URL       string
+	Secret    string
+	Format    string
+	Events    []string
+	ZoneIDs   []string
+	Active    bool
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// WebhookEvent is the JSON payload delivered to subscribers. Slack and Teams
+// formats are rendered from it at send time.
+type WebhookEvent struct {
+	Event      string          `json:"event"`
+	AuditID    int64           `json:"audit_id,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	ZoneID     string          `json:"zone_id,omitempty"`
+	RecordName string          `json:"record_name,omitempty"`
+	RecordType string          `json:"record_type,omitempty"`
+	Detail     string          `json:"detail,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	ChangeID   string          `json:"change_id,omitempty"`
+}
+
+// WebhookDelivery is one attempt-tracked send of an event to a subscription.
+type WebhookDelivery struct {
+	ID             int64
+	SubscriptionID int
+	AuditID        int64
+	Event          string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	ResponseCode   int
+	Error          string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+// Email notification modes.
+const (
+	NotifyInstant = "instant"
+	NotifyDigest  = "digest"
+)
+
+// NotificationSubscription subscribes a user to email about changes in a
+// zone. An empty ZoneID covers every zone.
+type NotificationSubscription struct {
+	ZoneID    string
+	Mode      string
+	CreatedAt time.Time
+}
+
+// Notification is a queued email for a user. Payload is a WebhookEvent for
+// kind "event" and a NotificationDigest for kind "digest"; the message is
+// rendered when it is sent.
+type Notification struct {
+	ID       int64
+	UserID   int64
+	Username string
+	Email    string
+	Kind     string
+	Payload  json.RawMessage
+	Attempts int
+}
+
+// NotificationDigest summarises the audit entries in a user's digest zones
+// since the previous digest.
+type NotificationDigest struct {
+	Since   time.Time    `json:"since"`
+	Entries []AuditEntry `json:"entries"`
+	More    int          `json:"more,omitempty"` // entries left out to keep the email short
+}
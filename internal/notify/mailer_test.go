@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ns116/internal/config"
+)
+
+// smtpMessage is what the fake server received in one session.
+type smtpMessage struct {
+	auth string
+	from string
+	rcpt []string
+	data string
+}
+
+// fakeSMTP is a minimal SMTP server on a local port that records the
+// sessions it serves.
+type fakeSMTP struct {
+	ln       net.Listener
+	auth     bool   // advertise AUTH PLAIN
+	rejectTo string // RCPT TO address refused with 550
+	messages chan smtpMessage
+}
+
+func newFakeSMTP(t *testing.T, auth bool, rejectTo string) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, auth: auth, rejectTo: rejectTo, messages: make(chan smtpMessage, 4)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) config() config.SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return config.SMTPConfig{Host: host, Port: p, From: "NS116 <ns116@example.com>", Timeout: 5 * time.Second}
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	var msg smtpMessage
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if s.auth {
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250 localhost")
+			}
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			b, _ := base64.StdEncoding.DecodeString(resp)
+			msg.auth = string(b)
+			reply("235 accepted")
+		case "MAIL":
+			msg.from = arg
+			reply("250 ok")
+		case "RCPT":
+			if s.rejectTo != "" && strings.Contains(arg, s.rejectTo) {
+				reply("550 no such user")
+				continue
+			}
+			msg.rcpt = append(msg.rcpt, arg)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			s.messages <- msg
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) next(t *testing.T) smtpMessage {
+	t.Helper()
+	select {
+	case m := <-s.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return smtpMessage{}
+	}
+}
+
+func TestMailerSend(t *testing.T) {
+	srv := newFakeSMTP(t, false, "")
+	m, err := NewMailer(srv.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "www.example.com. A changed: 192.0.2.1 -> 192.0.2.2\nÄnderung von alice"
+	if err := m.Send(context.Background(), "Bob <bob@example.com>", "Zone example.com. geändert", body); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.next(t)
+	if got.auth != "" {
+		t.Errorf("authenticated without a username: %q", got.auth)
+	}
+	if got.from != "FROM:<ns116@example.com>" || len(got.rcpt) != 1 || got.rcpt[0] != "TO:<bob@example.com>" {
+		t.Errorf("envelope = %q -> %q", got.from, got.rcpt)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "Zone example.com. geändert" {
+		t.Errorf("Subject = %q", subject)
+	}
+	if to := msg.Header.Get("To"); to != `"Bob" <bob@example.com>` {
+		t.Errorf("To = %q", to)
+	}
+	if msg.Header.Get("Auto-Submitted") != "auto-generated" || !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("headers = %v", msg.Header)
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The DATA writer ends the message with a line break
+	if got := strings.TrimSuffix(strings.ReplaceAll(string(decoded), "\r\n", "\n"), "\n"); got != body {
+		t.Errorf("body = %q, want %q", got, body)
+	}
+}
+
+func TestMailerAuth(t *testing.T) {
+	srv := newFakeSMTP(t, true, "")
+	cfg := srv.config()
+	cfg.Username, cfg.Password = "ns116", "s3cret"
+	m, err := NewMailer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), "bob@example.com", "hello", "body"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.next(t); got.auth != "\x00ns116\x00s3cret" {
+		t.Errorf("AUTH PLAIN = %q", got.auth)
+	}
+}
+
+func TestMailerErrors(t *testing.T) {
+	srv := newFakeSMTP(t, false, "nobody@example.com")
+	cfg := srv.config()
+
+	m, err := NewMailer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), "nobody@example.com", "hello", "body"); err == nil || !strings.Contains(err.Error(), "RCPT TO") {
+		t.Errorf("rejected recipient: err = %v", err)
+	}
+	if err := m.Send(context.Background(), "not an address", "hello", "body"); err == nil {
+		t.Error("invalid recipient accepted")
+	}
+
+	// A username requires the server to offer AUTH
+	cfg.Username, cfg.Password = "ns116", "s3cret"
+	m, _ = NewMailer(cfg)
+	if err := m.Send(context.Background(), "bob@example.com", "hello", "body"); err == nil || !strings.Contains(err.Error(), "AUTH") {
+		t.Errorf("server without AUTH: err = %v", err)
+	}
+
+	if _, err := NewMailer(config.SMTPConfig{From: "not an address"}); err == nil {
+		t.Error("invalid from accepted")
+	}
+}
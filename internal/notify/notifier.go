@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	claimBatch   = 20
+	leaseSeconds = 120
+	maxAttempts  = 8
+)
+
+// ZoneLister resolves zone IDs to names for display in emails.
+type ZoneLister interface {
+	ListZones(ctx context.Context) ([]model.HostedZone, error)
+}
+
+// Notifier sends queued notification emails and queues the daily digests.
+type Notifier struct {
+	db       *database.DB
+	mailer   *Mailer
+	zones    ZoneLister
+	baseURL  string
+	digestAt time.Time // only hour and minute are used
+
+	lastDigest string // day of the last digest this instance queued
+}
+
+func New(db *database.DB, cfg config.NotificationsConfig, zones ZoneLister) (*Notifier, error) {
+	mailer, err := NewMailer(cfg.SMTP)
+	if err != nil {
+		return nil, err
+	}
+	digestAt, err := time.Parse("15:04", cfg.DigestAt)
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{db: db, mailer: mailer, zones: zones, baseURL: cfg.BaseURL, digestAt: digestAt}, nil
+}
+
+// SendTest sends a test message straight to the address, bypassing the
+// queue so configuration errors are reported immediately.
+func (n *Notifier) SendTest(ctx context.Context, to, username string) error {
+	body := fmt.Sprintf("This is a test message from NS116, requested by %s.\n\n"+
+		"Email notifications are working.\n", username)
+	return n.mailer.Send(ctx, to, "[NS116] Test notification", body)
+}
+
+// Run sends due emails and queues the daily digest until ctx is cancelled.
+// A batch in progress when that happens is finished.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	work := context.WithoutCancel(ctx)
+	for {
+		n.queueDigest(work, time.Now().UTC())
+		n.sendDue(work)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *Notifier) queueDigest(ctx context.Context, now time.Time) {
+	day := now.Format("2006-01-02")
+	due := time.Date(now.Year(), now.Month(), now.Day(), n.digestAt.Hour(), n.digestAt.Minute(), 0, 0, time.UTC)
+	if day == n.lastDigest || now.Before(due) {
+		return
+	}
+	queued, err := n.db.QueueNotificationDigests(ctx, day)
+	if err != nil {
+		slog.Warn("queueing notification digests failed", "err", err)
+		return
+	}
+	n.lastDigest = day
+	if queued > 0 {
+		slog.Info("queued notification digests", "count", queued, "day", day)
+	}
+}
+
+func (n *Notifier) sendDue(ctx context.Context) {
+	pending, err := n.db.ClaimNotifications(ctx, claimBatch, leaseSeconds)
+	if err != nil {
+		slog.Warn("claiming notifications failed", "err", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	zoneNames := make(map[string]string)
+	if zones, err := n.zones.ListZones(ctx); err == nil {
+		for _, z := range zones {
+			zoneNames[z.ID] = z.Name
+		}
+	}
+
+	for _, p := range pending {
+		sendErr := n.send(ctx, p, zoneNames)
+		dropped, err := n.db.RecordNotificationAttempt(ctx, p.ID, sendErr, maxAttempts)
+		if err != nil {
+			slog.Warn("recording notification failed", "notification_id", p.ID, "err", err)
+		} else if dropped {
+			slog.Warn("giving up on notification", "kind", p.Kind, "user", p.Username,
+				"attempts", maxAttempts, "err", sendErr)
+		}
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, p model.Notification, zoneNames map[string]string) error {
+	if p.Email == "" {
+		return fmt.Errorf("user %s has no email address", p.Username)
+	}
+
+	var subject, body string
+	var err error
+	switch p.Kind {
+	case "event":
+		var ev model.WebhookEvent
+		if err := json.Unmarshal(p.Payload, &ev); err != nil {
+			return err
+		}
+		subject, body, err = renderEvent(ev, zoneNames, n.baseURL)
+	case "digest":
+		var d model.NotificationDigest
+		if err := json.Unmarshal(p.Payload, &d); err != nil {
+			return err
+		}
+		subject, body, err = renderDigest(d, n.baseURL)
+	default:
+		return fmt.Errorf("unknown notification kind %q", p.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	return n.mailer.Send(ctx, p.Email, subject, body)
+}
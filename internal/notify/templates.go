@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"ns116/internal/model"
+)
+
+// subjects holds the per-event subject lines; other events use
+// defaultSubject.
+var subjects = map[string]string{
+	"create_record": `{{.RecordType}} {{.RecordName}} created in {{.Zone}}`,
+	"edit_record":   `{{.RecordType}} {{.RecordName}} changed in {{.Zone}}`,
+	"delete_record": `{{.RecordType}} {{.RecordName}} deleted from {{.Zone}}`,
+}
+
+const defaultSubject = `{{.Event}} in {{.Zone}}`
+
+const eventBody = `{{.Actor}} {{.Verb}} in {{.Zone}}.
+{{with .RecordName}}
+  Record:    {{.}} {{$.RecordType}}{{end}}{{with .Before}}
+  Before:    {{.}}{{end}}{{with .After}}
+  After:     {{.}}{{end}}{{with .Detail}}
+  Detail:    {{.}}{{end}}
+  When:      {{.When}}{{with .ChangeID}}
+  Change ID: {{.}}{{end}}
+{{with .ZoneURL}}
+View the zone: {{.}}
+{{end}}
+-- 
+You receive this because you subscribed to changes in {{.Zone}}.{{with .ManageURL}}
+Manage your notifications: {{.}}{{end}}
+`
+
+const digestSubject = `Daily summary: {{.Total}} change{{if ne .Total 1}}s{{end}}`
+
+const digestBody = `Changes in your subscribed zones since {{.Since}} UTC:
+{{range .Zones}}
+{{.Name}}
+{{range .Entries}}  {{.When}}  {{.Username}}  {{.Action}}{{with .Record}}  {{.}}{{end}}{{with .Detail}}
+      {{.}}{{end}}
+{{end}}{{end}}{{if .More}}
+... and {{.More}} more. See the audit log for the full list.
+{{end}}
+-- 
+You receive this daily summary because you subscribed to it.{{with .ManageURL}}
+Manage your notifications: {{.}}{{end}}
+`
+
+var (
+	eventBodyTmpl     = template.Must(template.New("event").Parse(eventBody))
+	digestSubjectTmpl = template.Must(template.New("digest_subject").Parse(digestSubject))
+	digestBodyTmpl    = template.Must(template.New("digest").Parse(digestBody))
+	subjectTmpls      = map[string]*template.Template{}
+	defaultSubjTmpl   = template.Must(template.New("subject").Parse(defaultSubject))
+)
+
+func init() {
+	for event, s := range subjects {
+		subjectTmpls[event] = template.Must(template.New(event).Parse(s))
+	}
+}
+
+// renderEvent builds the subject and body of an instant notification.
+// zoneNames maps zone IDs to domain names for display.
+func renderEvent(ev model.WebhookEvent, zoneNames map[string]string, baseURL string) (string, string, error) {
+	data := map[string]any{
+		"Event":      ev.Event,
+		"Actor":      ev.Actor,
+		"Verb":       verb(ev.Event),
+		"Zone":       zoneLabel(ev.ZoneID, zoneNames),
+		"RecordName": ev.RecordName,
+		"RecordType": ev.RecordType,
+		"Before":     recordSummary(ev.Before),
+		"After":      recordSummary(ev.After),
+		"Detail":     ev.Detail,
+		"When":       ev.OccurredAt.Format("2006-01-02 15:04:05"),
+		"ChangeID":   ev.ChangeID,
+		"ZoneURL":    link(baseURL, "/zones/"+ev.ZoneID+"/records"),
+		"ManageURL":  link(baseURL, "/account/notifications"),
+	}
+
+	subj := subjectTmpls[ev.Event]
+	if subj == nil {
+		subj = defaultSubjTmpl
+	}
+	subject, err := execute(subj, data)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := execute(eventBodyTmpl, data)
+	return "[NS116] " + subject, body, err
+}
+
+type digestLine struct {
+	When, Username, Action, Record, Detail string
+}
+
+type digestZone struct {
+	Name    string
+	Entries []digestLine
+}
+
+// renderDigest builds the daily summary, grouping entries by zone in order
+// of first appearance.
+func renderDigest(d model.NotificationDigest, baseURL string) (string, string, error) {
+	var zones []*digestZone
+	byID := make(map[string]*digestZone)
+	for _, e := range d.Entries {
+		z, ok := byID[e.ZoneID]
+		if !ok {
+			name := e.ZoneID
+			if e.ZoneName != "" {
+				name = strings.TrimSuffix(e.ZoneName, ".") + " (" + e.ZoneID + ")"
+			}
+			z = &digestZone{Name: name}
+			byID[e.ZoneID] = z
+			zones = append(zones, z)
+		}
+		z.Entries = append(z.Entries, digestLine{
+			When:     e.CreatedAt.Format("01-02 15:04"),
+			Username: e.Username,
+			Action:   e.Action,
+			Record:   strings.TrimSpace(e.RecordType + " " + e.RecordName),
+			Detail:   e.Detail,
+		})
+	}
+
+	data := map[string]any{
+		"Since":     d.Since.UTC().Format("2006-01-02 15:04"),
+		"Total":     len(d.Entries) + d.More,
+		"Zones":     zones,
+		"More":      d.More,
+		"ManageURL": link(baseURL, "/account/notifications"),
+	}
+	subject, err := execute(digestSubjectTmpl, data)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := execute(digestBodyTmpl, data)
+	return "[NS116] " + subject, body, err
+}
+
+func execute(t *template.Template, data any) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func verb(event string) string {
+	switch event {
+	case "create_record":
+		return "created a record"
+	case "edit_record":
+		return "changed a record"
+	case "delete_record":
+		return "deleted a record"
+	}
+	return "performed " + event
+}
+
+func zoneLabel(zoneID string, names map[string]string) string {
+	if name := names[zoneID]; name != "" {
+		return strings.TrimSuffix(name, ".")
+	}
+	return zoneID
+}
+
+// recordSummary renders a record state as "TTL 300: 192.0.2.1, 192.0.2.2".
+func recordSummary(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var r model.DNSRecord
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return string(raw)
+	}
+	if r.IsAlias {
+		return "ALIAS " + r.AliasTarget
+	}
+	return fmt.Sprintf("TTL %d: %s", r.TTL, strings.Join(r.Values, ", "))
+}
+
+func link(baseURL, path string) string {
+	if baseURL == "" {
+		return ""
+	}
+	return baseURL + path
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"ns116/internal/database"
+)
+
+// runAuditCheckpoints signs the head of the audit chain every interval. A
+// broken chain is logged and the last good checkpoint is left in place.
+func runAuditCheckpoints(ctx context.Context, db *database.DB, key []byte, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	work := context.WithoutCancel(ctx)
+	for {
+		if _, err := db.WriteAuditCheckpoint(work, key); err != nil {
+			slog.Error("audit checkpoint failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
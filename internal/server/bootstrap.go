@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+)
+
+// bootstrapAdmin prepares the first-run state. When an admin is configured
+// via setup.admin_username/admin_password it is created directly; otherwise a
+// one-time token is returned that the web setup form requires.
+func bootstrapAdmin(ctx context.Context, db *database.DB, cfg *config.Config) (string, error) {
+	hasUsers, err := db.HasUsers(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to check users: %w", err)
+	}
+	if hasUsers {
+		return "", nil
+	}
+
+	if cfg.Setup.AdminUsername != "" {
+		if err := auth.ValidatePassword(cfg.PasswordPolicy, cfg.Setup.AdminPassword); err != nil {
+			return "", fmt.Errorf("setup.admin_password: %w", err)
+		}
+		created, err := db.CreateFirstAdmin(ctx, cfg.Setup.AdminUsername, cfg.Setup.AdminPassword)
+		if err != nil {
+			return "", fmt.Errorf("failed to bootstrap admin: %w", err)
+		}
+		if created {
+			slog.Info("bootstrapped initial admin from configuration", "user", cfg.Setup.AdminUsername)
+		}
+		return "", nil
+	}
+
+	if cfg.Setup.DisableWeb {
+		slog.Warn("no users exist and web setup is disabled; set setup.admin_username and setup.admin_password")
+		return "", nil
+	}
+
+	token := cfg.Setup.Token
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate setup token: %w", err)
+		}
+		token = hex.EncodeToString(b)
+		slog.Warn("initial setup required: open /setup and enter the one-time setup token", "setup_token", token)
+	} else {
+		slog.Warn("initial setup required: open /setup and enter the configured setup token")
+	}
+	return token, nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"ns116/internal/auth"
+	"ns116/internal/config"
+	"ns116/internal/database"
+	"ns116/internal/handler"
+	"ns116/internal/logging"
+	"ns116/internal/model"
+	"ns116/internal/service"
+	"ns116/internal/util"
+)
+
+// reloader re-reads the configuration on SIGHUP or from the admin
+// Configuration page and applies the settings that can change at runtime:
+// the zone allowlist and labels, LDAP, proxy authentication, the trusted
+// proxies, the client certificate users and the log level. Changes to anything else are
+// reported as needing a restart. An invalid configuration is rejected and
+// the running one kept.
+type reloader struct {
+	path       string
+	db         *database.DB
+	r53        *service.DNSService
+	authH      *handler.AuthHandler
+	proxyAuth  *handler.ProxyAuth
+	certMapper *auth.CertMapper // nil without client certificates
+	clientIP   *util.ClientIP
+
+	mu sync.Mutex
+	// cfg is the configuration in effect: the startup configuration with
+	// the reloadable settings of the last reload applied
+	cfg *config.Config
+}
+
+// Current returns the configuration in effect.
+func (rl *reloader) Current() *config.Config {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.cfg
+}
+
+// Reload loads the configuration file again and applies it, audited on
+// behalf of actor. It returns the changes that were applied and those that
+// only take effect after a restart.
+func (rl *reloader) Reload(ctx context.Context, actor model.AuditEntry) (applied, restart []config.Change, err error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	entry := actor
+	entry.Action = "reload_config"
+	next, err := config.Load(rl.path)
+	if err != nil {
+		entry.Status, entry.Error = model.AuditFailure, err.Error()
+		rl.audit(ctx, entry)
+		slog.ErrorContext(ctx, "configuration reload rejected; keeping the current configuration", "err", err)
+		return nil, nil, err
+	}
+
+	effective := rl.apply(next)
+	applied = config.Diff(rl.cfg, effective)
+	restart = config.Diff(effective, next)
+	rl.cfg = effective
+
+	before, after := make(map[string]string), make(map[string]string)
+	for _, c := range applied {
+		before[c.Path], after[c.Path] = c.Old, c.New
+	}
+	entry.Detail = fmt.Sprintf("applied=%d", len(applied))
+	if len(restart) > 0 {
+		entry.Detail += " restart_required=" + strings.Join(changedPaths(restart), ",")
+	}
+	if len(applied) > 0 {
+		entry.Before, entry.After = model.AuditState(before), model.AuditState(after)
+	}
+	entry.Status = model.AuditSuccess
+	rl.audit(ctx, entry)
+
+	slog.InfoContext(ctx, "configuration reloaded", "applied", strings.Join(changedPaths(applied), ","))
+	if len(restart) > 0 {
+		slog.WarnContext(ctx, "some configuration changes need a restart", "settings", strings.Join(changedPaths(restart), ","))
+	}
+	return applied, restart, nil
+}
+
+// apply switches the running components to the reloadable settings of next
+// and returns the resulting effective configuration.
+func (rl *reloader) apply(next *config.Config) *config.Config {
+	effective := *rl.cfg
+
+	rl.r53.SetAllowedZones(next.HostedZones)
+	effective.HostedZones = next.HostedZones
+
+	var ldapClient *auth.LDAPClient
+	if next.LDAP.Enabled {
+		ldapClient = auth.NewLDAPClient(next.LDAP)
+	}
+	rl.authH.Configure(ldapClient, next.ProxyAuth.LogoutURL)
+	rl.proxyAuth.Configure(next.ProxyAuth)
+	effective.LDAP, effective.ProxyAuth = next.LDAP, next.ProxyAuth
+
+	// Already validated by config.Load
+	trusted, _ := next.Server.TrustedProxyPrefixes()
+	rl.clientIP.Configure(trusted, next.Server.ClientIPHeader)
+	effective.Server.TrustedProxies = next.Server.TrustedProxies
+	effective.Server.ClientIPHeader = next.Server.ClientIPHeader
+
+	if rl.certMapper != nil {
+		rl.certMapper.SetUsers(next.Server.TLS.ClientCerts.Users)
+		effective.Server.TLS.ClientCerts.Users = next.Server.TLS.ClientCerts.Users
+	}
+
+	logging.SetLevel(next.Log.Level)
+	effective.Log.Level = next.Log.Level
+	return &effective
+}
+
+func (rl *reloader) audit(ctx context.Context, entry model.AuditEntry) {
+	if err := rl.db.LogAudit(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "writing audit entry failed", "action", entry.Action, "err", err)
+	}
+}
+
+// watchSignals reloads the configuration on every SIGHUP until ctx is done.
+// hup must already be registered with signal.Notify so a SIGHUP arriving
+// during startup does not terminate the process.
+func (rl *reloader) watchSignals(ctx context.Context, hup chan os.Signal) {
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received; reloading configuration", "path", rl.path)
+			// Errors are logged and audited by Reload
+			_, _, _ = rl.Reload(ctx, model.AuditEntry{Username: "system"})
+		}
+	}
+}
+
+// notifyHUP starts catching SIGHUP for watchSignals.
+func notifyHUP() chan os.Signal {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	return hup
+}
+
+func changedPaths(changes []config.Change) []string {
+	paths := make([]string, len(changes))
+	for i, c := range changes {
+		paths[i] = c.Path
+	}
+	return paths
+}
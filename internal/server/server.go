@@ -1,50 +1,156 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"ns116/internal/audit"
 	"ns116/internal/auth"
 	"ns116/internal/config"
 	"ns116/internal/database"
 	"ns116/internal/handler"
+	"ns116/internal/history"
+	"ns116/internal/logging"
+	"ns116/internal/metrics"
+	"ns116/internal/notify"
 	"ns116/internal/service"
+	"ns116/internal/tracing"
+	"ns116/internal/util"
+	"ns116/internal/webhook"
 	"ns116/web"
-	"strings"
-	"time"
 )
 
 func mustParseTemplates(fsys fs.FS, funcMap template.FuncMap, files ...string) *template.Template {
 	tmpl := template.New("").Funcs(funcMap)
 	tmpl, err := tmpl.ParseFS(fsys, files...)
 	if err != nil {
-		log.Fatalf("Failed to parse templates %v: %v", files, err)
+		slog.Error("failed to parse templates", "files", files, "err", err)
+		os.Exit(1)
 	}
 	return tmpl
 }
 
-func Start(cfg *config.Config, version string) error {
-	db, err := database.Open(cfg.Database.DSN, web.MigrationsFS())
+// Start serves NS116 until SIGINT or SIGTERM. configPath is re-read on
+// SIGHUP.
+func Start(cfg *config.Config, configPath, version string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := notifyHUP()
+
+	if cfg.Tracing.Enabled {
+		shutdown, err := tracing.Setup(ctx, cfg.Tracing, version)
+		if err != nil {
+			return err
+		}
+		defer shutdown(context.Background())
+		slog.Info("tracing enabled", "exporter", cfg.Tracing.Exporter, "endpoint", cfg.Tracing.Endpoint,
+			"sample_ratio", cfg.Tracing.SampleRatio)
+	}
+	db, err := database.Open(ctx, cfg.Database.DSN, web.MigrationsFS(), !cfg.Database.ManualMigrations)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer db.Close()
 
-	sessionMgr, err := auth.NewSessionManager(db)
+	sessionMgr, err := auth.NewSessionManager(ctx, db, cfg.Server.TLS.SecureCookies())
 	if err != nil {
 		return fmt.Errorf("failed to init session manager: %w", err)
 	}
 
-	_ = db.PurgeExpiredSessions()
+	if _, err := db.PurgeExpiredSessions(ctx); err != nil {
+		slog.Warn("purging expired sessions failed", "err", err)
+	}
 
 	r53, err := service.NewDNSService(cfg, db)
 	if err != nil {
 		return fmt.Errorf("failed to init DNS service: %w", err)
 	}
 
+	// Sinks must be registered before anything is audited so no entry misses
+	// the outbox
+	auditSinks, err := audit.NewSinks(cfg.Audit.Sinks)
+	if err != nil {
+		return err
+	}
+	var zoneHistory *history.Repo
+	if cfg.History.Enabled() {
+		if zoneHistory, err = history.New(ctx, cfg.History, db, r53); err != nil {
+			return err
+		}
+		auditSinks = append(auditSinks, zoneHistory)
+	}
+	sinkNames := make([]string, len(auditSinks))
+	for i, s := range auditSinks {
+		sinkNames[i] = s.Name()
+	}
+	if err := db.ConfigureAuditOutbox(ctx, sinkNames); err != nil {
+		return fmt.Errorf("failed to configure audit outbox: %w", err)
+	}
+
+	// Background jobs keep running while requests drain after a signal and
+	// are stopped separately once the server has shut down
+	jobsCtx, stopJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopJobs()
+	var jobs sync.WaitGroup
+
+	db.ConfigureNotifications(cfg.Notifications.Enabled())
+	if len(auditSinks) > 0 {
+		slog.Info("shipping audit entries to sinks", "sinks", strings.Join(sinkNames, ", "))
+		dispatcher := audit.NewDispatcher(db, auditSinks)
+		jobs.Go(func() { dispatcher.Run(jobsCtx) })
+	}
+	if zoneHistory != nil {
+		slog.Info("zone history enabled", "dir", cfg.History.Dir, "branch", cfg.History.Branch,
+			"push", cfg.History.Remote != "")
+		jobs.Go(func() { zoneHistory.Run(jobsCtx) })
+	}
+
+	setupToken, err := bootstrapAdmin(ctx, db, cfg)
+	if err != nil {
+		return err
+	}
+
+	auditKey, err := audit.CheckpointKey(ctx, cfg.Audit, db)
+	if err != nil {
+		return fmt.Errorf("failed to load audit checkpoint key: %w", err)
+	}
+	if cfg.Audit.CheckpointKey == "" {
+		slog.Warn("audit.checkpoint_key is not set; audit checkpoints are signed with the session secret stored in the database")
+	}
+	jobs.Go(func() { runAuditCheckpoints(jobsCtx, db, auditKey, cfg.Audit.CheckpointInterval) })
+
+	retention := audit.NewRetention(db, cfg.Audit, auditKey)
+	if retention.Enabled() {
+		slog.Info("audit retention enabled", "days", cfg.Audit.RetentionDays, "archive_dir", cfg.Audit.ArchiveDir)
+		jobs.Go(func() { retention.Loop(jobsCtx) })
+	}
+
+	webhooks := webhook.NewDispatcher(db)
+	jobs.Go(func() { webhooks.Run(jobsCtx) })
+
+	var notifier *notify.Notifier
+	if cfg.Notifications.Enabled() {
+		notifier, err = notify.New(db, cfg.Notifications, r53)
+		if err != nil {
+			return fmt.Errorf("failed to init notifications: %w", err)
+		}
+		smtp := cfg.Notifications.SMTP
+		slog.Info("email notifications enabled", "smtp_host", smtp.Host, "smtp_port", smtp.Port, "tls", smtp.TLS,
+			"digest_at", cfg.Notifications.DigestAt+" UTC")
+		jobs.Go(func() { notifier.Run(jobsCtx) })
+	}
+
 	tmplFS := web.TemplateFS()
 
 	funcMap := template.FuncMap{
@@ -52,6 +158,7 @@ func Start(cfg *config.Config, version string) error {
 		"subtract":   func(a, b int) int { return a - b },
 		"version":    func() string { return version },
 		"formatDate": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+		"join":       strings.Join,
 		"shortName": func(fqdn, zoneDomain string) string {
 			suffix := "." + zoneDomain
 			if fqdn == zoneDomain {
@@ -70,22 +177,47 @@ func Start(cfg *config.Config, version string) error {
 	recordsTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/records.html")
 	adminUsersTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_users.html")
 	adminAuditTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_audit.html")
+	adminRetentionTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_retention.html")
+	adminWebhooksTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_webhooks.html")
+	adminZonesTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_zones.html")
+	adminZoneEditTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_zone_edit.html")
+	adminConfigTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_config.html")
+	adminDeliveriesTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/admin_webhook_deliveries.html")
+	accountTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/account.html")
+	notificationsTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/account_notifications.html")
+	tokensTmpl := mustParseTemplates(tmplFS, funcMap, "templates/layout.html", "templates/account_tokens.html")
 
 	// Initialize LDAP client (nil if disabled)
 	var ldapClient *auth.LDAPClient
 	if cfg.LDAP.Enabled {
 		ldapClient = auth.NewLDAPClient(cfg.LDAP)
-		log.Println("LDAP authentication enabled")
-		log.Printf("LDAP server: %s", cfg.LDAP.URL)
-		log.Printf("LDAP groups mapped: %d role(s)", len(cfg.LDAP.GroupMapping))
+		slog.Info("LDAP authentication enabled", "url", cfg.LDAP.URL, "roles", len(cfg.LDAP.GroupMapping))
+		if strings.HasPrefix(cfg.LDAP.URL, "ldap://") && !cfg.LDAP.StartTLS {
+			slog.Warn("LDAP uses ldap:// without StartTLS; credentials are sent in cleartext")
+		}
 	}
 
-	setupH := handler.NewSetupHandler(db, setupTmpl)
-	authH := handler.NewAuthHandler(db, sessionMgr, ldapClient, loginTmpl)
+	setupH := handler.NewSetupHandler(db, setupTmpl, cfg.PasswordPolicy, setupToken, cfg.Setup.DisableWeb)
+	authH := handler.NewAuthHandler(db, sessionMgr, ldapClient, loginTmpl, cfg.ProxyAuth.LogoutURL)
 	zoneH := handler.NewZoneHandler(r53, sessionMgr, db, zonesTmpl)
 	recH := handler.NewRecordHandler(r53, sessionMgr, db, recordsTmpl)
-	adminH := handler.NewAdminHandler(db, sessionMgr, adminUsersTmpl)
-	adminAuditH := handler.NewAdminHandler(db, sessionMgr, adminAuditTmpl)
+	adminH := handler.NewAdminHandler(db, sessionMgr, adminUsersTmpl, cfg.PasswordPolicy, auditKey)
+	adminAuditH := handler.NewAdminHandler(db, sessionMgr, adminAuditTmpl, cfg.PasswordPolicy, auditKey)
+	retentionH := handler.NewRetentionHandler(db, sessionMgr, adminRetentionTmpl, retention)
+	webhookH := handler.NewWebhookHandler(db, sessionMgr, adminWebhooksTmpl)
+	zoneSettingsH := handler.NewZoneSettingsHandler(r53, sessionMgr, db, adminZonesTmpl)
+	zoneEditH := handler.NewZoneSettingsHandler(r53, sessionMgr, db, adminZoneEditTmpl)
+	rl := &reloader{path: configPath, cfg: cfg, db: db, r53: r53, authH: authH}
+	configH := handler.NewConfigHandler(db, sessionMgr, adminConfigTmpl, rl)
+	deliveriesH := handler.NewWebhookHandler(db, sessionMgr, adminDeliveriesTmpl)
+	accountH := handler.NewAccountHandler(db, sessionMgr, accountTmpl, cfg.PasswordPolicy)
+	notificationH := handler.NewNotificationHandler(r53, sessionMgr, db, notificationsTmpl, notifier)
+	tokenH := handler.NewTokenHandler(db, sessionMgr, tokensTmpl)
+	var certMapper *auth.CertMapper
+	if cfg.Server.TLS.ClientCerts.Enabled() {
+		certMapper = auth.NewCertMapper(cfg.Server.TLS.ClientCerts.Users)
+	}
+	apiH := handler.NewAPIHandler(r53, sessionMgr, db, cfg.Sync, certMapper)
 
 	mux := http.NewServeMux()
 
@@ -94,12 +226,56 @@ func Start(cfg *config.Config, version string) error {
 
 	mux.Handle("GET /static/", web.StaticHandler())
 
+	var readyR53 *service.DNSService
+	if cfg.Server.ReadyCheckRoute53 {
+		readyR53 = r53
+	}
+	healthH := handler.NewHealthHandler(db, readyR53)
+	mux.HandleFunc("GET /healthz", healthH.Healthz)
+	mux.HandleFunc("GET /readyz", healthH.Readyz)
+
+	metrics.RegisterDB(db)
+	if cfg.Metrics.Enabled {
+		mux.Handle("GET /metrics", metrics.Handler(cfg.Metrics.Token, cfg.Metrics.Username, cfg.Metrics.Password))
+		if cfg.Metrics.Token == "" && cfg.Metrics.Username == "" {
+			slog.Warn("/metrics is enabled without authentication")
+		}
+	}
+
+	// The API authenticates every request with a token, so it sits outside
+	// the cookie session middlewares of the web UI
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("GET /api/v1/whoami", apiH.RequireToken(apiH.WhoAmI))
+	apiMux.HandleFunc("GET /api/v1/zones", apiH.RequireToken(apiH.ListZones))
+	apiMux.HandleFunc("GET /api/v1/zones/{zoneID}", apiH.RequireToken(apiH.GetZone))
+	apiMux.HandleFunc("GET /api/v1/zones/{zoneID}/records", apiH.RequireToken(apiH.ListRecords))
+	apiMux.HandleFunc("POST /api/v1/zones/{zoneID}/records", apiH.RequireToken(apiH.CreateRecord))
+	apiMux.HandleFunc("POST /api/v1/zones/{zoneID}/import", apiH.RequireToken(apiH.Import))
+	apiMux.HandleFunc("POST /api/v1/zones/{zoneID}/plan", apiH.RequireToken(apiH.Plan))
+	apiMux.HandleFunc("POST /api/v1/zones/{zoneID}/apply", apiH.RequireToken(apiH.Apply))
+	apiMux.HandleFunc("GET /api/v1/zones/{zoneID}/records/{name}/{type}", apiH.RequireToken(apiH.GetRecord))
+	apiMux.HandleFunc("PUT /api/v1/zones/{zoneID}/records/{name}/{type}", apiH.RequireToken(apiH.UpdateRecord))
+	apiMux.HandleFunc("DELETE /api/v1/zones/{zoneID}/records/{name}/{type}", apiH.RequireToken(apiH.DeleteRecord))
+	apiMux.HandleFunc("GET /api/v1/changes/{changeID}", apiH.RequireToken(apiH.GetChange))
+	mux.Handle("/api/", apiMux)
+
 	appMux := http.NewServeMux()
 
 	appMux.HandleFunc("GET /login", authH.LoginPage)
 	appMux.HandleFunc("POST /login", authH.LoginSubmit)
 	appMux.HandleFunc("POST /logout", authH.Logout)
 
+	appMux.HandleFunc("GET /account/password", sessionMgr.RequireAuth(accountH.PasswordPage))
+	appMux.HandleFunc("POST /account/password", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(accountH.ChangePassword)))
+	appMux.HandleFunc("GET /account/notifications", sessionMgr.RequireAuth(notificationH.Page))
+	appMux.HandleFunc("POST /account/notifications/email", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(notificationH.SetEmail)))
+	appMux.HandleFunc("POST /account/notifications/subscribe", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(notificationH.Subscribe)))
+	appMux.HandleFunc("POST /account/notifications/unsubscribe", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(notificationH.Unsubscribe)))
+	appMux.HandleFunc("POST /account/notifications/test", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(notificationH.SendTest)))
+	appMux.HandleFunc("GET /account/tokens", sessionMgr.RequireAuth(tokenH.Page))
+	appMux.HandleFunc("POST /account/tokens/create", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(tokenH.Create)))
+	appMux.HandleFunc("POST /account/tokens/revoke", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(tokenH.Revoke)))
+
 	appMux.HandleFunc("GET /zones", sessionMgr.RequireAuth(zoneH.List))
 	appMux.HandleFunc("POST /zones/refresh", sessionMgr.RequireAuth(sessionMgr.ValidateCSRF(zoneH.RefreshZones)))
 	appMux.HandleFunc("GET /zones/{zoneID}/records", sessionMgr.RequireAuth(recH.List))
@@ -111,15 +287,105 @@ func Start(cfg *config.Config, version string) error {
 	appMux.HandleFunc("GET /admin/users", sessionMgr.RequireAdmin(adminH.ListUsers))
 	appMux.HandleFunc("POST /admin/users/create", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(adminH.CreateUser)))
 	appMux.HandleFunc("POST /admin/users/delete", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(adminH.DeleteUser)))
+	appMux.HandleFunc("POST /admin/users/role", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(adminH.UpdateRole)))
+	appMux.HandleFunc("POST /admin/users/active", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(adminH.SetActive)))
+	appMux.HandleFunc("POST /admin/users/reset-password", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(adminH.ResetPassword)))
 	appMux.HandleFunc("GET /admin/audit", sessionMgr.RequireAdmin(adminAuditH.AuditLog))
+	appMux.HandleFunc("GET /admin/audit/export", sessionMgr.RequireAdmin(adminAuditH.ExportAuditLog))
+	appMux.HandleFunc("POST /admin/audit/verify", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(adminAuditH.VerifyAuditLog)))
+	appMux.HandleFunc("GET /admin/audit/retention", sessionMgr.RequireAdmin(retentionH.Status))
+	appMux.HandleFunc("POST /admin/audit/retention/run", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(retentionH.RunNow)))
+	appMux.HandleFunc("GET /admin/webhooks", sessionMgr.RequireAdmin(webhookH.List))
+	appMux.HandleFunc("POST /admin/webhooks/create", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(webhookH.Create)))
+	appMux.HandleFunc("GET /admin/webhooks/{id}", sessionMgr.RequireAdmin(deliveriesH.Deliveries))
+	appMux.HandleFunc("POST /admin/webhooks/{id}/active", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(webhookH.SetActive)))
+	appMux.HandleFunc("POST /admin/webhooks/{id}/delete", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(webhookH.Delete)))
+	appMux.HandleFunc("POST /admin/webhooks/{id}/ping", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(webhookH.Ping)))
+	appMux.HandleFunc("POST /admin/webhooks/{id}/replay", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(webhookH.Replay)))
+
+	appMux.HandleFunc("GET /admin/zones", sessionMgr.RequireAdmin(zoneSettingsH.List))
+	appMux.HandleFunc("GET /admin/zones/{id}", sessionMgr.RequireAdmin(zoneEditH.Edit))
+	appMux.HandleFunc("POST /admin/zones/{id}", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(zoneSettingsH.Save)))
+	appMux.HandleFunc("POST /admin/zones/{id}/enabled", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(zoneSettingsH.SetEnabled)))
+
+	appMux.HandleFunc("GET /admin/config", sessionMgr.RequireAdmin(configH.Page))
+	appMux.HandleFunc("POST /admin/config/reload", sessionMgr.RequireAdmin(sessionMgr.ValidateCSRF(configH.Reload)))
 
 	appMux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/zones", http.StatusSeeOther)
 	})
 
-	mux.Handle("/", handler.RequireSetupComplete(db, appMux))
+	// Already validated with the rest of the configuration
+	trustedProxies, _ := cfg.Server.TrustedProxyPrefixes()
+	clientIP := util.NewClientIP(trustedProxies, cfg.Server.ClientIPHeader)
+	if len(trustedProxies) > 0 {
+		slog.Info("trusting forwarding header from proxies", "header", cfg.Server.ClientIPHeader,
+			"proxies", strings.Join(cfg.Server.TrustedProxies, ", "))
+	}
+
+	// Proxy authentication is always wired so a reload can turn it on
+	proxyAuth := handler.NewProxyAuth(db, sessionMgr, cfg.ProxyAuth, clientIP)
+	app := proxyAuth.Middleware(appMux)
+	if cfg.ProxyAuth.Enabled {
+		slog.Info("proxy header authentication enabled", "user_header", cfg.ProxyAuth.UserHeader,
+			"groups_header", cfg.ProxyAuth.GroupsHeader)
+	}
+	if certMapper != nil {
+		app = handler.NewCertAuth(db, sessionMgr, certMapper).Middleware(app)
+		slog.Info("client certificate authentication enabled", "rules", len(cfg.Server.TLS.ClientCerts.Users),
+			"required", cfg.Server.TLS.ClientCerts.Require)
+	}
+	// The reloader also swaps the authentication middlewares' settings
+	rl.proxyAuth, rl.certMapper, rl.clientIP = proxyAuth, certMapper, clientIP
+	jobs.Go(func() { rl.watchSignals(jobsCtx, hup) })
+	mux.Handle("/", handler.RequireSetupComplete(db, app))
+
+	root := clientIP.Middleware(
+		tracing.Middleware(logging.Middleware(metrics.Middleware(util.RecordRoute(mux)))))
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("NS116 server starting on %s", addr)
-	return http.ListenAndServe(addr, mux)
+	errorLog := slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           root,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ErrorLog:          errorLog,
+	}
+	if cfg.Server.TLS.Enabled() {
+		certs, err := newCertReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		go certs.Watch(ctx)
+		if srv.TLSConfig, err = tlsConfig(cfg.Server.TLS, certs); err != nil {
+			return fmt.Errorf("failed to load client CA bundle: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	listeners := []listener{{srv: srv, ln: ln}}
+	slog.Info("listening", "addr", addr, "tls", cfg.Server.TLS.Enabled())
+
+	if redirectAddr := cfg.Server.TLS.RedirectAddr; redirectAddr != "" {
+		ln, err := net.Listen("tcp", redirectAddr)
+		if err != nil {
+			return err
+		}
+		redirect := &http.Server{
+			Addr:              redirectAddr,
+			Handler:           redirectHandler(cfg.Server.Port),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			IdleTimeout:       cfg.Server.IdleTimeout,
+			ErrorLog:          errorLog,
+		}
+		listeners = append(listeners, listener{srv: redirect, ln: ln})
+		slog.Info("redirecting HTTP to HTTPS", "addr", redirectAddr)
+	}
+	return serve(ctx, listeners, cfg.Server.ShutdownTimeout, &jobs, stopJobs)
 }
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"ns116/internal/systemd"
+)
+
+// listener is an HTTP server bound to its socket. Servers with a TLSConfig
+// serve HTTPS.
+type listener struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+func (l listener) serve() error {
+	if l.srv.TLSConfig != nil {
+		return l.srv.ServeTLS(l.ln, "", "")
+	}
+	return l.srv.Serve(l.ln)
+}
+
+// serve runs every listener until one fails or ctx is cancelled by a
+// signal. It then stops accepting connections, waits for in-flight
+// requests, cancels the background jobs and waits for them too, all within
+// timeout.
+func serve(ctx context.Context, listeners []listener, timeout time.Duration, jobs *sync.WaitGroup, stopJobs context.CancelFunc) error {
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { serveErr <- l.serve() }()
+	}
+
+	if err := systemd.Notify("READY=1"); err != nil {
+		slog.Warn("systemd readiness notification failed", "err", err)
+	}
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go systemd.Watchdog(ctx, interval)
+	}
+
+	select {
+	case err := <-serveErr:
+		for _, l := range listeners {
+			l.srv.Close()
+		}
+		stopJobs()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", timeout)
+	_ = systemd.Notify("STOPPING=1")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Go(func() {
+			if err := l.srv.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("requests still in flight at shutdown timeout", "addr", l.srv.Addr, "err", err)
+			}
+		})
+	}
+	wg.Wait()
+	for range listeners {
+		if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("server stopped with error", "err", err)
+		}
+	}
+
+	stopJobs()
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("shutdown complete")
+	case <-shutdownCtx.Done():
+		slog.Warn("background jobs still running at shutdown timeout")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ns116/internal/config"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes. Renewals by certbot or cert-manager are picked up without restart.
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves the certificate loaded from certFile and keyFile and
+// swaps in a new one when either file changes. A broken or half-written
+// pair is logged and the current certificate is kept.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// lastModified is the later modification time of the two files.
+func (c *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) reload() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert, c.modTime = &cert, modTime
+	c.mu.Unlock()
+	slog.Info("TLS certificate loaded", "file", c.certFile, "subject", cert.Leaf.Subject.String(),
+		"not_after", cert.Leaf.NotAfter)
+	return nil
+}
+
+// Watch reloads the certificate whenever the files change, until ctx is done.
+func (c *certReloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reloadIfChanged()
+		}
+	}
+}
+
+func (c *certReloader) reloadIfChanged() {
+	modTime, err := c.lastModified()
+	if err != nil {
+		slog.Warn("checking TLS certificate failed", "err", err)
+		return
+	}
+	c.mu.RLock()
+	changed := !modTime.Equal(c.modTime)
+	c.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := c.reload(); err != nil {
+		slog.Error("reloading TLS certificate failed; keeping the current one", "err", err)
+	}
+}
+
+// tlsConfig builds the server TLS settings from cfg around certs.
+func tlsConfig(cfg config.TLSConfig, certs *certReloader) (*tls.Config, error) {
+	tc := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if cfg.MinVersion == "1.3" {
+		tc.MinVersion = tls.VersionTLS13
+	}
+	for _, name := range cfg.CipherSuites {
+		id, _ := config.CipherSuiteID(name)
+		tc.CipherSuites = append(tc.CipherSuites, id)
+	}
+	if cfg.ClientCerts.Enabled() {
+		pem, err := os.ReadFile(cfg.ClientCerts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", cfg.ClientCerts.CAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientCerts.Require {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tc, nil
+}
+
+// redirectHandler sends every request to the same host and path over HTTPS
+// on httpsPort.
+func redirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			http.Error(w, "Host header required", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, fmt.Sprintf("https://%s%s", host, r.URL.RequestURI()), http.StatusMovedPermanently)
+	})
+}
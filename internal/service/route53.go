@@ -2,9 +2,13 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -14,13 +18,36 @@ import (
 
 	"ns116/internal/config"
 	"ns116/internal/database"
+	"ns116/internal/logging"
+	"ns116/internal/metrics"
 	"ns116/internal/model"
+	"ns116/internal/tracing"
+)
+
+var (
+	ErrZoneNotAllowed = errors.New("not in the allowed list")
+	ErrRecordNotFound = errors.New("record no longer exists; reload the page")
+	ErrRecordChanged  = errors.New("record was modified since the page was loaded; reload and try again")
 )
 
 type DNSService struct {
-	client       *route53.Client
-	allowedZones map[string]string
-	db           *database.DB
+	client *route53.Client
+	// allowedZones maps the zone IDs of hosted_zones to their labels.
+	// Swapped as a whole on configuration reload.
+	allowedZones atomic.Pointer[map[string]string]
+	// managed is the last snapshot of the managed_zones table
+	managed atomic.Pointer[managedSnapshot]
+	db      *database.DB
+}
+
+// managedZonesTTL bounds how long zone settings changed through another
+// NS116 instance take to apply here.
+const managedZonesTTL = 30 * time.Second
+
+type managedSnapshot struct {
+	zones      map[string]model.ManagedZone
+	restricted bool // a zone has been enabled at some point
+	loadedAt   time.Time
 }
 
 func NewDNSService(cfg *config.Config, db *database.DB) (*DNSService, error) {
@@ -38,54 +65,183 @@ func NewDNSService(cfg *config.Config, db *database.DB) (*DNSService, error) {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	allowed := make(map[string]string)
-	for _, z := range cfg.HostedZones {
+	s := &DNSService{
+		client: route53.NewFromConfig(awsCfg, func(o *route53.Options) {
+			o.APIOptions = append(o.APIOptions, metrics.AWSMiddleware, logging.AWSMiddleware, tracing.AWSMiddleware)
+		}),
+		db: db,
+	}
+	s.setAllowedZones(cfg.HostedZones)
+	return s, nil
+}
+
+// SetAllowedZones replaces the hosted_zones allowlist and labels. Requests
+// already running finish with the list they started with.
+func (s *DNSService) SetAllowedZones(zones []config.HostedZoneEntry) {
+	s.setAllowedZones(zones)
+}
+
+func (s *DNSService) setAllowedZones(zones []config.HostedZoneEntry) {
+	allowed := make(map[string]string, len(zones))
+	for _, z := range zones {
 		allowed[z.ID] = z.Label
 	}
+	s.allowedZones.Store(&allowed)
+}
 
-	return &DNSService{
-		client:       route53.NewFromConfig(awsCfg),
-		allowedZones: allowed,
-		db:           db,
-	}, nil
+// Ping checks that Route53 answers with the configured credentials.
+func (s *DNSService) Ping(ctx context.Context) error {
+	_, err := s.client.GetHostedZoneCount(ctx, &route53.GetHostedZoneCountInput{})
+	return err
 }
 
+// ListZones returns the zones users may see and edit, with their labels
+// and managed settings.
 func (s *DNSService) ListZones(ctx context.Context) ([]model.HostedZone, error) {
-	if zones, ok := s.db.GetCachedZones(); ok {
-		return zones, nil
+	all, err := s.allZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	access := s.access(ctx)
+	var zones []model.HostedZone
+	for _, z := range all {
+		if zs := access.settings(z); zs.Visible {
+			zones = append(zones, zs.HostedZone)
+		}
 	}
+	return zones, nil
+}
 
-	result, err := s.client.ListHostedZones(ctx, &route53.ListHostedZonesInput{})
+// DiscoverZones returns every zone the AWS credentials can see, with the
+// settings in effect for it, for admins to choose the managed zones.
+func (s *DNSService) DiscoverZones(ctx context.Context) ([]model.ZoneSettings, error) {
+	all, err := s.allZones(ctx)
 	if err != nil {
 		return nil, err
 	}
+	access := s.access(ctx)
+	zones := make([]model.ZoneSettings, len(all))
+	for i, z := range all {
+		zones[i] = access.settings(z)
+	}
+	return zones, nil
+}
+
+// allZones lists every hosted zone of the account, from the cache when it
+// is fresh.
+func (s *DNSService) allZones(ctx context.Context) ([]model.HostedZone, error) {
+	if zones, ok := s.db.GetCachedZones(ctx); ok {
+		return zones, nil
+	}
 
 	var zones []model.HostedZone
-	for _, z := range result.HostedZones {
-		zoneID := extractZoneID(*z.Id)
+	pages := route53.NewListHostedZonesPaginator(s.client, &route53.ListHostedZonesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range page.HostedZones {
+			zones = append(zones, model.HostedZone{
+				ID:          extractZoneID(*z.Id),
+				Name:        *z.Name,
+				RecordCount: *z.ResourceRecordSetCount,
+				Comment:     safeComment(z.Config),
+			})
+		}
+	}
 
-		if len(s.allowedZones) > 0 {
-			if _, ok := s.allowedZones[zoneID]; !ok {
-				continue
-			}
+	if err := s.db.CacheZones(ctx, zones); err != nil {
+		slog.WarnContext(ctx, "caching zones failed", "err", err)
+	}
+	return zones, nil
+}
+
+// InvalidateManagedZones makes the next lookup read the managed zone
+// settings from the database again.
+func (s *DNSService) InvalidateManagedZones() {
+	s.managed.Store(nil)
+}
+
+func (s *DNSService) managedZones(ctx context.Context) *managedSnapshot {
+	snap := s.managed.Load()
+	if snap != nil && time.Since(snap.loadedAt) < managedZonesTTL {
+		return snap
+	}
+	list, err := s.db.ListManagedZones(ctx)
+	var restricted bool
+	if err == nil {
+		restricted, err = s.db.ZonesRestricted(ctx)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "loading managed zones failed", "err", err)
+		if snap != nil {
+			return snap
 		}
+		// Show nothing rather than every zone while the allowlist is unknown
+		return &managedSnapshot{restricted: true}
+	}
+	zones := make(map[string]model.ManagedZone, len(list))
+	for _, z := range list {
+		zones[z.ZoneID] = z
+	}
+	snap = &managedSnapshot{zones: zones, restricted: restricted, loadedAt: time.Now()}
+	s.managed.Store(snap)
+	return snap
+}
+
+// zoneAccess combines hosted_zones with the managed zone settings. Once
+// either enables a zone, only enabled zones are visible; before that all
+// are. Disabling every managed zone again leaves none visible rather than
+// all of them.
+type zoneAccess struct {
+	config     map[string]string
+	managed    map[string]model.ManagedZone
+	restricted bool
+}
+
+func (s *DNSService) access(ctx context.Context) zoneAccess {
+	snap := s.managedZones(ctx)
+	return newZoneAccess(*s.allowedZones.Load(), snap.zones, snap.restricted)
+}
 
-		zones = append(zones, model.HostedZone{
-			ID:          zoneID,
-			Name:        *z.Name,
-			RecordCount: *z.ResourceRecordSetCount,
-			Comment:     safeComment(z.Config),
-			Label:       s.allowedZones[zoneID],
-		})
+// newZoneAccess builds the access rules; restricted is set once a managed
+// zone has ever been enabled.
+func newZoneAccess(config map[string]string, managed map[string]model.ManagedZone, restricted bool) zoneAccess {
+	a := zoneAccess{config: config, managed: managed}
+	a.restricted = restricted || len(config) > 0
+	for _, z := range managed {
+		a.restricted = a.restricted || z.Enabled
 	}
+	return a
+}
 
-	_ = s.db.CacheZones(zones)
-	return zones, nil
+func (a zoneAccess) allowed(zoneID string) bool {
+	_, inConfig := a.config[zoneID]
+	return !a.restricted || inConfig || a.managed[zoneID].Enabled
+}
+
+// settings applies the zone's settings to z. A label from hosted_zones wins
+// over the managed one.
+func (a zoneAccess) settings(z model.HostedZone) model.ZoneSettings {
+	label, inConfig := a.config[z.ID]
+	m := a.managed[z.ID]
+	if label == "" {
+		label = m.Label
+	}
+	z.Label, z.Description, z.Owner, z.Tags = label, m.Description, m.Owner, m.Tags
+	return model.ZoneSettings{
+		HostedZone:    z,
+		Visible:       a.allowed(z.ID),
+		Enabled:       inConfig || m.Enabled,
+		InConfig:      inConfig,
+		LabelInConfig: a.config[z.ID] != "",
+	}
 }
 
 func (s *DNSService) GetZone(ctx context.Context, zoneID string) (model.HostedZone, error) {
-	if !s.isAllowed(zoneID) {
-		return model.HostedZone{}, fmt.Errorf("zone %s is not in the allowed list", zoneID)
+	if !s.isAllowed(ctx, zoneID) {
+		return model.HostedZone{}, fmt.Errorf("zone %s is %w", zoneID, ErrZoneNotAllowed)
 	}
 
 	result, err := s.client.GetHostedZone(ctx, &route53.GetHostedZoneInput{
@@ -95,21 +251,20 @@ func (s *DNSService) GetZone(ctx context.Context, zoneID string) (model.HostedZo
 		return model.HostedZone{}, err
 	}
 
-	return model.HostedZone{
+	return s.access(ctx).settings(model.HostedZone{
 		ID:          zoneID,
 		Name:        *result.HostedZone.Name,
 		RecordCount: *result.HostedZone.ResourceRecordSetCount,
 		Comment:     safeComment(result.HostedZone.Config),
-		Label:       s.allowedZones[zoneID],
-	}, nil
+	}).HostedZone, nil
 }
 
 func (s *DNSService) ListRecords(ctx context.Context, zoneID string) ([]model.DNSRecord, error) {
-	if !s.isAllowed(zoneID) {
-		return nil, fmt.Errorf("zone %s is not in the allowed list", zoneID)
+	if !s.isAllowed(ctx, zoneID) {
+		return nil, fmt.Errorf("zone %s is %w", zoneID, ErrZoneNotAllowed)
 	}
 
-	if records, ok := s.db.GetCachedRecords(zoneID); ok {
+	if records, ok := s.db.GetCachedRecords(ctx, zoneID); ok {
 		return records, nil
 	}
 
@@ -132,25 +287,7 @@ func (s *DNSService) ListRecords(ctx context.Context, zoneID string) ([]model.DN
 		}
 
 		for _, rrs := range result.ResourceRecordSets {
-			rec := model.DNSRecord{
-				Name: unescapeRoute53(*rrs.Name),
-				Type: string(rrs.Type),
-			}
-
-			if rrs.AliasTarget != nil {
-				rec.IsAlias = true
-				rec.AliasTarget = unescapeRoute53(*rrs.AliasTarget.DNSName)
-				rec.AliasZoneID = *rrs.AliasTarget.HostedZoneId
-			} else {
-				if rrs.TTL != nil {
-					rec.TTL = *rrs.TTL
-				}
-				for _, r := range rrs.ResourceRecords {
-					rec.Values = append(rec.Values, unescapeRoute53(*r.Value))
-				}
-			}
-
-			records = append(records, rec)
+			records = append(records, fromResourceRecordSet(rrs))
 		}
 
 		if !result.IsTruncated {
@@ -160,69 +297,276 @@ func (s *DNSService) ListRecords(ctx context.Context, zoneID string) ([]model.DN
 		nextType = result.NextRecordType
 	}
 
-	_ = s.db.CacheRecords(zoneID, records)
+	if err := s.db.CacheRecords(ctx, zoneID, records); err != nil {
+		slog.WarnContext(ctx, "caching records failed", "zone_id", zoneID, "err", err)
+	}
 	return records, nil
 }
 
-func (s *DNSService) ChangeRecord(ctx context.Context, zoneID string, req model.RecordChangeRequest) error {
-	if !s.isAllowed(zoneID) {
-		return fmt.Errorf("zone %s is not in the allowed list", zoneID)
+// maxCommentLength is the longest change batch comment Route53 accepts.
+const maxCommentLength = 256
+
+// ChangeRecord submits a single change and returns the Route53 change ID.
+func (s *DNSService) ChangeRecord(ctx context.Context, zoneID string, req model.RecordChangeRequest) (string, error) {
+	rec := model.DNSRecord{Name: req.Name, Type: req.Type, TTL: req.TTL, Values: req.Values}
+	verb := map[string]string{"CREATE": "Create", "UPSERT": "Update", "DELETE": "Delete"}[req.Action]
+	return s.ApplyChanges(ctx, zoneID, ChangeComment(verb, rec), []model.RecordChange{{Action: req.Action, Record: rec}})
+}
+
+// ChangeComment describes a change to one record set for the comment of
+// its change batch, e.g. "Create www.example.com. A".
+func ChangeComment(verb string, rec model.DNSRecord) string {
+	s := verb + " " + rec.Name + " " + rec.Type
+	if rec.SetIdentifier != "" {
+		s += " [" + rec.SetIdentifier + "]"
 	}
+	return s
+}
 
-	var action types.ChangeAction
-	switch req.Action {
-	case "CREATE":
-		action = types.ChangeActionCreate
-	case "UPSERT":
-		action = types.ChangeActionUpsert
-	case "DELETE":
-		action = types.ChangeActionDelete
-	default:
-		return fmt.Errorf("invalid action: %s", req.Action)
+// ApplyChanges submits the changes as one atomic change batch and returns
+// the Route53 change ID. The comment is stored with the change and becomes
+// the message of its commit in the zone history.
+func (s *DNSService) ApplyChanges(ctx context.Context, zoneID, comment string, changes []model.RecordChange) (string, error) {
+	if !s.isAllowed(ctx, zoneID) {
+		return "", fmt.Errorf("zone %s is %w", zoneID, ErrZoneNotAllowed)
 	}
 
-	var resourceRecords []types.ResourceRecord
-	for _, v := range req.Values {
-		resourceRecords = append(resourceRecords, types.ResourceRecord{
-			Value: aws.String(v),
-		})
+	batch := make([]types.Change, 0, len(changes))
+	for _, c := range changes {
+		var action types.ChangeAction
+		switch c.Action {
+		case "CREATE":
+			action = types.ChangeActionCreate
+		case "UPSERT":
+			action = types.ChangeActionUpsert
+		case "DELETE":
+			action = types.ChangeActionDelete
+		default:
+			return "", fmt.Errorf("invalid action: %s", c.Action)
+		}
+		batch = append(batch, types.Change{Action: action, ResourceRecordSet: toResourceRecordSet(c.Record)})
 	}
 
-	_, err := s.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+	if comment == "" {
+		comment = "Changed via NS116"
+	}
+	if len(comment) > maxCommentLength {
+		comment = strings.ToValidUTF8(comment[:maxCommentLength-3], "") + "..."
+	}
+	out, err := s.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: aws.String(zoneID),
 		ChangeBatch: &types.ChangeBatch{
-			Comment: aws.String("Changed via NS116"),
-			Changes: []types.Change{
-				{
-					Action: action,
-					ResourceRecordSet: &types.ResourceRecordSet{
-						Name:            aws.String(req.Name),
-						Type:            types.RRType(req.Type),
-						TTL:             aws.Int64(req.TTL),
-						ResourceRecords: resourceRecords,
-					},
-				},
-			},
+			Comment: aws.String(comment),
+			Changes: batch,
 		},
 	})
 
-	s.db.InvalidateRecordCache(zoneID)
-	return err
+	s.invalidate(ctx, zoneID)
+	if err != nil {
+		return "", err
+	}
+	return extractZoneID(aws.ToString(out.ChangeInfo.Id)), nil
+}
+
+// GetChange reports whether a change batch has propagated to all Route53
+// name servers. Change IDs are not tied to a zone, so any caller may look
+// one up.
+func (s *DNSService) GetChange(ctx context.Context, changeID string) (model.ChangeInfo, error) {
+	out, err := s.client.GetChange(ctx, &route53.GetChangeInput{Id: aws.String(changeID)})
+	if err != nil {
+		return model.ChangeInfo{}, err
+	}
+	return model.ChangeInfo{
+		ID:          extractZoneID(aws.ToString(out.ChangeInfo.Id)),
+		Status:      string(out.ChangeInfo.Status),
+		SubmittedAt: aws.ToTime(out.ChangeInfo.SubmittedAt),
+		Comment:     aws.ToString(out.ChangeInfo.Comment),
+	}, nil
+}
+
+// GetRecord reads a single record set straight from Route53, bypassing the
+// cache. It returns nil if no record set matches.
+func (s *DNSService) GetRecord(ctx context.Context, zoneID, name, recordType, setIdentifier string) (*model.DNSRecord, error) {
+	if !s.isAllowed(ctx, zoneID) {
+		return nil, fmt.Errorf("zone %s is %w", zoneID, ErrZoneNotAllowed)
+	}
+
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(name),
+		StartRecordType: types.RRType(recordType),
+	}
+	for {
+		result, err := s.client.ListResourceRecordSets(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		// Results are sorted by name and type, so the first set that does
+		// not match means there is nothing left to find
+		for _, rrs := range result.ResourceRecordSets {
+			rec := fromResourceRecordSet(rrs)
+			if !sameName(rec.Name, name) || rec.Type != recordType {
+				return nil, nil
+			}
+			if rec.SetIdentifier == setIdentifier {
+				return &rec, nil
+			}
+		}
+		if !result.IsTruncated {
+			return nil, nil
+		}
+		input.StartRecordName = result.NextRecordName
+		input.StartRecordType = result.NextRecordType
+		input.StartRecordIdentifier = result.NextRecordIdentifier
+	}
+}
+
+// CurrentRecord returns the authoritative current state of a record set,
+// or ErrRecordNotFound after dropping the zone cache.
+func (s *DNSService) CurrentRecord(ctx context.Context, zoneID, name, recordType, setIdentifier string) (*model.DNSRecord, error) {
+	rec, err := s.GetRecord(ctx, zoneID, name, recordType, setIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		s.invalidate(ctx, zoneID)
+		return nil, ErrRecordNotFound
+	}
+	return rec, nil
 }
 
-func (s *DNSService) isAllowed(zoneID string) bool {
-	if len(s.allowedZones) == 0 {
-		return true
+// ResolveRecord returns the authoritative current state of a record set and
+// verifies it still matches the version the client based its change on. A
+// missing version counts as a stale page, so a form posted without one
+// cannot overwrite a change made since. On a mismatch the zone cache is
+// dropped so the next page load is fresh.
+func (s *DNSService) ResolveRecord(ctx context.Context, zoneID, name, recordType, setIdentifier, version string) (*model.DNSRecord, error) {
+	rec, err := s.CurrentRecord(ctx, zoneID, name, recordType, setIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" || rec.Version() != version {
+		s.invalidate(ctx, zoneID)
+		return rec, ErrRecordChanged
 	}
-	_, ok := s.allowedZones[zoneID]
-	return ok
+	return rec, nil
 }
 
+// InvalidateRecords drops the cached records of a zone, so the next
+// ListRecords reads them from Route53.
+func (s *DNSService) InvalidateRecords(ctx context.Context, zoneID string) {
+	s.invalidate(ctx, zoneID)
+}
+
+// invalidate drops the cached records of a zone. A failure only means stale
+// data until the cache expires, so it is logged rather than returned.
+func (s *DNSService) invalidate(ctx context.Context, zoneID string) {
+	if err := s.db.InvalidateRecordCache(ctx, zoneID); err != nil {
+		slog.ErrorContext(ctx, "invalidating record cache failed", "zone_id", zoneID, "err", err)
+	}
+}
+
+func (s *DNSService) isAllowed(ctx context.Context, zoneID string) bool {
+	return s.access(ctx).allowed(zoneID)
+}
+
+// extractZoneID strips the resource prefix from Route53 IDs such as
+// "/hostedzone/Z123" or "/change/C456".
 func extractZoneID(fullID string) string {
 	parts := strings.Split(fullID, "/")
 	return parts[len(parts)-1]
 }
 
+// fromResourceRecordSet converts a record set read from Route53. Names and
+// alias targets are decoded; values stay exactly as Route53 stores them, so
+// they match when sent back in a DELETE or UPSERT.
+func fromResourceRecordSet(rrs types.ResourceRecordSet) model.DNSRecord {
+	rec := model.DNSRecord{
+		Name:          UnescapeRoute53(aws.ToString(rrs.Name)),
+		Type:          string(rrs.Type),
+		SetIdentifier: aws.ToString(rrs.SetIdentifier),
+	}
+
+	if rrs.AliasTarget != nil {
+		rec.IsAlias = true
+		rec.AliasTarget = UnescapeRoute53(aws.ToString(rrs.AliasTarget.DNSName))
+		rec.AliasZoneID = aws.ToString(rrs.AliasTarget.HostedZoneId)
+		rec.EvaluateTargetHealth = rrs.AliasTarget.EvaluateTargetHealth
+	} else {
+		if rrs.TTL != nil {
+			rec.TTL = *rrs.TTL
+		}
+		for _, r := range rrs.ResourceRecords {
+			rec.Values = append(rec.Values, aws.ToString(r.Value))
+		}
+	}
+
+	rec.Weight = rrs.Weight
+	rec.Region = string(rrs.Region)
+	rec.Failover = string(rrs.Failover)
+	rec.MultiValueAnswer = aws.ToBool(rrs.MultiValueAnswer)
+	rec.HealthCheckID = aws.ToString(rrs.HealthCheckId)
+	if g := rrs.GeoLocation; g != nil {
+		rec.GeoLocation = &model.GeoLocation{
+			ContinentCode:   aws.ToString(g.ContinentCode),
+			CountryCode:     aws.ToString(g.CountryCode),
+			SubdivisionCode: aws.ToString(g.SubdivisionCode),
+		}
+	}
+	return rec
+}
+
+func toResourceRecordSet(rec model.DNSRecord) *types.ResourceRecordSet {
+	rrs := &types.ResourceRecordSet{
+		Name: aws.String(rec.Name),
+		Type: types.RRType(rec.Type),
+	}
+
+	if rec.IsAlias {
+		rrs.AliasTarget = &types.AliasTarget{
+			DNSName:              aws.String(rec.AliasTarget),
+			HostedZoneId:         aws.String(rec.AliasZoneID),
+			EvaluateTargetHealth: rec.EvaluateTargetHealth,
+		}
+	} else {
+		rrs.TTL = aws.Int64(rec.TTL)
+		for _, v := range rec.Values {
+			rrs.ResourceRecords = append(rrs.ResourceRecords, types.ResourceRecord{Value: aws.String(v)})
+		}
+	}
+
+	if rec.SetIdentifier != "" {
+		rrs.SetIdentifier = aws.String(rec.SetIdentifier)
+	}
+	rrs.Weight = rec.Weight
+	rrs.Region = types.ResourceRecordSetRegion(rec.Region)
+	rrs.Failover = types.ResourceRecordSetFailover(rec.Failover)
+	if rec.MultiValueAnswer {
+		rrs.MultiValueAnswer = aws.Bool(true)
+	}
+	if rec.HealthCheckID != "" {
+		rrs.HealthCheckId = aws.String(rec.HealthCheckID)
+	}
+	if g := rec.GeoLocation; g != nil {
+		rrs.GeoLocation = &types.GeoLocation{}
+		if g.ContinentCode != "" {
+			rrs.GeoLocation.ContinentCode = aws.String(g.ContinentCode)
+		}
+		if g.CountryCode != "" {
+			rrs.GeoLocation.CountryCode = aws.String(g.CountryCode)
+		}
+		if g.SubdivisionCode != "" {
+			rrs.GeoLocation.SubdivisionCode = aws.String(g.SubdivisionCode)
+		}
+	}
+	return rrs
+}
+
+// sameName compares DNS names case-insensitively, ignoring the trailing dot.
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
 func safeComment(cfg *types.HostedZoneConfig) string {
 	if cfg != nil && cfg.Comment != nil {
 		return *cfg.Comment
@@ -230,14 +574,17 @@ func safeComment(cfg *types.HostedZoneConfig) string {
 	return ""
 }
 
-func unescapeRoute53(s string) string {
+// UnescapeRoute53 decodes the \ddd octal escapes Route53 returns for
+// characters outside letters, digits, hyphen and underscore, e.g.
+// "\052.example.com." for the wildcard "*.example.com.".
+func UnescapeRoute53(s string) string {
 	if !strings.Contains(s, `\`) {
 		return s
 	}
 	var out strings.Builder
 	for i := 0; i < len(s); i++ {
-		if s[i] == '\\' && i+3 < len(s) {
-			if v, err := strconv.ParseInt(s[i+1:i+4], 8, 32); err == nil {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1:i+4]) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
 				out.WriteByte(byte(v))
 				i += 3
 				continue
@@ -247,3 +594,12 @@ func unescapeRoute53(s string) string {
 	}
 	return out.String()
 }
+
+func isOctal(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '7' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+
+	"ns116/internal/model"
+)
+
+func TestZoneAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     map[string]string
+		managed    map[string]model.ManagedZone
+		restricted bool
+		allowed    map[string]bool
+	}{
+		{
+			name:    "nothing enabled yet shows every zone",
+			allowed: map[string]bool{"Z1": true, "Z2": true},
+		},
+		{
+			name:    "labels alone do not restrict",
+			managed: map[string]model.ManagedZone{"Z1": {ZoneID: "Z1", Label: "prod"}},
+			allowed: map[string]bool{"Z1": true, "Z2": true},
+		},
+		{
+			name:       "enabled managed zone",
+			managed:    map[string]model.ManagedZone{"Z1": {ZoneID: "Z1", Enabled: true}},
+			restricted: true,
+			allowed:    map[string]bool{"Z1": true, "Z2": false},
+		},
+		{
+			name:    "hosted_zones",
+			config:  map[string]string{"Z2": ""},
+			allowed: map[string]bool{"Z1": false, "Z2": true},
+		},
+		{
+			name:       "last enabled zone disabled",
+			managed:    map[string]model.ManagedZone{"Z1": {ZoneID: "Z1", Enabled: false}},
+			restricted: true,
+			allowed:    map[string]bool{"Z1": false, "Z2": false},
+		},
+		{
+			name:       "last enabled zone disabled with hosted_zones",
+			config:     map[string]string{"Z2": "internal"},
+			managed:    map[string]model.ManagedZone{"Z1": {ZoneID: "Z1"}},
+			restricted: true,
+			allowed:    map[string]bool{"Z1": false, "Z2": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newZoneAccess(tt.config, tt.managed, tt.restricted)
+			for zoneID, want := range tt.allowed {
+				if got := a.allowed(zoneID); got != want {
+					t.Errorf("allowed(%s) = %t, want %t", zoneID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnescapeRoute53(t *testing.T) {
+	tests := map[string]string{
+		"www.example.com.":           "www.example.com.",
+		`\052.example.com.`:          "*.example.com.",
+		`a\100b.example.com.`:        "a@b.example.com.",
+		`\052`:                       "*",
+		`trailing\05`:                `trailing\05`,
+		`\+12.example.com.`:          `\+12.example.com.`,
+		`\999.example.com.`:          `\999.example.com.`,
+		`"v=spf1 include:\052 -all"`: `"v=spf1 include:* -all"`,
+	}
+	for in, want := range tests {
+		if got := UnescapeRoute53(in); got != want {
+			t.Errorf("UnescapeRoute53(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestResourceRecordSetRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   types.ResourceRecordSet
+		want model.DNSRecord
+	}{
+		{
+			name: "escaped values are kept",
+			in: types.ResourceRecordSet{
+				Name: aws.String(`\052.example.com.`),
+				Type: types.RRTypeTxt,
+				TTL:  aws.Int64(300),
+				ResourceRecords: []types.ResourceRecord{
+					{Value: aws.String(`"v=spf1 ip4:192.0.2.0/24 \134 -all"`)},
+					{Value: aws.String(`"caf\303\251"`)},
+				},
+			},
+			want: model.DNSRecord{
+				Name:   "*.example.com.",
+				Type:   "TXT",
+				TTL:    300,
+				Values: []string{`"v=spf1 ip4:192.0.2.0/24 \134 -all"`, `"caf\303\251"`},
+			},
+		},
+		{
+			name: "alias target",
+			in: types.ResourceRecordSet{
+				Name: aws.String("www.example.com."),
+				Type: types.RRTypeA,
+				AliasTarget: &types.AliasTarget{
+					DNSName:      aws.String(`\052.cdn.example.net.`),
+					HostedZoneId: aws.String("Z2FDTNDATAQYW2"),
+				},
+			},
+			want: model.DNSRecord{
+				Name:        "www.example.com.",
+				Type:        "A",
+				IsAlias:     true,
+				AliasTarget: "*.cdn.example.net.",
+				AliasZoneID: "Z2FDTNDATAQYW2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := fromResourceRecordSet(tt.in)
+			if !reflect.DeepEqual(rec, tt.want) {
+				t.Fatalf("fromResourceRecordSet = %+v, want %+v", rec, tt.want)
+			}
+			out := toResourceRecordSet(rec)
+			if len(out.ResourceRecords) != len(tt.in.ResourceRecords) {
+				t.Fatalf("%d values sent back, want %d", len(out.ResourceRecords), len(tt.in.ResourceRecords))
+			}
+			for i, r := range out.ResourceRecords {
+				if got, want := aws.ToString(r.Value), aws.ToString(tt.in.ResourceRecords[i].Value); got != want {
+					t.Errorf("value %d sent back as %q, want %q as Route53 stores it", i, got, want)
+				}
+			}
+			if rec.IsAlias && aws.ToString(out.AliasTarget.DNSName) != rec.AliasTarget {
+				t.Errorf("alias target = %q", aws.ToString(out.AliasTarget.DNSName))
+			}
+		})
+	}
+}
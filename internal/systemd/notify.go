@@ -0,0 +1,60 @@
+// Package systemd implements the parts of the sd_notify protocol NS116 uses
+// to run as a Type=notify service with a watchdog. Everything is a no-op
+// when the process was not started by systemd.
+package systemd
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends state (e.g. "READY=1") to the service manager. It does
+// nothing when NOTIFY_SOCKET is unset.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading "@" names an abstract socket, which net handles itself
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often the service manager expects a
+// keep-alive: half of WatchdogSec=, or 0 when the watchdog is off or meant
+// for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// Watchdog sends keep-alives every interval until ctx is done.
+func Watchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Notify("WATCHDOG=1"); err != nil {
+				slog.Warn("systemd watchdog notification failed", "err", err)
+			}
+		}
+	}
+}
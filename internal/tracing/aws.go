@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AWSMiddleware records a client span per AWS API operation, covering all of
+// its retries, and an event for every failed attempt. Add it to the
+// client's APIOptions.
+func AWSMiddleware(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NS116Tracing",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			service := strings.ReplaceAll(awsmiddleware.GetServiceID(ctx), " ", "")
+			op := awsmiddleware.GetOperationName(ctx)
+			ctx, span := Tracer().Start(ctx, service+"."+op,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.RPCSystemNameKey.String("aws-api"),
+					semconv.RPCMethod(service+"/"+op),
+				))
+			defer span.End()
+
+			out, md, err := next.HandleInitialize(ctx, in)
+			if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+				span.SetAttributes(semconv.AWSRequestID(id))
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return out, md, err
+		}), middleware.After)
+	if err != nil {
+		return err
+	}
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("NS116TracingAttempt",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, md, err := next.HandleFinalize(ctx, in)
+			if err != nil {
+				trace.SpanFromContext(ctx).AddEvent("attempt failed", trace.WithAttributes(
+					attribute.String("error", err.Error())))
+			}
+			return out, md, err
+		}), middleware.After)
+}
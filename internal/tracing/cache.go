@@ -0,0 +1,19 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CacheLookup starts a span for a lookup in the named DNS cache. The
+// returned function records whether it was a hit and ends the span.
+func CacheLookup(ctx context.Context, cache string, attrs ...attribute.KeyValue) (context.Context, func(hit bool)) {
+	ctx, span := Tracer().Start(ctx, "cache "+cache)
+	span.SetAttributes(attribute.String("ns116.cache", cache))
+	span.SetAttributes(attrs...)
+	return ctx, func(hit bool) {
+		span.SetAttributes(attribute.Bool("ns116.cache.hit", hit))
+		span.End()
+	}
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"ns116/internal/util"
+)
+
+// Middleware records a server span per request, continuing a trace started
+// by the caller when it sends a traceparent header. Spans are named after
+// the ServeMux pattern that matched, like the metrics. Static assets, metric
+// scrapes and health probes are not traced.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/static/"), r.URL.Path == "/metrics", r.URL.Path == "/healthz", r.URL.Path == "/readyz":
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(util.GetClientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			))
+		defer span.End()
+
+		r, matched := util.WithRoute(r.WithContext(ctx))
+		rec := &util.ResponseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if route := matched(); route != "" {
+			if !strings.Contains(route, " ") {
+				route = r.Method + " " + route
+			}
+			span.SetName(route)
+			span.SetAttributes(semconv.HTTPRoute(route[strings.Index(route, " ")+1:]))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status()))
+		if rec.Status() >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
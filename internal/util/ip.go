@@ -1,39 +1,159 @@
 package util
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync/atomic"
 )
 
-// GetClientIP extracts the true client IP address from a request,
-// considering X-Forwarded-For headers if present (e.g. from Ingress/Nginx).
+type clientIPKey struct{}
+
+// ClientIP resolves the client address of requests that pass through
+// trusted reverse proxies. Only header, the one the proxies set
+// (X-Forwarded-For, Forwarded or X-Real-IP), is read, and only when the
+// connection comes from one of them; any other forwarding header may have
+// been passed through from the client. The chain is walked right to left and
+// the first address that is not itself a trusted proxy is the client, so
+// entries a client prepends are ignored.
+type ClientIP struct {
+	cfg atomic.Pointer[clientIPConfig]
+}
+
+type clientIPConfig struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+func NewClientIP(trusted []netip.Prefix, header string) *ClientIP {
+	c := &ClientIP{}
+	c.Configure(trusted, header)
+	return c
+}
+
+// Configure replaces the trusted proxies and their header for requests from
+// now on.
+func (c *ClientIP) Configure(trusted []netip.Prefix, header string) {
+	c.cfg.Store(&clientIPConfig{trusted: trusted, header: http.CanonicalHeaderKey(header)})
+}
+
+// Middleware resolves the client address of every request once and stores
+// it for GetClientIP.
+func (c *ClientIP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := c.cfg.Load()
+		ip := resolveClientIP(r, cfg.trusted, cfg.header)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// FromTrustedProxy reports whether the request's connection comes from one
+// of the trusted proxies.
+func (c *ClientIP) FromTrustedProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddr(peerAddr(r.RemoteAddr))
+	return err == nil && isTrusted(addr, c.cfg.Load().trusted)
+}
+
+// GetClientIP returns the client address resolved by ClientIP.Middleware,
+// or the connection's peer address outside of it.
 func GetClientIP(r *http.Request) string {
-	// 1. Check X-Forwarded-For (standard for proxies)
-	// Format: client, proxy1, proxy2
-	xForwardedFor := r.Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// Taking the first IP in the list as the client IP
-		ips := strings.Split(xForwardedFor, ",")
-		if len(ips) > 0 {
-			clientIP := strings.TrimSpace(ips[0])
-			if clientIP != "" {
-				return clientIP
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerAddr(r.RemoteAddr)
+}
+
+func resolveClientIP(r *http.Request, trusted []netip.Prefix, header string) string {
+	peer := peerAddr(r.RemoteAddr)
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !isTrusted(addr, trusted) {
+		return peer
+	}
+
+	var hops []string
+	switch values := r.Header.Values(header); header {
+	case "Forwarded":
+		hops = forwardedFor(values)
+	case "X-Real-Ip":
+		if real := strings.TrimSpace(r.Header.Get(header)); real != "" {
+			hops = []string{real}
+		}
+	default:
+		for _, h := range values {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+	}
+
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseHop(hops[i])
+		if err != nil {
+			// An obfuscated or malformed entry ends the chain; the proxy
+			// that added it is the closest known hop
+			break
+		}
+		client = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for= parameter of every Forwarded element, in
+// order. Elements without one yield an empty hop.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hop = strings.Trim(val, `"`)
+				}
 			}
+			hops = append(hops, hop)
 		}
 	}
+	return hops
+}
 
-	// 2. Check X-Real-IP (common alternative)
-	xRealIP := r.Header.Get("X-Real-IP")
-	if xRealIP != "" {
-		return strings.TrimSpace(xRealIP)
+// parseHop parses one forwarding entry: a bare address, an IPv4 address
+// with port, or a bracketed IPv6 address with optional port.
+func parseHop(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return netip.Addr{}, net.InvalidAddrError(s)
+		}
+		s = s[1:end]
+	} else if strings.Count(s, ":") == 1 {
+		s, _, _ = strings.Cut(s, ":")
 	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
 
-	// 3. Fallback to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		return ip
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
 	}
+	return false
+}
 
-	return r.RemoteAddr
+func peerAddr(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
 }
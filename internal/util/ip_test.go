@@ -0,0 +1,204 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	tests := []struct {
+		name    string
+		peer    string
+		header  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name: "untrusted peer ignores header",
+			peer: "198.51.100.7:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "198.51.100.7",
+		},
+		{
+			name: "trusted peer without header",
+			peer: "10.0.0.1:4000",
+			want: "10.0.0.1",
+		},
+		{
+			name: "single hop",
+			peer: "10.0.0.1:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "spoofed entry prepended by the client",
+			peer: "10.0.0.1:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"192.0.2.66, 203.0.113.1"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "multiple trusted hops",
+			peer: "10.0.0.1:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"192.0.2.66, 203.0.113.1, 10.1.2.3", "10.0.0.9"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "only trusted hops",
+			peer: "10.0.0.1:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.1.2.3, 10.0.0.9"},
+			},
+			want: "10.1.2.3",
+		},
+		{
+			name: "malformed hop ends the chain",
+			peer: "10.0.0.1:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.1, garbage, 10.0.0.9"},
+			},
+			want: "10.0.0.9",
+		},
+		{
+			name: "client Forwarded header passed through is ignored",
+			peer: "10.0.0.1:4000",
+			headers: map[string][]string{
+				"Forwarded":       {"for=192.0.2.66"},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name:   "Forwarded when configured",
+			peer:   "10.0.0.1:4000",
+			header: "forwarded",
+			headers: map[string][]string{
+				"Forwarded":       {`for=192.0.2.66, for="[2001:db8::1]:443";proto=https, for=203.0.113.1:5000`},
+				"X-Forwarded-For": {"192.0.2.77"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name:   "Forwarded multi-hop through trusted IPv6 proxy",
+			peer:   "[2001:db8::2]:4000",
+			header: "Forwarded",
+			headers: map[string][]string{
+				"Forwarded": {"for=192.0.2.66", `for="[2001:db8::1]"`},
+			},
+			want: "192.0.2.66",
+		},
+		{
+			name:   "Forwarded obfuscated hop",
+			peer:   "10.0.0.1:4000",
+			header: "Forwarded",
+			headers: map[string][]string{
+				"Forwarded": {"for=192.0.2.66, for=_hidden"},
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name:   "X-Real-IP when configured",
+			peer:   "10.0.0.1:4000",
+			header: "X-Real-IP",
+			headers: map[string][]string{
+				"X-Real-Ip":       {" 203.0.113.1 "},
+				"X-Forwarded-For": {"192.0.2.66"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "IPv4-mapped peer",
+			peer: "[::ffff:10.0.0.1]:4000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "203.0.113.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = "X-Forwarded-For"
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for k, vs := range tt.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+			var got string
+			h := NewClientIP(trusted, header).Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = GetClientIP(r)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPConfigure(t *testing.T) {
+	c := NewClientIP(nil, "X-Forwarded-For")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+	if c.FromTrustedProxy(r) || resolveClientIP(r, nil, "X-Forwarded-For") != "10.0.0.1" {
+		t.Fatal("untrusted peer treated as a proxy")
+	}
+
+	c.Configure([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "x-forwarded-for")
+	if !c.FromTrustedProxy(r) {
+		t.Error("proxy added by Configure is not trusted")
+	}
+	var got string
+	c.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = GetClientIP(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "203.0.113.1" {
+		t.Errorf("client IP after Configure = %q", got)
+	}
+}
+
+func TestParseHop(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"203.0.113.1", "203.0.113.1", true},
+		{" 203.0.113.1:8080 ", "203.0.113.1", true},
+		{"2001:db8::1", "2001:db8::1", true},
+		{"[2001:db8::1]", "2001:db8::1", true},
+		{"[2001:db8::1]:443", "2001:db8::1", true},
+		{"::ffff:192.0.2.1", "192.0.2.1", true},
+		{"[2001:db8::1", "", false},
+		{"unknown", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseHop(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseHop(%q) error = %v, want ok %t", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && got.String() != tt.want {
+			t.Errorf("parseHop(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
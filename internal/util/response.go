@@ -0,0 +1,45 @@
+package util
+
+import "net/http"
+
+// ResponseRecorder captures the status code and size of a response for
+// middleware. It exposes the wrapped writer through Unwrap so
+// http.ResponseController can still flush streamed exports.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Code  int
+	Bytes int64
+}
+
+func (s *ResponseRecorder) WriteHeader(code int) {
+	if s.Code == 0 {
+		s.Code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *ResponseRecorder) Write(b []byte) (int, error) {
+	if s.Code == 0 {
+		s.Code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.Bytes += int64(n)
+	return n, err
+}
+
+func (s *ResponseRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *ResponseRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// Status returns the recorded status code; a handler that wrote nothing
+// answered 200.
+func (s *ResponseRecorder) Status() int {
+	if s.Code == 0 {
+		return http.StatusOK
+	}
+	return s.Code
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"context"
+	"net/http"
+)
+
+type routeKey struct{}
+
+// WithRoute returns a request that collects the ServeMux pattern matched
+// further down the handler chain, and a function returning it once the
+// request has been served. Middleware that replaces the request to add
+// context values would otherwise hide r.Pattern from everything outside it.
+func WithRoute(r *http.Request) (*http.Request, func() string) {
+	if p, ok := r.Context().Value(routeKey{}).(*string); ok {
+		return r, func() string { return *p }
+	}
+	p := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, p)), func() string { return *p }
+}
+
+// RecordRoute reports the pattern matched by mux to WithRoute callers. Nested
+// muxes update the same request, so the innermost match wins.
+func RecordRoute(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if p, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*p = r.Pattern
+		}
+	})
+}
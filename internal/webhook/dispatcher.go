@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ns116/internal/database"
+	"ns116/internal/model"
+)
+
+const (
+	pollInterval  = 2 * time.Second
+	claimBatch    = 50
+	leaseSeconds  = 60
+	maxAttempts   = 10
+	keepDays      = 30
+	pruneInterval = time.Hour
+)
+
+// Dispatcher sends queued deliveries and records each attempt. Several
+// instances can run against the same database; claimed deliveries are
+// leased so each is sent by one of them.
+type Dispatcher struct {
+	db     *database.DB
+	client *http.Client
+}
+
+func NewDispatcher(db *database.DB) *Dispatcher {
+	return &Dispatcher{db: db, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Run delivers due webhooks until ctx is cancelled. A batch in progress
+// when that happens is finished.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	work := context.WithoutCancel(ctx)
+
+	for {
+		if time.Since(lastPrune) > pruneInterval {
+			if err := d.db.PruneWebhookDeliveries(work, keepDays); err != nil {
+				slog.Warn("pruning webhook deliveries failed", "err", err)
+			}
+			lastPrune = time.Now()
+		}
+		d.deliverDue(work)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	deliveries, err := d.db.ClaimWebhookDeliveries(ctx, claimBatch, leaseSeconds)
+	if err != nil {
+		slog.Warn("claiming webhook deliveries failed", "err", err)
+		return
+	}
+
+	subs := make(map[int]*model.WebhookSubscription)
+	for _, del := range deliveries {
+		sub, ok := subs[del.SubscriptionID]
+		if !ok {
+			if sub, err = d.db.GetWebhook(ctx, del.SubscriptionID); err != nil {
+				slog.Warn("loading webhook failed", "webhook_id", del.SubscriptionID, "err", err)
+				continue
+			}
+			subs[del.SubscriptionID] = sub
+		}
+		if sub == nil {
+			continue
+		}
+
+		code, sendErr := d.send(ctx, sub, del)
+		if err := d.db.RecordWebhookAttempt(ctx, del.ID, code, sendErr, maxAttempts); err != nil {
+			slog.Warn("recording webhook delivery failed", "delivery_id", del.ID, "err", err)
+		}
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, sub *model.WebhookSubscription, del model.WebhookDelivery) (int, error) {
+	body, err := Render(sub.Format, del.Payload)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NS116-Webhook")
+	req.Header.Set("X-NS116-Event", del.Event)
+	req.Header.Set("X-NS116-Delivery", strconv.FormatInt(del.ID, 10))
+	req.Header.Set("X-NS116-Timestamp", timestamp)
+	if sub.Secret != "" {
+		req.Header.Set("X-NS116-Signature", Sign(sub.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+func TestDispatcherSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		secret  string
+		format  string
+		wantErr string
+	}{
+		{name: "signed json", status: http.StatusNoContent, secret: "whsec", format: model.WebhookFormatJSON},
+		{name: "unsigned slack", status: http.StatusOK, format: model.WebhookFormatSlack},
+		{name: "endpoint error", status: http.StatusBadGateway, secret: "whsec", wantErr: "502 Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			d := &Dispatcher{client: srv.Client()}
+			sub := &model.WebhookSubscription{URL: srv.URL, Secret: tt.secret, Format: tt.format}
+			payload, _ := json.Marshal(model.WebhookEvent{Event: "login", Actor: "alice"})
+			del := model.WebhookDelivery{ID: 42, Event: "login", Payload: payload}
+
+			code, err := d.send(context.Background(), sub, del)
+			if code != tt.status {
+				t.Errorf("code = %d, want %d", code, tt.status)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Header.Get("Content-Type") != "application/json" || got.Header.Get("X-NS116-Event") != "login" ||
+				got.Header.Get("X-NS116-Delivery") != "42" {
+				t.Errorf("headers = %v", got.Header)
+			}
+			want, _ := Render(tt.format, payload)
+			if string(body) != string(want) {
+				t.Errorf("body = %s, want %s", body, want)
+			}
+			sig := got.Header.Get("X-NS116-Signature")
+			if tt.secret == "" {
+				if sig != "" {
+					t.Errorf("signed without a secret: %s", sig)
+				}
+			} else if sig != Sign(tt.secret, got.Header.Get("X-NS116-Timestamp"), body) {
+				t.Errorf("signature %q does not verify over the timestamp and body", sig)
+			}
+		})
+	}
+}
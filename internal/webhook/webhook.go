@@ -0,0 +1,99 @@
+// Package webhook delivers audit events to subscribed HTTP endpoints as
+// signed JSON or as Slack/Teams incoming-webhook messages.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ns116/internal/model"
+)
+
+// Events are the audit actions a subscription can filter on.
+var Events = []string{
+	"create_record", "edit_record", "delete_record",
+	"create_user", "delete_user", "update_user_role", "enable_user", "disable_user",
+	"reset_password", "change_password", "login", "logout",
+	"create_token", "revoke_token",
+}
+
+// Formats are the supported payload formats.
+var Formats = []string{model.WebhookFormatJSON, model.WebhookFormatSlack, model.WebhookFormatTeams}
+
+// NewSecret generates a random signing secret for a subscription.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the X-NS116-Signature value for a request body: HMAC-SHA256
+// over "<timestamp>.<body>" keyed with the subscription secret. Including
+// the timestamp lets receivers reject replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Render builds the request body for the subscription's format.
+func Render(format string, payload json.RawMessage) ([]byte, error) {
+	if format == model.WebhookFormatJSON || format == "" {
+		return payload, nil
+	}
+
+	var ev model.WebhookEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return nil, err
+	}
+	switch format {
+	case model.WebhookFormatSlack:
+		return json.Marshal(map[string]string{"text": Summary(ev, "*")})
+	case model.WebhookFormatTeams:
+		facts := []map[string]string{{"name": "Event", "value": ev.Event}, {"name": "By", "value": ev.Actor}}
+		if ev.ZoneID != "" {
+			facts = append(facts, map[string]string{"name": "Zone", "value": ev.ZoneID})
+		}
+		if ev.RecordName != "" {
+			facts = append(facts, map[string]string{"name": "Record", "value": ev.RecordName + " " + ev.RecordType})
+		}
+		if ev.Detail != "" {
+			facts = append(facts, map[string]string{"name": "Detail", "value": ev.Detail})
+		}
+		return json.Marshal(map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    Summary(ev, ""),
+			"themeColor": "2E7D32",
+			"title":      "NS116: " + ev.Event,
+			"sections":   []map[string]any{{"facts": facts}},
+		})
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", format)
+	}
+}
+
+// Summary describes the event in one line for chat messages. bold wraps the
+// actor, e.g. "*" for Slack mrkdwn.
+func Summary(ev model.WebhookEvent, bold string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s%s %s", bold, ev.Actor, bold, strings.ReplaceAll(ev.Event, "_", " "))
+	if ev.RecordName != "" {
+		fmt.Fprintf(&b, " %s %s", ev.RecordType, ev.RecordName)
+	}
+	if ev.ZoneID != "" {
+		fmt.Fprintf(&b, " in zone %s", ev.ZoneID)
+	}
+	if ev.Detail != "" {
+		fmt.Fprintf(&b, " (%s)", ev.Detail)
+	}
+	return b.String()
+}
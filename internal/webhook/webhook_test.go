@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+func TestSign(t *testing.T) {
+	// Receivers compute HMAC-SHA256 over "<X-NS116-Timestamp>.<body>"
+	got := Sign("whsec", "1700000000", []byte(`{"event":"login"}`))
+	if want := "sha256=0591f49e28e343c6d9164fc8118710d0e40b08e56efb4fed90502f534861bc99"; got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("whsec", "1700000001", []byte(`{"event":"login"}`)) == got {
+		t.Error("signature does not cover the timestamp")
+	}
+	if Sign("other", "1700000000", []byte(`{"event":"login"}`)) == got {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestRender(t *testing.T) {
+	ev := model.WebhookEvent{
+		Event:      "edit_record",
+		Actor:      "alice",
+		ZoneID:     "Z1",
+		RecordName: "www.example.com.",
+		RecordType: "A",
+		Detail:     "ttl=300",
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, _ := json.Marshal(model.WebhookEvent{Event: "login", Actor: "bob"})
+
+	tests := []struct {
+		name    string
+		format  string
+		payload json.RawMessage
+		want    any
+	}{
+		{
+			name:    "json passes the payload through",
+			format:  model.WebhookFormatJSON,
+			payload: payload,
+			want:    ev,
+		},
+		{
+			name:    "empty format is json",
+			payload: login,
+			want:    model.WebhookEvent{Event: "login", Actor: "bob"},
+		},
+		{
+			name:    "slack",
+			format:  model.WebhookFormatSlack,
+			payload: payload,
+			want:    map[string]any{"text": "*alice* edit record A www.example.com. in zone Z1 (ttl=300)"},
+		},
+		{
+			name:    "teams",
+			format:  model.WebhookFormatTeams,
+			payload: payload,
+			want: map[string]any{
+				"@type":      "MessageCard",
+				"@context":   "https://schema.org/extensions",
+				"summary":    "alice edit record A www.example.com. in zone Z1 (ttl=300)",
+				"themeColor": "2E7D32",
+				"title":      "NS116: edit_record",
+				"sections": []any{map[string]any{"facts": []any{
+					map[string]any{"name": "Event", "value": "edit_record"},
+					map[string]any{"name": "By", "value": "alice"},
+					map[string]any{"name": "Zone", "value": "Z1"},
+					map[string]any{"name": "Record", "value": "www.example.com. A"},
+					map[string]any{"name": "Detail", "value": "ttl=300"},
+				}}},
+			},
+		},
+		{
+			name:    "teams without a record",
+			format:  model.WebhookFormatTeams,
+			payload: login,
+			want: map[string]any{
+				"@type":      "MessageCard",
+				"@context":   "https://schema.org/extensions",
+				"summary":    "bob login",
+				"themeColor": "2E7D32",
+				"title":      "NS116: login",
+				"sections": []any{map[string]any{"facts": []any{
+					map[string]any{"name": "Event", "value": "login"},
+					map[string]any{"name": "By", "value": "bob"},
+				}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := Render(tt.format, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := reflect.New(reflect.TypeOf(tt.want)).Interface()
+			if err := json.Unmarshal(body, got); err != nil {
+				t.Fatalf("body %s: %v", body, err)
+			}
+			if !reflect.DeepEqual(reflect.ValueOf(got).Elem().Interface(), tt.want) {
+				t.Errorf("body = %s", body)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	if _, err := Render("irc", json.RawMessage(`{}`)); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if _, err := Render(model.WebhookFormatSlack, json.RawMessage(`not json`)); err == nil {
+		t.Error("invalid payload accepted")
+	}
+}
@@ -0,0 +1,269 @@
+package zonesync
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"ns116/internal/model"
+	"ns116/internal/service"
+)
+
+// ErrDuplicateRecord reports a record set listed more than once.
+var ErrDuplicateRecord = errors.New("listed more than once")
+
+// Route53 limits per change batch. UPSERTs count twice towards both.
+const (
+	maxBatchRecords = 1000
+	maxBatchChars   = 32000
+)
+
+// Plan reads the zone's record sets from Route53, bypassing the cache, and
+// compares desired with them.
+func Plan(ctx context.Context, r53 *service.DNSService, zone model.HostedZone, desired []model.DNSRecord, managedOnly bool) (*model.SyncPlan, error) {
+	r53.InvalidateRecords(ctx, zone.ID)
+	current, err := r53.ListRecords(ctx, zone.ID)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(zone, desired, current, managedOnly)
+}
+
+// Diff lists the changes that turn the current record sets of zone into
+// desired. Record sets missing from desired are deleted unless managedOnly
+// is set. The apex SOA and NS records belong to the hosted zone and are
+// never changed.
+//
+// Deletions come first, so a name can change type, and alias record sets
+// last, so their targets exist by the time they are created.
+func Diff(zone model.HostedZone, desired, current []model.DNSRecord, managedOnly bool) (*model.SyncPlan, error) {
+	plan := &model.SyncPlan{ZoneID: zone.ID, ZoneName: zone.Name, Changes: []model.PlannedChange{}, ManagedOnly: managedOnly}
+	existing := make(map[string]model.DNSRecord, len(current))
+	for _, rec := range current {
+		existing[recordKey(rec)] = rec
+	}
+
+	var deletes, upserts, aliases []model.PlannedChange
+	listed := make(map[string]bool, len(desired))
+	for _, rec := range desired {
+		if rec.IsAlias && rec.AliasZoneID == "" {
+			rec.AliasZoneID = zone.ID
+		}
+		key := recordKey(rec)
+		if listed[key] {
+			return nil, fmt.Errorf("%s is %w", describe(rec), ErrDuplicateRecord)
+		}
+		listed[key] = true
+		if zoneOwned(rec, zone.Name) {
+			continue
+		}
+
+		c := model.PlannedChange{Action: "CREATE", After: &rec}
+		if old, ok := existing[key]; ok {
+			if equal(old, rec) {
+				plan.Unchanged++
+				continue
+			}
+			rec.Name = old.Name // Route53's spelling
+			c = model.PlannedChange{Action: "UPDATE", Before: &old, After: &rec}
+		}
+		if rec.IsAlias {
+			aliases = append(aliases, c)
+		} else {
+			upserts = append(upserts, c)
+		}
+	}
+	for _, rec := range current {
+		if listed[recordKey(rec)] || zoneOwned(rec, zone.Name) {
+			continue
+		}
+		if managedOnly {
+			plan.Kept++
+			continue
+		}
+		deletes = append(deletes, model.PlannedChange{Action: "DELETE", Before: &rec})
+	}
+
+	for _, group := range [][]model.PlannedChange{deletes, upserts, aliases} {
+		slices.SortStableFunc(group, func(a, b model.PlannedChange) int {
+			return cmp.Compare(recordKey(a.Record()), recordKey(b.Record()))
+		})
+		plan.Changes = append(plan.Changes, group...)
+	}
+	return plan, nil
+}
+
+// Apply submits the changes of the plan in order, in change batches of at
+// most batchSize changes commented with comment. It stops at the first batch Route53 rejects;
+// batches submitted before it stay applied. done, if not nil, is called
+// after every batch with its changes, change ID and error, e.g. to audit
+// them. The submitted batches are recorded in plan.Batches.
+func Apply(ctx context.Context, r53 *service.DNSService, plan *model.SyncPlan, batchSize int, comment string,
+	done func(batch []model.PlannedChange, changeID string, err error)) error {
+	batches := split(plan.Changes, batchSize)
+	for i, batch := range batches {
+		changes := make([]model.RecordChange, len(batch))
+		for j, c := range batch {
+			changes[j] = recordChange(c)
+		}
+		batchComment := comment
+		if len(batches) > 1 {
+			batchComment += fmt.Sprintf(" (batch %d of %d)", i+1, len(batches))
+		}
+		changeID, err := r53.ApplyChanges(ctx, plan.ZoneID, batchComment, changes)
+		if done != nil {
+			done(batch, changeID, err)
+		}
+		if err != nil {
+			return fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err)
+		}
+		plan.Batches = append(plan.Batches, model.ChangeInfo{ID: changeID, Status: "PENDING", SubmittedAt: time.Now().UTC()})
+	}
+	return nil
+}
+
+// AuditEntry fills in an audit entry for a planned change, worded like the
+// same change made in the web UI.
+func AuditEntry(entry model.AuditEntry, zoneID string, c model.PlannedChange) model.AuditEntry {
+	rec := c.Record()
+	entry.ZoneID, entry.RecordName, entry.RecordType = zoneID, rec.Name, rec.Type
+	entry.Before, entry.After = model.AuditState(c.Before), model.AuditState(c.After)
+	switch c.Action {
+	case "CREATE":
+		entry.Action = "create_record"
+		if rec.IsAlias {
+			entry.Detail = fmt.Sprintf("alias=%s", rec.AliasTarget)
+		} else {
+			entry.Detail = fmt.Sprintf("values=[%s] ttl=%d", strings.Join(rec.Values, ", "), rec.TTL)
+		}
+	case "UPDATE":
+		entry.Action = "edit_record"
+		entry.Detail = Summary(c)
+	case "DELETE":
+		entry.Action = "delete_record"
+		if rec.IsAlias {
+			entry.Detail = fmt.Sprintf("alias=%s", rec.AliasTarget)
+		} else {
+			entry.Detail = fmt.Sprintf("ttl=%d values=[%s]", rec.TTL, strings.Join(rec.Values, ", "))
+		}
+	}
+	return entry
+}
+
+// Summary lists the fields an UPDATE changes, as "field: old -> new".
+func Summary(c model.PlannedChange) string {
+	e := model.AuditEntry{Before: model.AuditState(c.Before), After: model.AuditState(c.After)}
+	var parts []string
+	for _, ch := range e.Changes() {
+		parts = append(parts, fmt.Sprintf("%s: %s -> %s", ch.Field, ch.Old, ch.New))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func recordChange(c model.PlannedChange) model.RecordChange {
+	switch c.Action {
+	case "CREATE":
+		return model.RecordChange{Action: "CREATE", Record: *c.After}
+	case "UPDATE":
+		return model.RecordChange{Action: "UPSERT", Record: *c.After}
+	}
+	return model.RecordChange{Action: "DELETE", Record: *c.Before}
+}
+
+// split cuts changes into batches of at most size changes that also stay
+// within the Route53 limits on records and characters per batch.
+func split(changes []model.PlannedChange, size int) [][]model.PlannedChange {
+	var batches [][]model.PlannedChange
+	var batch []model.PlannedChange
+	records, chars := 0, 0
+	for _, c := range changes {
+		n, l := weight(c)
+		if len(batch) > 0 && (len(batch) == size || records+n > maxBatchRecords || chars+l > maxBatchChars) {
+			batches = append(batches, batch)
+			batch, records, chars = nil, 0, 0
+		}
+		batch = append(batch, c)
+		records += n
+		chars += l
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// weight returns what a change counts towards the batch limits.
+func weight(c model.PlannedChange) (records, chars int) {
+	rec := c.Record()
+	records = max(len(rec.Values), 1)
+	for _, v := range rec.Values {
+		chars += len(v)
+	}
+	if c.Action == "UPDATE" {
+		records, chars = 2*records, 2*chars
+	}
+	return records, chars
+}
+
+// Replace returns records with before taken out and after put in, matching
+// record sets by name, type and set identifier. Either may be nil, for a
+// creation or a deletion.
+func Replace(records []model.DNSRecord, before, after *model.DNSRecord) []model.DNSRecord {
+	out := make([]model.DNSRecord, 0, len(records)+1)
+	for _, rec := range records {
+		key := recordKey(rec)
+		if (before == nil || key != recordKey(*before)) && (after == nil || key != recordKey(*after)) {
+			out = append(out, rec)
+		}
+	}
+	if after != nil {
+		out = append(out, *after)
+	}
+	return out
+}
+
+// recordKey identifies a record set within its zone. Route53 escapes, as in
+// "\052.example.com.", are decoded so they match the file's "*".
+func recordKey(rec model.DNSRecord) string {
+	return strings.ToLower(strings.TrimSuffix(service.UnescapeRoute53(rec.Name), ".")) + " " + rec.Type + " " + rec.SetIdentifier
+}
+
+// equal reports whether two record sets with the same key are the same,
+// ignoring the order of values and the case of names.
+func equal(a, b model.DNSRecord) bool {
+	return canonical(a).Version() == canonical(b).Version()
+}
+
+func canonical(rec model.DNSRecord) model.DNSRecord {
+	rec.Name = strings.ToLower(service.UnescapeRoute53(rec.Name))
+	if rec.IsAlias {
+		rec.TTL, rec.Values = 0, nil
+		rec.AliasTarget = strings.ToLower(strings.TrimSuffix(service.UnescapeRoute53(rec.AliasTarget), ".") + ".")
+	} else {
+		rec.Values = slices.Clone(rec.Values)
+		slices.Sort(rec.Values)
+	}
+	if g := rec.GeoLocation; g != nil && *g == (model.GeoLocation{}) {
+		rec.GeoLocation = nil
+	}
+	return rec
+}
+
+// zoneOwned reports whether rec is the apex SOA or NS record set, which
+// Route53 creates with the hosted zone.
+func zoneOwned(rec model.DNSRecord, zoneName string) bool {
+	return (rec.Type == "SOA" || rec.Type == "NS") && strings.EqualFold(rec.Name, zoneName)
+}
+
+// describe names a record set in messages.
+func describe(rec model.DNSRecord) string {
+	s := rec.Name + " " + rec.Type
+	if rec.SetIdentifier != "" {
+		s += " (" + rec.SetIdentifier + ")"
+	}
+	return s
+}
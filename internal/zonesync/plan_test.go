@@ -0,0 +1,197 @@
+package zonesync
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+var testZone = model.HostedZone{ID: "Z1", Name: "example.com."}
+
+func a(name string, ttl int64, values ...string) model.DNSRecord {
+	return model.DNSRecord{Name: name, Type: "A", TTL: ttl, Values: values}
+}
+
+// actions renders a plan's changes as "ACTION name type".
+func actions(plan *model.SyncPlan) []string {
+	var out []string
+	for _, c := range plan.Changes {
+		rec := c.Record()
+		out = append(out, c.Action+" "+rec.Name+" "+rec.Type)
+	}
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	apex := []model.DNSRecord{
+		{Name: "example.com.", Type: "SOA", TTL: 900, Values: []string{"ns-1.awsdns-01.org. hostmaster.example.com. 1 7200 900 1209600 86400"}},
+		{Name: "example.com.", Type: "NS", TTL: 172800, Values: []string{"ns-1.awsdns-01.org."}},
+	}
+	tests := []struct {
+		name        string
+		desired     []model.DNSRecord
+		current     []model.DNSRecord
+		managedOnly bool
+		want        []string
+		unchanged   int
+		kept        int
+	}{
+		{
+			name:    "create",
+			desired: []model.DNSRecord{a("www.example.com.", 300, "192.0.2.1")},
+			want:    []string{"CREATE www.example.com. A"},
+		},
+		{
+			name:      "unchanged ignores value order and name case",
+			desired:   []model.DNSRecord{a("www.example.com.", 300, "192.0.2.2", "192.0.2.1")},
+			current:   []model.DNSRecord{a("WWW.example.com.", 300, "192.0.2.1", "192.0.2.2")},
+			unchanged: 1,
+		},
+		{
+			name:    "update",
+			desired: []model.DNSRecord{a("www.example.com.", 60, "192.0.2.1")},
+			current: []model.DNSRecord{a("www.example.com.", 300, "192.0.2.1")},
+			want:    []string{"UPDATE www.example.com. A"},
+		},
+		{
+			name:    "escaped wildcard from Route53 matches the file",
+			desired: []model.DNSRecord{a("*.example.com.", 300, "192.0.2.1")},
+			current: []model.DNSRecord{a(`\052.example.com.`, 300, "192.0.2.1")},
+			// Not a CREATE of "*" plus a DELETE of "\052"
+			unchanged: 1,
+		},
+		{
+			name:    "escaped wildcard updated",
+			desired: []model.DNSRecord{a("*.example.com.", 60, "192.0.2.1")},
+			current: []model.DNSRecord{a(`\052.example.com.`, 300, "192.0.2.1")},
+			want:    []string{`UPDATE \052.example.com. A`},
+		},
+		{
+			name:    "delete unlisted, apex SOA and NS kept",
+			current: append(apex[:2:2], a("old.example.com.", 300, "192.0.2.9")),
+			want:    []string{"DELETE old.example.com. A"},
+		},
+		{
+			name:        "managed only keeps unlisted",
+			current:     []model.DNSRecord{a("old.example.com.", 300, "192.0.2.9")},
+			managedOnly: true,
+			kept:        1,
+		},
+		{
+			name: "deletes first, aliases last",
+			desired: []model.DNSRecord{
+				{Name: "api.example.com.", Type: "A", IsAlias: true, AliasTarget: "lb.example.com."},
+				a("lb.example.com.", 300, "192.0.2.3"),
+				{Name: "www.example.com.", Type: "CNAME", TTL: 300, Values: []string{"lb.example.com."}},
+			},
+			current: []model.DNSRecord{a("www.example.com.", 300, "192.0.2.1")},
+			want: []string{
+				"DELETE www.example.com. A",
+				"CREATE lb.example.com. A",
+				"CREATE www.example.com. CNAME",
+				"CREATE api.example.com. A",
+			},
+		},
+		{
+			name: "set identifiers are separate record sets",
+			desired: []model.DNSRecord{
+				{Name: "www.example.com.", Type: "A", TTL: 300, Values: []string{"192.0.2.1"}, SetIdentifier: "eu"},
+				{Name: "www.example.com.", Type: "A", TTL: 300, Values: []string{"192.0.2.2"}, SetIdentifier: "us"},
+			},
+			current: []model.DNSRecord{
+				{Name: "www.example.com.", Type: "A", TTL: 300, Values: []string{"192.0.2.1"}, SetIdentifier: "eu"},
+			},
+			unchanged: 1,
+			want:      []string{"CREATE www.example.com. A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := Diff(testZone, tt.desired, tt.current, tt.managedOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := actions(plan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+			if plan.Unchanged != tt.unchanged || plan.Kept != tt.kept {
+				t.Errorf("unchanged, kept = %d, %d, want %d, %d", plan.Unchanged, plan.Kept, tt.unchanged, tt.kept)
+			}
+		})
+	}
+}
+
+func TestDiffAliasDefaultsToZone(t *testing.T) {
+	desired := []model.DNSRecord{{Name: "api.example.com.", Type: "A", IsAlias: true, AliasTarget: "lb.example.com."}}
+	current := []model.DNSRecord{{Name: "api.example.com.", Type: "A", IsAlias: true, AliasTarget: "LB.example.com.", AliasZoneID: "Z1"}}
+	plan, err := Diff(testZone, desired, current, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 || plan.Unchanged != 1 {
+		t.Errorf("changes = %q, unchanged = %d", actions(plan), plan.Unchanged)
+	}
+}
+
+func TestDiffDuplicate(t *testing.T) {
+	desired := []model.DNSRecord{a("*.example.com.", 300, "192.0.2.1"), a(`\052.example.com.`, 300, "192.0.2.2")}
+	if _, err := Diff(testZone, desired, nil, false); !errors.Is(err, ErrDuplicateRecord) {
+		t.Errorf("err = %v, want ErrDuplicateRecord", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	create := func(i int, values ...string) model.PlannedChange {
+		rec := a(fmt.Sprintf("h%d.example.com.", i), 300, values...)
+		return model.PlannedChange{Action: "CREATE", After: &rec}
+	}
+	update := func(i int, values ...string) model.PlannedChange {
+		rec := a(fmt.Sprintf("h%d.example.com.", i), 300, values...)
+		return model.PlannedChange{Action: "UPDATE", Before: &rec, After: &rec}
+	}
+	many := func(n int, v string) []string {
+		values := make([]string, n)
+		for i := range values {
+			values[i] = v
+		}
+		return values
+	}
+	long := strings.Repeat("x", 255)
+
+	tests := []struct {
+		name    string
+		changes []model.PlannedChange
+		size    int
+		want    []int
+	}{
+		{"empty", nil, 10, nil},
+		{"by size", []model.PlannedChange{create(1, "a"), create(2, "a"), create(3, "a")}, 2, []int{2, 1}},
+		{"by records", []model.PlannedChange{create(1, many(600, "a")...), create(2, many(600, "a")...)}, 100, []int{1, 1}},
+		// An UPSERT counts twice: 2 x 400 + 400 > 1000
+		{"updates count twice", []model.PlannedChange{update(1, many(400, "a")...), create(2, many(400, "a")...)}, 100, []int{1, 1}},
+		{"by characters", []model.PlannedChange{create(1, many(100, long)...), create(2, many(100, long)...)}, 100, []int{1, 1}},
+		{"oversized change alone", []model.PlannedChange{create(1, many(1200, "a")...), create(2, "a")}, 100, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			total := 0
+			for _, b := range split(tt.changes, tt.size) {
+				got = append(got, len(b))
+				for _, c := range b {
+					if c.Record().Name != tt.changes[total].Record().Name {
+						t.Fatalf("change %d out of order", total)
+					}
+					total++
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batch sizes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Package zonesync brings hosted zones in line with declarative zone files.
+// A zone file lists the desired record sets of one zone in YAML, keyed by
+// name relative to the zone like octoDNS:
+//
+//	'':
+//	  - type: A
+//	    values: [192.0.2.10]
+//	  - type: MX
+//	    values: ["10 mail.example.com."]
+//	www:
+//	  type: CNAME
+//	  ttl: 3600
+//	  value: example.com.
+//	api:
+//	  - type: A
+//	    set_identifier: eu
+//	    region: eu-west-1
+//	    alias:
+//	      name: my-lb-123.eu-west-1.elb.amazonaws.com.
+//	      zone_id: Z32O12XQLNTSW2
+//	      evaluate_target_health: true
+//
+// Values are written as Route53 expects them. Diff compares a file with
+// the zone and Apply submits the differences in change batches; Marshal
+// writes a zone's record sets as a file.
+package zonesync
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"ns116/internal/model"
+)
+
+// defaultTTL applies to record sets without a ttl, as in the web UI.
+const defaultTTL = 300
+
+// fileRecord is one record set in a zone file.
+type fileRecord struct {
+	Type   string     `yaml:"type"`
+	TTL    int64      `yaml:"ttl,omitempty"`
+	Value  string     `yaml:"value,omitempty"`
+	Values []string   `yaml:"values,omitempty"`
+	Alias  *fileAlias `yaml:"alias,omitempty"`
+
+	// SetIdentifier and the fields after it make up the routing policy
+	SetIdentifier string   `yaml:"set_identifier,omitempty"`
+	Weight        *int64   `yaml:"weight,omitempty"`
+	Region        string   `yaml:"region,omitempty"`
+	Failover      string   `yaml:"failover,omitempty"`
+	GeoLocation   *fileGeo `yaml:"geolocation,omitempty"`
+	MultiValue    bool     `yaml:"multivalue,omitempty"`
+	HealthCheckID string   `yaml:"health_check_id,omitempty"`
+}
+
+// fileAlias points an alias record set at an AWS resource or another record
+// set. ZoneID defaults to the zone of the file.
+type fileAlias struct {
+	Name                 string `yaml:"name"`
+	ZoneID               string `yaml:"zone_id,omitempty"`
+	EvaluateTargetHealth bool   `yaml:"evaluate_target_health,omitempty"`
+}
+
+type fileGeo struct {
+	Continent   string `yaml:"continent,omitempty"`
+	Country     string `yaml:"country,omitempty"`
+	Subdivision string `yaml:"subdivision,omitempty"`
+}
+
+// Parse reads the record sets of a zone file for the zone zoneName. Errors
+// name the line of the offending entry.
+func Parse(data []byte, zoneName string) ([]model.DNSRecord, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected record names mapped to record sets", root.Line)
+	}
+
+	var records []model.DNSRecord
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		name, err := qualify(key.Value, zoneName)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", key.Line, err)
+		}
+		items := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			items = value.Content
+		}
+		for _, item := range items {
+			if err := checkFields(item, reflect.TypeOf(fileRecord{})); err != nil {
+				return nil, err
+			}
+			var fr fileRecord
+			if err := item.Decode(&fr); err != nil {
+				var te *yaml.TypeError
+				if errors.As(err, &te) {
+					return nil, errors.New(strings.Join(te.Errors, "; "))
+				}
+				return nil, err
+			}
+			rec, err := fr.record(name)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", item.Line, strings.TrimSuffix(name, "."), err)
+			}
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// Marshal writes the record sets of zone as a zone file in canonical form:
+// the apex first and other names in order, record sets sorted by type and
+// set identifier, values sorted. The same record sets always give the same
+// file, so it diffs well under version control. Alias targets in zone
+// itself leave out zone_id.
+func Marshal(zone model.HostedZone, records []model.DNSRecord) ([]byte, error) {
+	records = slices.Clone(records)
+	slices.SortFunc(records, func(a, b model.DNSRecord) int {
+		return cmp.Or(
+			cmp.Compare(relative(a.Name, zone.Name), relative(b.Name, zone.Name)),
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.SetIdentifier, b.SetIdentifier))
+	})
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	var sets *yaml.Node
+	for i, rec := range records {
+		name := relative(rec.Name, zone.Name)
+		if i == 0 || name != relative(records[i-1].Name, zone.Name) {
+			sets = &yaml.Node{Kind: yaml.SequenceNode}
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, sets)
+		}
+		var n yaml.Node
+		if err := n.Encode(toFileRecord(rec, zone.ID)); err != nil {
+			return nil, err
+		}
+		sets.Content = append(sets.Content, &n)
+	}
+	// A name with a single record set maps to it directly
+	for i := 1; i < len(root.Content); i += 2 {
+		if seq := root.Content[i]; len(seq.Content) == 1 {
+			root.Content[i] = seq.Content[0]
+		}
+	}
+
+	if len(root.Content) == 0 {
+		return []byte("{}\n"), nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// relative returns name relative to the zone zoneName, "" for the apex.
+func relative(name, zoneName string) string {
+	if strings.EqualFold(name, zoneName) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSuffix(name, ".")), "."+strings.ToLower(strings.TrimSuffix(zoneName, ".")))
+}
+
+func toFileRecord(rec model.DNSRecord, zoneID string) fileRecord {
+	fr := fileRecord{
+		Type:          rec.Type,
+		SetIdentifier: rec.SetIdentifier,
+		Weight:        rec.Weight,
+		Region:        rec.Region,
+		Failover:      rec.Failover,
+		MultiValue:    rec.MultiValueAnswer,
+		HealthCheckID: rec.HealthCheckID,
+	}
+	if g := rec.GeoLocation; g != nil && *g != (model.GeoLocation{}) {
+		fr.GeoLocation = &fileGeo{Continent: g.ContinentCode, Country: g.CountryCode, Subdivision: g.SubdivisionCode}
+	}
+	if rec.IsAlias {
+		fr.Alias = &fileAlias{Name: rec.AliasTarget, EvaluateTargetHealth: rec.EvaluateTargetHealth}
+		if rec.AliasZoneID != zoneID {
+			fr.Alias.ZoneID = rec.AliasZoneID
+		}
+		return fr
+	}
+	fr.TTL = rec.TTL
+	if len(rec.Values) == 1 {
+		fr.Value = rec.Values[0]
+	} else {
+		fr.Values = slices.Sorted(slices.Values(rec.Values))
+	}
+	return fr
+}
+
+// qualify turns a name of a zone file into the fully-qualified name. ""
+// and "@" stand for the zone apex.
+func qualify(name, zoneName string) (string, error) {
+	switch {
+	case name == "" || name == "@":
+		return zoneName, nil
+	case strings.HasSuffix(name, "."):
+		return "", fmt.Errorf("%q: names are relative to the zone and must not end with a dot", name)
+	}
+	return strings.ToLower(name) + "." + zoneName, nil
+}
+
+func (fr fileRecord) record(name string) (model.DNSRecord, error) {
+	rec := model.DNSRecord{
+		Name:          name,
+		Type:          strings.ToUpper(strings.TrimSpace(fr.Type)),
+		TTL:           fr.TTL,
+		Values:        fr.Values,
+		SetIdentifier: fr.SetIdentifier,
+		RoutingPolicy: model.RoutingPolicy{
+			Weight:           fr.Weight,
+			Region:           fr.Region,
+			Failover:         strings.ToUpper(fr.Failover),
+			MultiValueAnswer: fr.MultiValue,
+			HealthCheckID:    fr.HealthCheckID,
+		},
+	}
+	if g := fr.GeoLocation; g != nil {
+		rec.GeoLocation = &model.GeoLocation{ContinentCode: g.Continent, CountryCode: g.Country, SubdivisionCode: g.Subdivision}
+	}
+	if rec.Type == "" {
+		return rec, errors.New("type is required")
+	}
+	if fr.Value != "" {
+		if len(fr.Values) > 0 {
+			return rec, errors.New("set either value or values, not both")
+		}
+		rec.Values = []string{fr.Value}
+	}
+
+	if fr.Alias != nil {
+		if len(rec.Values) > 0 || rec.TTL != 0 {
+			return rec, errors.New("alias record sets take no values or ttl")
+		}
+		if fr.Alias.Name == "" {
+			return rec, errors.New("alias.name is required")
+		}
+		rec.IsAlias = true
+		rec.AliasTarget = strings.TrimSuffix(fr.Alias.Name, ".") + "."
+		rec.AliasZoneID = fr.Alias.ZoneID
+		rec.EvaluateTargetHealth = fr.Alias.EvaluateTargetHealth
+		return rec, nil
+	}
+	if len(rec.Values) == 0 {
+		return rec, errors.New("at least one value is required")
+	}
+	if rec.TTL < 0 {
+		return rec, errors.New("ttl must not be negative")
+	}
+	if rec.TTL == 0 {
+		rec.TTL = defaultTTL
+	}
+	return rec, nil
+}
+
+// checkFields reports the first key of the mapping n, or of the mappings
+// within it, that is not a field of t. Decoding alone would ignore it, so a
+// misspelt key would silently drop a setting.
+func checkFields(n *yaml.Node, t reflect.Type) error {
+	if n.Kind != yaml.MappingNode {
+		return nil // left for Decode to reject with a type error
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		f, ok := fieldByTag(t, key.Value)
+		if !ok {
+			return fmt.Errorf("line %d: unknown field %q", key.Line, key.Value)
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			if err := checkFields(n.Content[i+1], ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
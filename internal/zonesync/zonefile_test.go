@@ -0,0 +1,113 @@
+package zonesync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"ns116/internal/model"
+)
+
+func TestParse(t *testing.T) {
+	data := `
+'':
+  - type: A
+    values: [192.0.2.10]
+  - type: mx
+    values: ["10 mail.example.com."]
+www:
+  type: CNAME
+  ttl: 3600
+  value: example.com.
+'*':
+  type: A
+  value: 192.0.2.11
+API:
+  - type: A
+    set_identifier: eu
+    region: eu-west-1
+    alias:
+      name: my-lb.eu-west-1.elb.amazonaws.com
+      zone_id: Z32O12XQLNTSW2
+      evaluate_target_health: true
+`
+	got, err := Parse([]byte(data), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.DNSRecord{
+		{Name: "example.com.", Type: "A", TTL: 300, Values: []string{"192.0.2.10"}},
+		{Name: "example.com.", Type: "MX", TTL: 300, Values: []string{"10 mail.example.com."}},
+		{Name: "www.example.com.", Type: "CNAME", TTL: 3600, Values: []string{"example.com."}},
+		{Name: "*.example.com.", Type: "A", TTL: 300, Values: []string{"192.0.2.11"}},
+		{Name: "api.example.com.", Type: "A", IsAlias: true, AliasTarget: "my-lb.eu-west-1.elb.amazonaws.com.",
+			AliasZoneID: "Z32O12XQLNTSW2", EvaluateTargetHealth: true, SetIdentifier: "eu",
+			RoutingPolicy: model.RoutingPolicy{Region: "eu-west-1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not a mapping", "- www", "line 1: expected record names"},
+		{"absolute name", "www.example.com.:\n  type: A\n  value: 192.0.2.1", "must not end with a dot"},
+		{"unknown field", "www:\n  type: A\n  valeu: 192.0.2.1", `line 3: unknown field "valeu"`},
+		{"unknown nested field", "www:\n  type: A\n  alias:\n    nmae: x.", `line 4: unknown field "nmae"`},
+		{"missing type", "www:\n  value: 192.0.2.1", "line 2: www.example.com: type is required"},
+		{"value and values", "www:\n  type: A\n  value: 192.0.2.1\n  values: [192.0.2.2]", "set either value or values"},
+		{"no values", "www:\n  type: A", "at least one value is required"},
+		{"alias with ttl", "www:\n  type: A\n  ttl: 60\n  alias:\n    name: x.", "alias record sets take no values or ttl"},
+		{"negative ttl", "www:\n  type: A\n  ttl: -1\n  value: 192.0.2.1", "ttl must not be negative"},
+		{"wrong type", "www:\n  type: A\n  ttl: soon\n  value: 192.0.2.1", "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data), "example.com.")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	got, err := Parse(nil, "example.com.")
+	if err != nil || got != nil {
+		t.Errorf("Parse(nil) = %v, %v", got, err)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	records := []model.DNSRecord{
+		{Name: "www.example.com.", Type: "A", TTL: 300, Values: []string{"192.0.2.2", "192.0.2.1"}},
+		{Name: "example.com.", Type: "TXT", TTL: 300, Values: []string{`"v=spf1 -all"`}},
+		{Name: "api.example.com.", Type: "A", IsAlias: true, AliasTarget: "www.example.com.", AliasZoneID: "Z1"},
+	}
+	data, err := Marshal(testZone, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "'':") {
+		t.Errorf("apex not first:\n%s", data)
+	}
+	if strings.Contains(string(data), "Z1") {
+		t.Errorf("alias into the same zone keeps zone_id:\n%s", data)
+	}
+	parsed, err := Parse(data, testZone.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := Diff(testZone, parsed, records, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 || plan.Unchanged != len(records) {
+		t.Errorf("round trip changes = %q\n%s", actions(plan), data)
+	}
+}
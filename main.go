@@ -1,35 +1,62 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"ns116/internal/cli"
 	"ns116/internal/config"
+	"ns116/internal/logging"
 	"ns116/internal/server"
 )
 
 var version = "dev"
 
 func main() {
-	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	configPath := flag.String("config", "config.yaml", "Path to configuration file (empty to configure from NS116_* variables only)")
+	flag.Usage = func() {
+		cli.Usage(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		status := cli.Run(ctx, cli.Env{ConfigPath: *configPath, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}, flag.Args())
+		stop()
+		os.Exit(status)
+	}
+
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	logging.Setup(cfg.Log)
 
-	log.Println("=== NS116 — DNS Manager ===")
-	log.Printf("Version: %s", version)
-	log.Printf("Listening on %s:%d", cfg.Server.Host, cfg.Server.Port)
+	slog.Info("NS116 DNS Manager starting", "version", version, "zones", zoneScope(cfg))
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "effective configuration", cfg.Summary()...)
 
-	if len(cfg.HostedZones) > 0 {
-		log.Printf("Managing %d hosted zone(s)", len(cfg.HostedZones))
-	} else {
-		log.Println("Managing ALL hosted zones in the account")
+	if err := server.Start(cfg, *configPath, version); err != nil {
+		fatal("server error", err)
 	}
+}
 
-	if err := server.Start(cfg, version); err != nil {
-		log.Fatalf("Server error: %v", err)
+func zoneScope(cfg *config.Config) string {
+	if len(cfg.HostedZones) == 0 {
+		return "all"
 	}
+	return strconv.Itoa(len(cfg.HostedZones))
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
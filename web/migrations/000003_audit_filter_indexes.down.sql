@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_audit_detail_trgm;
+DROP INDEX IF EXISTS idx_audit_ip;
+DROP INDEX IF EXISTS idx_audit_record;
+DROP INDEX IF EXISTS idx_audit_zone;
+DROP INDEX IF EXISTS idx_audit_action;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_dns_cache_record;
+DELETE FROM dns_cache;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dns_cache_record ON dns_cache(zone_id, record_name, record_type);
+
+ALTER TABLE dns_cache DROP COLUMN IF EXISTS evaluate_target_health;
+ALTER TABLE dns_cache DROP COLUMN IF EXISTS routing_json;
+ALTER TABLE dns_cache DROP COLUMN IF EXISTS set_identifier;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS notification_outbox;
+DROP TABLE IF EXISTS notification_subscriptions;
+DELETE FROM settings WHERE key = 'notification_digest';
+ALTER TABLE users DROP COLUMN IF EXISTS email;
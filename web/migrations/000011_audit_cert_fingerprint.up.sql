@@ -0,0 +1,3 @@
+-- SHA-256 fingerprint of the verified TLS client certificate presented on
+-- the request that produced the entry.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS cert_fingerprint TEXT;
@@ -0,0 +1,13 @@
+-- Zone allowlist entries, labels and ownership maintained from the admin
+-- UI. Entries in config hosted_zones take precedence over rows here.
+CREATE TABLE IF NOT EXISTS managed_zones (
+    zone_id     TEXT PRIMARY KEY,
+    name        TEXT    NOT NULL DEFAULT '',
+    enabled     INTEGER NOT NULL DEFAULT 0,
+    label       TEXT    NOT NULL DEFAULT '',
+    description TEXT    NOT NULL DEFAULT '',
+    owner       TEXT    NOT NULL DEFAULT '',
+    tags        TEXT    NOT NULL DEFAULT '', -- comma-separated
+    updated_by  TEXT    NOT NULL DEFAULT '',
+    updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);